@@ -0,0 +1,124 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// Notification bookkeeping is buffered and written to the bot database in batches
+const (
+	bookkeepingBatchSize     = 100
+	bookkeepingFlushInterval = 2 * time.Second
+)
+
+var (
+	pendingEncounters = make(map[string]Encounter)
+	pendingMessages   []Message
+	// Sent notifications to store, nil for a retracted notification that has to be removed
+	pendingSentNotifications = make(map[sentNotificationKey]*SentNotification)
+	bookkeepingMutex         sync.Mutex
+	// Held while a flush writes, so a flush returns only once earlier ones have been written
+	flushMutex sync.Mutex
+)
+
+type sentNotificationKey struct {
+	EncounterID string
+	ChatID      int64
+}
+
+func recordEncounter(encounter Encounter) {
+	bookkeepingMutex.Lock()
+	pendingEncounters[encounter.ID] = encounter
+	full := len(pendingEncounters) >= bookkeepingBatchSize
+	bookkeepingMutex.Unlock()
+	if full {
+		flushBookkeeping()
+	}
+}
+
+func recordMessage(message Message) {
+	bookkeepingMutex.Lock()
+	pendingMessages = append(pendingMessages, message)
+	full := len(pendingMessages) >= bookkeepingBatchSize
+	bookkeepingMutex.Unlock()
+	if full {
+		flushBookkeeping()
+	}
+}
+
+func recordSentNotification(notification SentNotification) {
+	bookkeepingMutex.Lock()
+	pendingSentNotifications[sentNotificationKey{notification.EncounterID, notification.ChatID}] = &notification
+	full := len(pendingSentNotifications) >= bookkeepingBatchSize
+	bookkeepingMutex.Unlock()
+	if full {
+		flushBookkeeping()
+	}
+}
+
+// Remove a sent notification with the next flush. It replaces a buffered one, so the removal
+// is written in order with the notification and cannot be overwritten by a flush in flight.
+func forgetSentNotification(encounterID string, chatID int64) {
+	bookkeepingMutex.Lock()
+	pendingSentNotifications[sentNotificationKey{encounterID, chatID}] = nil
+	bookkeepingMutex.Unlock()
+}
+
+// Write all buffered encounters, messages and sent notifications to the bot database
+func flushBookkeeping() {
+	flushMutex.Lock()
+	defer flushMutex.Unlock()
+
+	bookkeepingMutex.Lock()
+	encounters := make([]Encounter, 0, len(pendingEncounters))
+	for _, encounter := range pendingEncounters {
+		encounters = append(encounters, encounter)
+	}
+	messages := pendingMessages
+	notifications := make([]SentNotification, 0, len(pendingSentNotifications))
+	var retracted []sentNotificationKey
+	for key, notification := range pendingSentNotifications {
+		if notification == nil {
+			retracted = append(retracted, key)
+			continue
+		}
+		notifications = append(notifications, *notification)
+	}
+	pendingEncounters = make(map[string]Encounter)
+	pendingMessages = nil
+	pendingSentNotifications = make(map[sentNotificationKey]*SentNotification)
+	bookkeepingMutex.Unlock()
+
+	if len(encounters) > 0 {
+		if err := dbConfig.Clauses(clause.OnConflict{UpdateAll: true}).CreateInBatches(encounters, bookkeepingBatchSize).Error; err != nil {
+			log.Printf("❌ Failed to store %d encounters: %v", len(encounters), err)
+		}
+	}
+	if len(messages) > 0 {
+		if err := dbConfig.CreateInBatches(messages, bookkeepingBatchSize).Error; err != nil {
+			log.Printf("❌ Failed to store %d messages: %v", len(messages), err)
+		}
+	}
+	if len(notifications) > 0 {
+		if err := dbConfig.Clauses(clause.OnConflict{UpdateAll: true}).CreateInBatches(notifications, bookkeepingBatchSize).Error; err != nil {
+			log.Printf("❌ Failed to store %d sent notifications: %v", len(notifications), err)
+		}
+	}
+	for _, key := range retracted {
+		if err := dbConfig.Where("encounter_id = ? AND chat_id = ?", key.EncounterID, key.ChatID).Delete(&SentNotification{}).Error; err != nil {
+			log.Printf("❌ Failed to remove sent notification of %s to %d: %v", key.EncounterID, key.ChatID, err)
+		}
+	}
+}
+
+func startBookkeeping() {
+	go func() {
+		for {
+			time.Sleep(bookkeepingFlushInterval)
+			flushBookkeeping()
+		}
+	}()
+}
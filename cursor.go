@@ -0,0 +1,39 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	// Encounters updated shortly before the cursor are polled again, as rows can be committed
+	// after newer ones. Encounters that have already been notified are skipped by their fingerprint.
+	pollOverlap = 10
+	// Pokémon despawn within an hour, older cursors are moved forward
+	pollMaxLookback = 60 * 60
+)
+
+// Number of encounters fetched per query, a variable so tests can page small fixtures
+var pollPageSize = 1000
+
+// ScannerCursor is the high-water mark of the updated timestamps polled from a scanner database source
+type ScannerCursor struct {
+	Name    string `gorm:"primaryKey;size:64"`
+	Updated int    `gorm:"not null;size:32"`
+}
+
+// Get the polling cursor, starting at the current time if none is stored
+func getScannerCursor(name string) int {
+	now := int(time.Now().Unix())
+	var cursor ScannerCursor
+	if dbConfig.Where("name = ?", name).Limit(1).Find(&cursor).RowsAffected == 0 {
+		return now - 30
+	}
+	return max(cursor.Updated, now-pollMaxLookback)
+}
+
+func setScannerCursor(name string, updated int) {
+	if err := dbConfig.Save(&ScannerCursor{Name: name, Updated: updated}).Error; err != nil {
+		log.Printf("❌ Failed to store %s cursor: %v", name, err)
+	}
+}
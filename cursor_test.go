@@ -0,0 +1,160 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// pagingAdapter records the pages fetched from a scanner database
+type pagingAdapter struct {
+	ScannerAdapter
+	pages []string
+}
+
+func (adapter *pagingAdapter) Encounters(db *gorm.DB, updated int, id string, now int64, limit int) ([]EncounterData, error) {
+	encounters, err := adapter.ScannerAdapter.Encounters(db, updated, id, now, limit)
+	adapter.pages = append(adapter.pages, encounterIDs(encounters))
+	return encounters, err
+}
+
+// Poll the Golbat scanner fixture as a database source, the fixture is moved to the current time
+func setupPollingTest(t *testing.T) (*ScannerSource, *pagingAdapter, int) {
+	t.Helper()
+	setupTestDB(t)
+	previousUsers, previousSent, previousReceived := users, sentNotifications, receivedEncounters
+	t.Cleanup(func() { users, sentNotifications, receivedEncounters = previousUsers, previousSent, previousReceived })
+	users = FilteredUsers{}
+	sentNotifications = NewSentNotificationCache(10)
+	receivedEncounters = make(map[string]receivedEncounter)
+
+	db := openTestScannerDB(t, "golbat")
+	offset := int(time.Now().Unix()) - testScannerNow
+	if err := db.Exec("UPDATE pokemon SET updated = updated + ?, expire_timestamp = expire_timestamp + ?", offset, offset).Error; err != nil {
+		t.Fatal(err)
+	}
+	adapter := &pagingAdapter{ScannerAdapter: GolbatAdapter{}}
+	return &ScannerSource{Name: "berlin", Type: "database", db: db, adapter: adapter, cursor: "berlin"}, adapter, offset
+}
+
+// Add a copy of the fixture Pokémon 1005 committed late with another ID and updated timestamp
+func insertLatePokemon(t *testing.T, db *gorm.DB, id string, updated int) {
+	t.Helper()
+	for _, statement := range []string{
+		"CREATE TEMP TABLE late AS SELECT * FROM pokemon WHERE id = '1005'",
+		"UPDATE late SET id = ?, updated = ?",
+		"INSERT INTO pokemon SELECT * FROM late",
+		"DROP TABLE late",
+	} {
+		var args []interface{}
+		if strings.HasPrefix(statement, "UPDATE") {
+			args = []interface{}{id, updated}
+		}
+		if err := db.Exec(statement, args...).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Poll a source and get the IDs of the encounters received for the first time
+func pollTestSource(source *ScannerSource) string {
+	known := make(map[string]bool, len(receivedEncounters))
+	for id := range receivedEncounters {
+		known[id] = true
+	}
+	processEncounters(source)
+	var received []string
+	for id := range receivedEncounters {
+		if !known[id] {
+			received = append(received, id)
+		}
+	}
+	sort.Strings(received)
+	return strings.Join(received, ",")
+}
+
+func getStoredCursor(t *testing.T, name string) int {
+	t.Helper()
+	var cursor ScannerCursor
+	if err := dbConfig.Where("name = ?", name).First(&cursor).Error; err != nil {
+		t.Fatal(err)
+	}
+	return cursor.Updated
+}
+
+func TestPollingStartsAtTheCurrentTime(t *testing.T) {
+	source, _, _ := setupPollingTest(t)
+
+	// All fixture Pokémon have been updated more than 30 seconds ago
+	if received := pollTestSource(source); received != "" {
+		t.Errorf("received %s without a stored cursor", received)
+	}
+}
+
+func TestPollingResumesFromStoredCursor(t *testing.T) {
+	source, _, offset := setupPollingTest(t)
+	// Stored before a restart, after Pikachu 1001 has been polled
+	setScannerCursor(source.cursor, 1760779930+offset)
+
+	if received := pollTestSource(source); received != "1002,1005" {
+		t.Errorf("received %s", received)
+	}
+	if cursor := getStoredCursor(t, source.cursor); cursor != 1760779950+offset {
+		t.Errorf("cursor %d, expected the last updated Pokémon %d", cursor, 1760779950+offset)
+	}
+}
+
+func TestPollingRefetchesLateRows(t *testing.T) {
+	source, _, offset := setupPollingTest(t)
+	cursor := 1760779950 + offset
+	setScannerCursor(source.cursor, cursor)
+	if received := pollTestSource(source); received != "1002,1005" {
+		t.Fatalf("received %s", received)
+	}
+
+	// Committed after the poll, but updated before the cursor
+	insertLatePokemon(t, source.db, "1006", cursor-pollOverlap/2)
+	// Rows committed later than the overlap are missed
+	insertLatePokemon(t, source.db, "1007", cursor-pollOverlap-1)
+	if received := pollTestSource(source); received != "1006" {
+		t.Errorf("received %s, expected the late Pokémon within the overlap", received)
+	}
+	if stored := getStoredCursor(t, source.cursor); stored != cursor {
+		t.Errorf("cursor moved from %d to %d by a late Pokémon", cursor, stored)
+	}
+}
+
+func TestPollingPagesWithinTheSameSecond(t *testing.T) {
+	source, adapter, offset := setupPollingTest(t)
+	previous := pollPageSize
+	pollPageSize = 2
+	t.Cleanup(func() { pollPageSize = previous })
+	setScannerCursor(source.cursor, 1760779900+offset)
+	// Three Pokémon updated in the same second are split across pages
+	insertLatePokemon(t, source.db, "1006", 1760779950+offset)
+
+	if received := pollTestSource(source); received != "1001,1002,1005,1006" {
+		t.Errorf("received %s", received)
+	}
+	if pages := strings.Join(adapter.pages, " | "); pages != "1001,1002 | 1005,1006 | " {
+		t.Errorf("pages %s", pages)
+	}
+}
+
+func TestPollingCursorIsClamped(t *testing.T) {
+	source, _, _ := setupPollingTest(t)
+	now := int(time.Now().Unix())
+	setScannerCursor(source.cursor, now-2*pollMaxLookback)
+
+	if cursor := getScannerCursor(source.cursor); cursor < now-pollMaxLookback || cursor > now-pollMaxLookback+1 {
+		t.Errorf("cursor %d, expected %d", cursor, now-pollMaxLookback)
+	}
+	// Pokémon updated before the lookback are not fetched anymore
+	insertLatePokemon(t, source.db, "1006", now-pollMaxLookback-pollOverlap-1)
+	if received := pollTestSource(source); received != "1001,1002,1005" {
+		t.Errorf("received %s", received)
+	}
+}
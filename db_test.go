@@ -0,0 +1,201 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+// Use a new bot database for a test, MySQL if TEST_MYSQL_DSN is set and SQLite otherwise
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	if os.Getenv("TEST_MYSQL_DSN") != "" {
+		setupTestMySQL(t)
+		return
+	}
+	setupTestSQLite(t)
+}
+
+// Use a new SQLite bot database for a test
+func setupTestSQLite(t *testing.T) {
+	t.Helper()
+	t.Setenv("BOT_DB_DRIVER", "sqlite")
+	t.Setenv("BOT_DB_PATH", filepath.Join(t.TempDir(), "pogobot.db"))
+	db, err := openConfigDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	useTestDB(t, db)
+}
+
+// Use a MySQL bot database for a test, skipped unless TEST_MYSQL_DSN is set.
+// All tables of the bot are dropped, so the DSN has to point to a database used only for tests.
+func setupTestMySQL(t *testing.T) {
+	t.Helper()
+	dsn := os.Getenv("TEST_MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("TEST_MYSQL_DSN is not set")
+	}
+	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Migrator().DropTable(configModels...); err != nil {
+		t.Fatal(err)
+	}
+	useTestDB(t, db)
+	t.Cleanup(func() { db.Migrator().DropTable(configModels...) })
+}
+
+func useTestDB(t *testing.T, db *gorm.DB) {
+	t.Helper()
+	if err := db.AutoMigrate(configModels...); err != nil {
+		t.Fatal(err)
+	}
+	previous := dbConfig
+	dbConfig = db
+	t.Cleanup(func() {
+		dbConfig = previous
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+}
+
+// Run a test against each supported bot database
+func forEachTestDB(t *testing.T, test func(t *testing.T)) {
+	t.Run("sqlite", func(t *testing.T) {
+		setupTestSQLite(t)
+		test(t)
+	})
+	t.Run("mysql", func(t *testing.T) {
+		setupTestMySQL(t)
+		test(t)
+	})
+}
+
+func TestMigrateConfigDB(t *testing.T) {
+	forEachTestDB(t, func(t *testing.T) {
+		// Migrating an existing database changes nothing
+		if err := dbConfig.AutoMigrate(configModels...); err != nil {
+			t.Fatal(err)
+		}
+		for _, model := range configModels {
+			if !dbConfig.Migrator().HasTable(model) {
+				t.Errorf("table of %T missing", model)
+			}
+		}
+		if !dbConfig.Migrator().HasIndex(&SentNotification{}, "Expiration") {
+			t.Error("index of the sent notification expiration missing")
+		}
+	})
+}
+
+func TestUserAndSubscriptionCRUD(t *testing.T) {
+	forEachTestDB(t, func(t *testing.T) {
+		// Columns not set on creation get their database defaults
+		if err := dbConfig.Create(&User{ID: 1001, Language: "en", Latitude: 52.5163, Longitude: 13.3777}).Error; err != nil {
+			t.Fatal(err)
+		}
+		getUsersByFilters()
+		user, exists := users.All[1001]
+		if !exists || !user.Notify || !user.Cleanup || !user.Stickers || !user.QuietHundo || user.HundoIV || user.MinIV != 0 {
+			t.Fatalf("user %+v", user)
+		}
+		if user.Latitude != float32(52.5163) || user.Longitude != float32(13.3777) {
+			t.Errorf("location %f, %f", user.Latitude, user.Longitude)
+		}
+
+		updateUserPreference(1001, "MinIV", 90)
+		updateUserPreference(1001, "HundoIV", true)
+		updateUserPreference(1001, "Timezone", "Europe/Berlin")
+		if user := users.All[1001]; user.MinIV != 90 || !user.HundoIV || user.Timezone != "Europe/Berlin" || len(users.HundoIV) != 1 {
+			t.Errorf("updated user %+v", user)
+		}
+
+		addSubscription(1001, 25, 80, 20, 1000)
+		addSubscription(1001, 147, 0, 0, 0)
+		// Subscribing again replaces the filters
+		addSubscription(1001, 25, 95, 30, 500)
+		var subscriptions []Subscription
+		dbConfig.Order("pokemon_id").Find(&subscriptions)
+		if len(subscriptions) != 2 || subscriptions[0] != (Subscription{UserID: 1001, PokemonID: 25, MinIV: 95, MinLevel: 30, MaxDistance: 500}) {
+			t.Fatalf("subscriptions %+v", subscriptions)
+		}
+		if active := activeSubscriptions[25]; len(active) != 1 || active[0].MinIV != 95 {
+			t.Errorf("active subscriptions %+v", active)
+		}
+
+		dbConfig.Where("user_id = ? AND pokemon_id = ?", 1001, 25).Delete(&Subscription{})
+		getActiveSubscriptions()
+		if _, exists := activeSubscriptions[25]; exists || len(activeSubscriptions[147]) != 1 {
+			t.Errorf("active subscriptions after deletion %+v", activeSubscriptions)
+		}
+
+		dbConfig.Where("user_id = ?", 1001).Delete(&Subscription{})
+		dbConfig.Delete(&User{}, 1001)
+		getUsersByFilters()
+		var count int64
+		dbConfig.Model(&Subscription{}).Count(&count)
+		if _, exists := users.All[1001]; exists || count != 0 {
+			t.Errorf("user or %d subscriptions left", count)
+		}
+	})
+}
+
+func TestSentNotificationUpsert(t *testing.T) {
+	forEachTestDB(t, func(t *testing.T) {
+		recordEncounter(Encounter{ID: "1001", Expiration: 1760781800})
+		recordSentNotification(SentNotification{EncounterID: "1001", ChatID: 1, Fingerprint: "first", Expiration: 1760781800})
+		recordSentNotification(SentNotification{EncounterID: "1001", ChatID: 2, Fingerprint: "first", Expiration: 1760781800})
+		flushBookkeeping()
+
+		// Updates of an encounter replace the stored fingerprint
+		recordEncounter(Encounter{ID: "1001", Expiration: 1760782000})
+		recordSentNotification(SentNotification{EncounterID: "1001", ChatID: 1, Fingerprint: "second", Expiration: 1760782000})
+		flushBookkeeping()
+
+		var sent []SentNotification
+		dbConfig.Order("chat_id").Find(&sent)
+		if len(sent) != 2 || sent[0].Fingerprint != "second" || sent[0].Expiration != 1760782000 || sent[1].Fingerprint != "first" {
+			t.Errorf("sent notifications %+v", sent)
+		}
+		var encounters []Encounter
+		dbConfig.Find(&encounters)
+		if len(encounters) != 1 || encounters[0].Expiration != 1760782000 {
+			t.Errorf("encounters %+v", encounters)
+		}
+
+		forgetSentNotification("1001", 2)
+		flushBookkeeping()
+		var count int64
+		dbConfig.Model(&SentNotification{}).Count(&count)
+		if count != 1 {
+			t.Errorf("%d sent notifications after forgetting one", count)
+		}
+	})
+}
+
+func TestCountDeadLetters(t *testing.T) {
+	forEachTestDB(t, func(t *testing.T) {
+		if counts := countDeadLetters(); len(counts) != 0 {
+			t.Errorf("dead letters %v", counts)
+		}
+		first := WebhookSubscriber{URL: "https://first.example.com", Secret: "a"}
+		second := WebhookSubscriber{URL: "https://second.example.com", Secret: "b"}
+		dbConfig.Create(&first)
+		dbConfig.Create(&second)
+		for i := 0; i < 3; i++ {
+			dbConfig.Create(&WebhookDeadLetter{SubscriberID: first.ID, Payload: `{"type":"pokemon"}`, Error: "timeout", Attempts: 5, FailedAt: 1760780000})
+		}
+		dbConfig.Create(&WebhookDeadLetter{SubscriberID: second.ID, Payload: `{"type":"pokemon"}`, Error: "410 Gone", Attempts: 1, FailedAt: 1760780000})
+
+		counts := countDeadLetters()
+		if len(counts) != 2 || counts[first.ID] != 3 || counts[second.ID] != 1 {
+			t.Errorf("dead letters %v", counts)
+		}
+	})
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"container/list"
+	"log"
+	"sync"
+)
+
+// SentNotification is the fingerprint of the notification sent to a chat about an encounter.
+// It is stored so notifications are not sent again after a restart.
+type SentNotification struct {
+	EncounterID string `gorm:"primaryKey;size:25"`
+	ChatID      int64  `gorm:"primaryKey;autoIncrement:false"`
+	Fingerprint string `gorm:"not null;size:40"`
+	Expiration  int    `gorm:"index;not null;size:32"`
+}
+
+// SentNotificationCache keeps the sent notifications of the most recently used encounters.
+// Encounters missing in the cache are loaded from the bot database, encounters without
+// any sent notification are cached as well to avoid repeated lookups.
+type SentNotificationCache struct {
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List // Most recently used encounter first
+	mutex    sync.Mutex
+}
+
+type sentCacheEntry struct {
+	encounterID string
+	chats       map[int64]string // Fingerprint per chat
+}
+
+func NewSentNotificationCache(capacity int) *SentNotificationCache {
+	return &SentNotificationCache{
+		capacity: max(capacity, 1),
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Add an encounter to the cache, evicting the least recently used one if it is full
+func (c *SentNotificationCache) add(encounterID string, chats map[int64]string) map[int64]string {
+	c.entries[encounterID] = c.order.PushFront(&sentCacheEntry{encounterID: encounterID, chats: chats})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*sentCacheEntry).encounterID)
+	}
+	return chats
+}
+
+// Load the sent notifications of encounters from the bot database, after writing the buffered ones
+func loadSentNotifications(encounterIDs []string) (map[string]map[int64]string, error) {
+	flushBookkeeping()
+	var sent []SentNotification
+	if err := dbConfig.Where("encounter_id IN ?", encounterIDs).Find(&sent).Error; err != nil {
+		return nil, err
+	}
+	loaded := make(map[string]map[int64]string, len(encounterIDs))
+	for _, encounterID := range encounterIDs {
+		loaded[encounterID] = make(map[int64]string)
+	}
+	for _, notification := range sent {
+		loaded[notification.EncounterID][notification.ChatID] = notification.Fingerprint
+	}
+	return loaded, nil
+}
+
+// Call a function with the sent notifications of an encounter while holding the mutex.
+// Missing encounters are loaded without holding it, so a slow database does not block other
+// lookups. An encounter loaded by another caller in the meantime is used instead.
+func (c *SentNotificationCache) withChats(encounterID string, use func(chats map[int64]string)) {
+	c.mutex.Lock()
+	if element, exists := c.entries[encounterID]; exists {
+		c.order.MoveToFront(element)
+		use(element.Value.(*sentCacheEntry).chats)
+		c.mutex.Unlock()
+		return
+	}
+	c.mutex.Unlock()
+
+	loaded, err := loadSentNotifications([]string{encounterID})
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element, exists := c.entries[encounterID]; exists {
+		c.order.MoveToFront(element)
+		use(element.Value.(*sentCacheEntry).chats)
+		return
+	}
+	if err != nil {
+		// Not cached, so the encounter is loaded again with the next lookup
+		log.Printf("❌ Failed to load sent notifications of %s: %v", encounterID, err)
+		use(make(map[int64]string))
+		return
+	}
+	use(c.add(encounterID, loaded[encounterID]))
+}
+
+// Load the sent notifications of all given encounters missing in the cache with a single query
+func (c *SentNotificationCache) Preload(encounterIDs []string) {
+	c.mutex.Lock()
+	var missing []string
+	for _, encounterID := range encounterIDs {
+		if _, exists := c.entries[encounterID]; !exists {
+			missing = append(missing, encounterID)
+		}
+	}
+	c.mutex.Unlock()
+	if len(missing) == 0 {
+		return
+	}
+
+	loaded, err := loadSentNotifications(missing)
+	if err != nil {
+		log.Printf("❌ Failed to load sent notifications: %v", err)
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for encounterID, chats := range loaded {
+		if _, exists := c.entries[encounterID]; !exists {
+			c.add(encounterID, chats)
+		}
+	}
+}
+
+// Get the fingerprint of the notification sent to a chat about an encounter
+func (c *SentNotificationCache) Get(encounterID string, chatID int64) (string, bool) {
+	var fingerprint string
+	var exists bool
+	c.withChats(encounterID, func(chats map[int64]string) {
+		fingerprint, exists = chats[chatID]
+	})
+	return fingerprint, exists
+}
+
+// Get the fingerprints of all notifications sent about an encounter per chat
+func (c *SentNotificationCache) Chats(encounterID string) map[int64]string {
+	chats := make(map[int64]string)
+	c.withChats(encounterID, func(sent map[int64]string) {
+		for chatID, fingerprint := range sent {
+			chats[chatID] = fingerprint
+		}
+	})
+	return chats
+}
+
+// Record a notification sent to a chat
+func (c *SentNotificationCache) Set(encounter EncounterData, chatID int64, fingerprint string) {
+	c.withChats(encounter.ID, func(chats map[int64]string) {
+		chats[chatID] = fingerprint
+	})
+	recordSentNotification(SentNotification{EncounterID: encounter.ID, ChatID: chatID, Fingerprint: fingerprint, Expiration: *encounter.ExpireTimestamp})
+}
+
+// Forget a notification sent to a chat, e.g. after it has been retracted
+func (c *SentNotificationCache) Delete(encounterID string, chatID int64) {
+	c.withChats(encounterID, func(chats map[int64]string) {
+		delete(chats, chatID)
+	})
+	forgetSentNotification(encounterID, chatID)
+}
+
+// Remove an expired encounter from the cache, its stored notifications are removed by the cleanup
+func (c *SentNotificationCache) Forget(encounterID string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element, exists := c.entries[encounterID]; exists {
+		c.order.Remove(element)
+		delete(c.entries, encounterID)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func newDedupeEncounter(id string) EncounterData {
+	encounter := getSampleEncounter()
+	encounter.ID = id
+	return encounter
+}
+
+func TestSentNotificationCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	setupTestDB(t)
+	cache := NewSentNotificationCache(2)
+
+	cache.Set(newDedupeEncounter("a"), 1, "fa")
+	cache.Set(newDedupeEncounter("b"), 1, "fb")
+	// a is used more recently than b
+	cache.Get("a", 1)
+	cache.Set(newDedupeEncounter("c"), 1, "fc")
+
+	cache.mutex.Lock()
+	_, cachedA := cache.entries["a"]
+	_, cachedB := cache.entries["b"]
+	_, cachedC := cache.entries["c"]
+	size := cache.order.Len()
+	cache.mutex.Unlock()
+	if !cachedA || cachedB || !cachedC || size != 2 {
+		t.Errorf("cached a %v, b %v, c %v, %d entries", cachedA, cachedB, cachedC, size)
+	}
+
+	// Evicted encounters are loaded from the bot database again
+	if fingerprint, sent := cache.Get("b", 1); !sent || fingerprint != "fb" {
+		t.Errorf("evicted encounter: fingerprint %q, sent %v", fingerprint, sent)
+	}
+}
+
+func TestSentNotificationCacheAfterRestart(t *testing.T) {
+	setupTestDB(t)
+	cache := NewSentNotificationCache(10)
+	cache.Set(newDedupeEncounter("a"), 1, "fa")
+	cache.Set(newDedupeEncounter("a"), 2, "fa")
+	cache.Delete("a", 2)
+
+	// The notifications are written on the lookup of the new cache
+	restarted := NewSentNotificationCache(10)
+	if fingerprint, sent := restarted.Get("a", 1); !sent || fingerprint != "fa" {
+		t.Errorf("fingerprint %q, sent %v after a restart", fingerprint, sent)
+	}
+	if _, sent := restarted.Get("a", 2); sent {
+		t.Error("retracted notification is back after a restart")
+	}
+	restarted.Preload([]string{"a", "b"})
+	if chats := restarted.Chats("b"); len(chats) != 0 {
+		t.Errorf("chats of an unknown encounter %v", chats)
+	}
+}
+
+func TestRetractionIsNotOverwrittenByFlush(t *testing.T) {
+	setupTestDB(t)
+	cache := NewSentNotificationCache(10)
+	cache.Set(newDedupeEncounter("a"), 1, "fa")
+
+	// A flush in flight and a retraction of the notification
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		flushBookkeeping()
+	}()
+	go func() {
+		defer wg.Done()
+		cache.Delete("a", 1)
+	}()
+	wg.Wait()
+	flushBookkeeping()
+
+	var count int64
+	dbConfig.Model(&SentNotification{}).Count(&count)
+	if count != 0 {
+		t.Errorf("%d sent notifications after the retraction", count)
+	}
+}
+
+func TestNotificationIsSkippedAfterRestart(t *testing.T) {
+	setupTemplateTest(t)
+	setupTestDB(t)
+	queue := setupTestQueue(t)
+	previous := sentNotifications
+	t.Cleanup(func() { sentNotifications = previous })
+	sentNotifications = NewSentNotificationCache(10)
+	uicons = &UIcons{Base: "https://icons.example", Extension: "png"}
+	_, server := newRecordingServer(t, respondJSON(http.StatusOK, `{"id": "1"}`))
+	notifiers["discord"] = NewDiscordNotifier()
+	user := newDiscordUser(server, false)
+	encounter := getSampleEncounter()
+
+	sendEncounterNotification(user, encounter, false)
+	deliverNext(t, queue)
+
+	sentNotifications = NewSentNotificationCache(10)
+	sendEncounterNotification(user, encounter, false)
+	if queued := len(queue.lanes[PriorityHigh]) + len(queue.lanes[PriorityNormal]) + len(queue.lanes[PriorityLow]); queued != 0 {
+		t.Errorf("%d notifications queued again after a restart", queued)
+	}
+}
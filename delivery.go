@@ -0,0 +1,435 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/telebot.v3"
+)
+
+// Priority of an outbound message, higher priorities are sent first
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+)
+
+var priorityNames = map[Priority]string{
+	PriorityLow:    "low",
+	PriorityNormal: "normal",
+	PriorityHigh:   "high",
+}
+
+const maxDeliveryAttempts = 3
+
+var errQueueFull = errors.New("delivery queue is full")
+
+// RateLimitError is returned by notifiers if a service asks to retry a request later
+type RateLimitError struct {
+	RetryAfter time.Duration
+	Message    string
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limited for %s: %s", e.RetryAfter, e.Message)
+}
+
+// Delivery holds the context of a notification shared by all of its messages
+type Delivery struct {
+	EncounterID string
+	Expiration  int64
+	Priority    Priority
+	Silent      bool
+}
+
+// OutboundMessage is a single message waiting in the delivery queue, a Telegram message
+// unless it is delivered by another notifier
+type OutboundMessage struct {
+	ChatID   int64
+	What     interface{}
+	Options  *telebot.SendOptions
+	Kind     string
+	Delivery Delivery
+	Attempts int
+	Edit     *telebot.StoredMessage // Set to edit an existing message instead of sending a new one
+	// Set to get the file of a photo when it is sent. Maps need slow tile downloads, so they are
+	// rendered by the workers instead of holding up the matching.
+	PhotoFile func() telebot.File
+	// Set to deliver the message with another notifier than Telegram, e.g. a Discord webhook
+	Deliver func() error
+	// The next message of the same notification, sent right after this one
+	Then *OutboundMessage
+}
+
+// Chain the messages of a notification, so they are queued as a single job
+func chainMessages(messages ...*OutboundMessage) *OutboundMessage {
+	for i := len(messages) - 1; i > 0; i-- {
+		messages[i-1].Then = messages[i]
+	}
+	return messages[0]
+}
+
+// TokenBucket allows bursts up to its capacity and refills at a constant rate (tokens per second)
+type TokenBucket struct {
+	capacity float64
+	rate     float64
+	tokens   float64
+	last     time.Time
+}
+
+func NewTokenBucket(capacity float64, rate float64) *TokenBucket {
+	return &TokenBucket{capacity: capacity, rate: rate, tokens: capacity, last: time.Now()}
+}
+
+func (b *TokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+}
+
+// Get the time to wait until a token is available
+func (b *TokenBucket) wait(now time.Time) time.Duration {
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *TokenBucket) take(now time.Time) {
+	b.refill(now)
+	b.tokens--
+}
+
+// DeliveryQueue sends outbound messages with per-chat and global rate limits.
+// Messages are taken from the highest priority lane first and handed to a pool
+// of workers. A chat has at most one message in flight, so messages of a chat are
+// sent in the order they were queued. Messages of a chat that is rate limited or
+// busy are held back in order until the chat is ready again. The chained messages
+// of a notification are held back in front of the others once the first is sent,
+// so notifications are never interleaved.
+type DeliveryQueue struct {
+	lanes      map[Priority]chan *OutboundMessage
+	jobs       chan *OutboundMessage
+	wake       chan struct{}
+	workers    int
+	global     *TokenBucket
+	chats      map[int64]*TokenBucket
+	chatRate   float64
+	chatBurst  float64
+	blocked    map[int64]time.Time
+	inflight   map[int64]struct{}
+	backlogs   map[int64][]*OutboundMessage
+	backlogLen int
+	size       int
+	mutex      sync.Mutex
+	send       func(*OutboundMessage) (*telebot.Message, error)
+}
+
+var (
+	deliveryQueue *DeliveryQueue
+
+	queueLengthGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "bot_queue_length",
+			Help: "Number of messages waiting in the delivery queue",
+		},
+		[]string{"lane"},
+	)
+	queueDroppedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "bot_queue_dropped_total",
+			Help: "Total number of messages dropped from the delivery queue",
+		},
+		[]string{"reason"},
+	)
+	queueRetriesCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "bot_queue_retries_total",
+			Help: "Total number of message deliveries retried after a rate limit",
+		},
+	)
+)
+
+func NewDeliveryQueue(size int, workers int, globalRate float64, chatRate float64, chatBurst float64) *DeliveryQueue {
+	queue := &DeliveryQueue{
+		lanes:     make(map[Priority]chan *OutboundMessage),
+		jobs:      make(chan *OutboundMessage),
+		wake:      make(chan struct{}, 1),
+		workers:   max(workers, 1),
+		global:    NewTokenBucket(globalRate, globalRate),
+		chats:     make(map[int64]*TokenBucket),
+		chatRate:  chatRate,
+		chatBurst: chatBurst,
+		blocked:   make(map[int64]time.Time),
+		inflight:  make(map[int64]struct{}),
+		backlogs:  make(map[int64][]*OutboundMessage),
+		size:      size,
+		send:      sendOutboundMessage,
+	}
+	for priority := range priorityNames {
+		queue.lanes[priority] = make(chan *OutboundMessage, size)
+	}
+	return queue
+}
+
+func sendOutboundMessage(message *OutboundMessage) (*telebot.Message, error) {
+	if message.Deliver != nil {
+		return nil, message.Deliver()
+	}
+	what := message.What
+	if photo, ok := what.(*telebot.Photo); ok && message.PhotoFile != nil {
+		rendered := *photo
+		rendered.File = message.PhotoFile()
+		what = &rendered
+	}
+	if message.Edit != nil {
+		return bot.Edit(message.Edit, what, message.Options)
+	}
+	return bot.Send(&telebot.User{ID: message.ChatID}, what, message.Options)
+}
+
+// Add a message to the queue, fails if the lane of its priority is full
+func (q *DeliveryQueue) Enqueue(message *OutboundMessage) error {
+	select {
+	case q.lanes[message.Delivery.Priority] <- message:
+		queueLengthGauge.WithLabelValues(priorityNames[message.Delivery.Priority]).Inc()
+		return nil
+	default:
+		queueDroppedCounter.WithLabelValues("full").Inc()
+		log.Printf("❌ Failed to queue %s for %d: %v", message.Kind, message.ChatID, errQueueFull)
+		return errQueueFull
+	}
+}
+
+func (q *DeliveryQueue) Start() {
+	for i := 0; i < q.workers; i++ {
+		go func() {
+			for message := range q.jobs {
+				q.deliver(message)
+			}
+		}()
+	}
+	go func() {
+		for {
+			if message := q.next(); q.dispatch(message) {
+				q.jobs <- message
+			}
+		}
+	}()
+}
+
+func (q *DeliveryQueue) chatBucket(chatID int64) *TokenBucket {
+	bucket, exists := q.chats[chatID]
+	if !exists {
+		bucket = NewTokenBucket(q.chatBurst, q.chatRate)
+		q.chats[chatID] = bucket
+	}
+	return bucket
+}
+
+// Get the time to wait until a message can be sent to the chat.
+// Chats with a message in flight are woken up once it has been delivered.
+func (q *DeliveryQueue) chatWait(chatID int64, now time.Time) time.Duration {
+	if _, busy := q.inflight[chatID]; busy {
+		return time.Second
+	}
+	if until, exists := q.blocked[chatID]; exists {
+		if now.Before(until) {
+			return until.Sub(now)
+		}
+		delete(q.blocked, chatID)
+	}
+	return q.chatBucket(chatID).wait(now)
+}
+
+// Hold back a message until its chat is ready, new messages are dropped if the backlog is full
+func (q *DeliveryQueue) hold(message *OutboundMessage, front bool) {
+	if !front && q.backlogLen >= q.size {
+		queueDroppedCounter.WithLabelValues("full").Inc()
+		log.Printf("❌ Failed to hold %s for %d: %v", message.Kind, message.ChatID, errQueueFull)
+		return
+	}
+	if front {
+		q.backlogs[message.ChatID] = append([]*OutboundMessage{message}, q.backlogs[message.ChatID]...)
+	} else {
+		q.backlogs[message.ChatID] = append(q.backlogs[message.ChatID], message)
+	}
+	q.backlogLen++
+	queueLengthGauge.WithLabelValues("held").Inc()
+}
+
+// Take the next message from the backlog of a chat that is ready again, or from the lanes
+func (q *DeliveryQueue) next() *OutboundMessage {
+	for {
+		q.mutex.Lock()
+		now := time.Now()
+		nextReady := time.Second
+		for chatID, backlog := range q.backlogs {
+			wait := q.chatWait(chatID, now)
+			if wait > 0 {
+				nextReady = min(nextReady, wait)
+				continue
+			}
+			message := backlog[0]
+			if len(backlog) == 1 {
+				delete(q.backlogs, chatID)
+			} else {
+				q.backlogs[chatID] = backlog[1:]
+			}
+			q.backlogLen--
+			queueLengthGauge.WithLabelValues("held").Dec()
+			q.mutex.Unlock()
+			return message
+		}
+		q.mutex.Unlock()
+
+		message := q.receive(nextReady)
+		if message == nil {
+			continue
+		}
+
+		q.mutex.Lock()
+		// Keep the order of messages for chats that are held back
+		if _, held := q.backlogs[message.ChatID]; held || q.chatWait(message.ChatID, time.Now()) > 0 {
+			q.hold(message, false)
+			q.mutex.Unlock()
+			continue
+		}
+		q.mutex.Unlock()
+		return message
+	}
+}
+
+// Receive a message from the highest priority lane, waits up to timeout if all lanes are empty
+func (q *DeliveryQueue) receive(timeout time.Duration) *OutboundMessage {
+	var message *OutboundMessage
+	select {
+	case message = <-q.lanes[PriorityHigh]:
+	default:
+		select {
+		case message = <-q.lanes[PriorityHigh]:
+		case message = <-q.lanes[PriorityNormal]:
+		default:
+			select {
+			case message = <-q.lanes[PriorityHigh]:
+			case message = <-q.lanes[PriorityNormal]:
+			case message = <-q.lanes[PriorityLow]:
+			case <-q.wake:
+				return nil
+			case <-time.After(timeout):
+				return nil
+			}
+		}
+	}
+	queueLengthGauge.WithLabelValues(priorityNames[message.Delivery.Priority]).Dec()
+	return message
+}
+
+// Reserve the rate limits for a message and mark its chat as busy.
+// Returns false if the message should not be sent anymore.
+func (q *DeliveryQueue) dispatch(message *OutboundMessage) bool {
+	// Do not bother sending notifications that have expired while waiting
+	if message.Delivery.Expiration > 0 && message.Delivery.Expiration < time.Now().Unix() {
+		// The rest of the notification has expired as well
+		for ; message != nil; message = message.Then {
+			queueDroppedCounter.WithLabelValues("expired").Inc()
+		}
+		return false
+	}
+
+	q.mutex.Lock()
+	// The global rate limit is the one of the Telegram bot
+	telegram := message.Deliver == nil
+	if wait := q.global.wait(time.Now()); telegram && wait > 0 {
+		q.mutex.Unlock()
+		time.Sleep(wait)
+		q.mutex.Lock()
+	}
+	now := time.Now()
+	if telegram {
+		q.global.take(now)
+	}
+	q.chatBucket(message.ChatID).take(now)
+	q.inflight[message.ChatID] = struct{}{}
+	q.mutex.Unlock()
+	return true
+}
+
+// Send a message and release its chat, called by the workers
+func (q *DeliveryQueue) deliver(message *OutboundMessage) {
+	retry := false
+	defer func() {
+		q.mutex.Lock()
+		// Continue with the rest of the notification before other messages of the chat
+		if !retry && message.Then != nil {
+			q.hold(message.Then, true)
+		}
+		delete(q.inflight, message.ChatID)
+		q.mutex.Unlock()
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}()
+
+	sent, err := q.send(message)
+	if err != nil {
+		if retryAfter, limited := getRetryAfter(err); limited && message.Attempts+1 < maxDeliveryAttempts {
+			message.Attempts++
+			retry = true
+			queueRetriesCounter.Inc()
+			log.Printf("⏳ Rate limit hit for %d, retrying %s in %s", message.ChatID, message.Kind, retryAfter)
+			q.mutex.Lock()
+			q.blocked[message.ChatID] = time.Now().Add(retryAfter)
+			q.hold(message, true)
+			q.mutex.Unlock()
+			return
+		}
+		queueDroppedCounter.WithLabelValues("error").Inc()
+		log.Printf("❌ Failed to send %s: %v", message.Kind, err)
+		return
+	}
+
+	// Other notifiers count and store their messages themselves
+	if message.Deliver != nil {
+		return
+	}
+	messagesCounter.Inc()
+	if message.Delivery.EncounterID != "" && message.Edit == nil {
+		// Store message ID for cleanup
+		recordMessage(Message{ChatID: message.ChatID, MessageID: sent.ID, EncounterID: message.Delivery.EncounterID, Kind: message.Kind})
+	}
+}
+
+// Get the time to wait before retrying a message that hit a rate limit
+func getRetryAfter(err error) (time.Duration, bool) {
+	var floodErr telebot.FloodError
+	if errors.As(err, &floodErr) {
+		return time.Duration(floodErr.RetryAfter) * time.Second, true
+	}
+	var rateLimitErr *RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		return rateLimitErr.RetryAfter, true
+	}
+	return 0, false
+}
+
+// Get the delivery priority of a notification: 100% IV first, channels last
+func getNotificationPriority(user User, encounter EncounterData) Priority {
+	if encounter.IV != nil && *encounter.IV == 100 {
+		return PriorityHigh
+	}
+	if isChannel(user) {
+		return PriorityLow
+	}
+	return PriorityNormal
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/telebot.v3"
+)
+
+// Use a delivery queue that is not started, messages are delivered with deliverNext
+func setupTestQueue(t *testing.T) *DeliveryQueue {
+	t.Helper()
+	previous := deliveryQueue
+	deliveryQueue = NewDeliveryQueue(10, 1, 25, 100, 100)
+	t.Cleanup(func() { deliveryQueue = previous })
+	return deliveryQueue
+}
+
+// Deliver the next queued message like a worker does
+func deliverNext(t *testing.T, queue *DeliveryQueue) *OutboundMessage {
+	t.Helper()
+	next := make(chan *OutboundMessage, 1)
+	go func() { next <- queue.next() }()
+	select {
+	case message := <-next:
+		if !queue.dispatch(message) {
+			t.Fatalf("%s has not been dispatched", message.Kind)
+		}
+		queue.deliver(message)
+		return message
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message to deliver")
+		return nil
+	}
+}
+
+// sentMessages records the messages sent by a test queue
+type sentMessages struct {
+	mutex sync.Mutex
+	sent  []string
+}
+
+// Record the messages sent by the queue as "<chat>:<text>", the sender can fail messages by returning an error
+func recordSentMessages(queue *DeliveryQueue, fail func(message *OutboundMessage) error) *sentMessages {
+	recorder := &sentMessages{}
+	queue.send = func(message *OutboundMessage) (*telebot.Message, error) {
+		if fail != nil {
+			if err := fail(message); err != nil {
+				return nil, err
+			}
+		}
+		recorder.mutex.Lock()
+		defer recorder.mutex.Unlock()
+		recorder.sent = append(recorder.sent, fmt.Sprintf("%d:%v", message.ChatID, message.What))
+		return &telebot.Message{ID: len(recorder.sent)}, nil
+	}
+	return recorder
+}
+
+func (r *sentMessages) get() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]string(nil), r.sent...)
+}
+
+func newTestMessage(chatID int64, text string, priority Priority) *OutboundMessage {
+	return &OutboundMessage{ChatID: chatID, What: text, Kind: "message", Delivery: Delivery{Priority: priority}}
+}
+
+// Build the sticker, location and text of a notification as a chain
+func newTestNotificationChain(chatID int64, name string, priority Priority) *OutboundMessage {
+	return chainMessages(
+		newTestMessage(chatID, name+"-sticker", priority),
+		newTestMessage(chatID, name+"-location", priority),
+		newTestMessage(chatID, name+"-text", priority),
+	)
+}
+
+func expectSent(t *testing.T, recorder *sentMessages, expected ...string) {
+	t.Helper()
+	sent := recorder.get()
+	if fmt.Sprint(sent) != fmt.Sprint(expected) {
+		t.Errorf("sent %v, expected %v", sent, expected)
+	}
+}
+
+func TestChainMessages(t *testing.T) {
+	head := newTestNotificationChain(1, "a", PriorityNormal)
+	var kinds []interface{}
+	for message := head; message != nil; message = message.Then {
+		kinds = append(kinds, message.What)
+	}
+	if fmt.Sprint(kinds) != "[a-sticker a-location a-text]" {
+		t.Errorf("chain %v", kinds)
+	}
+}
+
+func TestNotificationsOfAChatAreNotInterleaved(t *testing.T) {
+	queue := setupTestQueue(t)
+	recorder := recordSentMessages(queue, nil)
+
+	if err := queue.Enqueue(newTestNotificationChain(1, "a", PriorityNormal)); err != nil {
+		t.Fatal(err)
+	}
+	deliverNext(t, queue)
+	// A hundo for the same chat arrives while the first notification is being sent
+	if err := queue.Enqueue(newTestNotificationChain(1, "b", PriorityHigh)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		deliverNext(t, queue)
+	}
+	expectSent(t, recorder, "1:a-sticker", "1:a-location", "1:a-text", "1:b-sticker", "1:b-location", "1:b-text")
+}
+
+func TestNotificationIsQueuedAsASingleJob(t *testing.T) {
+	queue := NewDeliveryQueue(1, 1, 25, 100, 100)
+	recordSentMessages(queue, nil)
+
+	if err := queue.Enqueue(newTestNotificationChain(1, "a", PriorityNormal)); err != nil {
+		t.Fatalf("a notification takes a single place in the lane: %v", err)
+	}
+	// The lane is full, the next notification is dropped as a whole
+	if err := queue.Enqueue(newTestNotificationChain(2, "b", PriorityNormal)); err != errQueueFull {
+		t.Errorf("error %v, expected %v", err, errQueueFull)
+	}
+}
+
+func TestNotificationContinuesAfterRetry(t *testing.T) {
+	queue := setupTestQueue(t)
+	limited := false
+	recorder := recordSentMessages(queue, func(message *OutboundMessage) error {
+		// Rate limit the location once
+		if message.What == "a-location" && !limited {
+			limited = true
+			return &RateLimitError{RetryAfter: 10 * time.Millisecond, Message: "slow down"}
+		}
+		return nil
+	})
+
+	queue.Enqueue(newTestNotificationChain(1, "a", PriorityNormal))
+	deliverNext(t, queue)
+	queue.Enqueue(newTestMessage(1, "b-text", PriorityHigh))
+	for i := 0; i < 4; i++ {
+		deliverNext(t, queue)
+	}
+	expectSent(t, recorder, "1:a-sticker", "1:a-location", "1:a-text", "1:b-text")
+}
+
+func TestHigherPrioritiesAreSentFirst(t *testing.T) {
+	queue := setupTestQueue(t)
+	recorder := recordSentMessages(queue, nil)
+
+	queue.Enqueue(newTestMessage(1, "low", PriorityLow))
+	queue.Enqueue(newTestMessage(2, "normal", PriorityNormal))
+	queue.Enqueue(newTestMessage(3, "high", PriorityHigh))
+	for i := 0; i < 3; i++ {
+		deliverNext(t, queue)
+	}
+	expectSent(t, recorder, "3:high", "2:normal", "1:low")
+}
+
+func TestMessagesOfAChatKeepTheirOrder(t *testing.T) {
+	queue := setupTestQueue(t)
+	recorder := recordSentMessages(queue, nil)
+
+	queue.Enqueue(newTestMessage(1, "first", PriorityNormal))
+	deliverNext(t, queue)
+	// The chat is rate limited, its messages are held back in order
+	queue.mutex.Lock()
+	queue.blocked[1] = time.Now().Add(50 * time.Millisecond)
+	queue.mutex.Unlock()
+	queue.Enqueue(newTestMessage(1, "second", PriorityNormal))
+	queue.Enqueue(newTestMessage(1, "third", PriorityNormal))
+	for i := 0; i < 2; i++ {
+		deliverNext(t, queue)
+	}
+	expectSent(t, recorder, "1:first", "1:second", "1:third")
+}
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	bucket := NewTokenBucket(2, 1)
+	bucket.last = now
+
+	for i := 0; i < 2; i++ {
+		if wait := bucket.wait(now); wait != 0 {
+			t.Fatalf("burst token %d: wait %s", i, wait)
+		}
+		bucket.take(now)
+	}
+	if wait := bucket.wait(now); wait != time.Second {
+		t.Errorf("wait %s after the burst, expected 1s", wait)
+	}
+	if wait := bucket.wait(now.Add(500 * time.Millisecond)); wait != 500*time.Millisecond {
+		t.Errorf("wait %s after half a second, expected 500ms", wait)
+	}
+	// Tokens do not pile up beyond the capacity
+	if bucket.wait(now.Add(time.Hour)); bucket.tokens != 2 {
+		t.Errorf("%v tokens after an hour, expected 2", bucket.tokens)
+	}
+}
+
+func TestChatRateLimitLetsOtherChatsPass(t *testing.T) {
+	previous := deliveryQueue
+	t.Cleanup(func() { deliveryQueue = previous })
+	// One message per chat at a time, refilled after 50ms
+	queue := NewDeliveryQueue(10, 1, 25, 20, 1)
+	deliveryQueue = queue
+	recorder := recordSentMessages(queue, nil)
+
+	queue.Enqueue(newTestMessage(1, "a1", PriorityNormal))
+	queue.Enqueue(newTestMessage(1, "a2", PriorityNormal))
+	queue.Enqueue(newTestMessage(2, "b1", PriorityNormal))
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		deliverNext(t, queue)
+	}
+	expectSent(t, recorder, "1:a1", "2:b1", "1:a2")
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("second message of a chat sent after %s", elapsed)
+	}
+}
+
+func TestGlobalRateLimitAppliesToTelegramOnly(t *testing.T) {
+	queue := setupTestQueue(t)
+	recordSentMessages(queue, nil)
+	queue.global = NewTokenBucket(1, 20)
+
+	if !queue.dispatch(newTestMessage(1, "first", PriorityNormal)) {
+		t.Fatal("message has not been dispatched")
+	}
+	// Other notifiers have their own limits
+	start := time.Now()
+	external := &OutboundMessage{ChatID: 2, Kind: "discord", Deliver: func() error { return nil }}
+	queue.dispatch(external)
+	if time.Since(start) > 20*time.Millisecond {
+		t.Errorf("external message waited %s for the Telegram rate limit", time.Since(start))
+	}
+	start = time.Now()
+	queue.dispatch(newTestMessage(3, "second", PriorityNormal))
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("second Telegram message dispatched after %s", elapsed)
+	}
+}
+
+func TestFloodErrorIsRetriedAfterTheGivenTime(t *testing.T) {
+	queue := setupTestQueue(t)
+	flooded := false
+	recorder := recordSentMessages(queue, func(message *OutboundMessage) error {
+		if !flooded {
+			flooded = true
+			return telebot.FloodError{RetryAfter: 3}
+		}
+		return nil
+	})
+
+	queue.Enqueue(newTestMessage(1, "hundo", PriorityHigh))
+	message := deliverNext(t, queue)
+	queue.mutex.Lock()
+	blocked, held := queue.blocked[1], queue.backlogs[1]
+	queue.mutex.Unlock()
+	if wait := time.Until(blocked); wait < 2*time.Second || wait > 3*time.Second {
+		t.Errorf("chat blocked for %s, expected 3s", wait)
+	}
+	if len(held) != 1 || held[0] != message || message.Attempts != 1 {
+		t.Fatalf("held back %v after %d attempts", held, message.Attempts)
+	}
+	expectSent(t, recorder)
+
+	// Skip the wait
+	queue.mutex.Lock()
+	queue.blocked[1] = time.Now()
+	queue.mutex.Unlock()
+	deliverNext(t, queue)
+	expectSent(t, recorder, "1:hundo")
+}
+
+func TestRateLimitedMessageIsDroppedAfterMaxAttempts(t *testing.T) {
+	queue := setupTestQueue(t)
+	attempts := 0
+	recordSentMessages(queue, func(message *OutboundMessage) error {
+		attempts++
+		return &RateLimitError{RetryAfter: time.Millisecond, Message: "slow down"}
+	})
+
+	queue.Enqueue(newTestMessage(1, "hundo", PriorityHigh))
+	for i := 0; i < maxDeliveryAttempts; i++ {
+		deliverNext(t, queue)
+	}
+	queue.mutex.Lock()
+	held := len(queue.backlogs[1])
+	queue.mutex.Unlock()
+	if attempts != maxDeliveryAttempts || held != 0 {
+		t.Errorf("%d attempts, %d messages held back", attempts, held)
+	}
+}
+
+func TestExpiredMessagesAreDropped(t *testing.T) {
+	queue := setupTestQueue(t)
+	recorder := recordSentMessages(queue, nil)
+
+	expired := newTestNotificationChain(1, "a", PriorityNormal)
+	expired.Delivery.Expiration = time.Now().Unix() - 1
+	if queue.dispatch(expired) {
+		t.Error("expired message has been dispatched")
+	}
+	queue.mutex.Lock()
+	_, inflight := queue.inflight[1]
+	queue.mutex.Unlock()
+	if inflight {
+		t.Error("chat of an expired message is busy")
+	}
+
+	active := newTestMessage(1, "b", PriorityNormal)
+	active.Delivery.Expiration = time.Now().Unix() + 60
+	queue.Enqueue(active)
+	deliverNext(t, queue)
+	expectSent(t, recorder, "1:b")
+}
+
+func TestFullQueueDoesNotMarkNotificationAsSent(t *testing.T) {
+	setupTemplateTest(t)
+	setupTestDB(t)
+	previousQueue, previousSent := deliveryQueue, sentNotifications
+	t.Cleanup(func() { deliveryQueue, sentNotifications = previousQueue, previousSent })
+	sentNotifications = NewSentNotificationCache(10)
+	uicons = &UIcons{Base: "https://icons.example", Extension: "png"}
+	// A queue without room for any message
+	deliveryQueue = NewDeliveryQueue(0, 1, 25, 100, 100)
+	_, server := newRecordingServer(t, respondJSON(200, `{"id": "1"}`))
+	notifiers["discord"] = NewDiscordNotifier()
+	user := newDiscordUser(server, false)
+	encounter := getSampleEncounter()
+
+	sendEncounterNotification(user, encounter, false)
+	if _, sent := sentNotifications.Get(encounter.ID, user.ID); sent {
+		t.Fatal("dropped notification has been marked as sent")
+	}
+
+	// Sent on the next poll
+	queue := setupTestQueue(t)
+	sendEncounterNotification(user, encounter, false)
+	if _, sent := sentNotifications.Get(encounter.ID, user.ID); !sent {
+		t.Error("queued notification has not been marked as sent")
+	}
+	if message := deliverNext(t, queue); message.Kind != "discord" {
+		t.Errorf("delivered %s", message.Kind)
+	}
+}
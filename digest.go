@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/telebot.v3"
+)
+
+// Maximal number of Pokémon listed in a digest, also limited by the inline keyboard size
+const digestMaxEntries = 20
+
+// Digest intervals offered in the settings, in minutes
+var digestIntervals = []int{15, 30, 60}
+
+var (
+	// Matched encounters per user waiting for the next digest
+	digestBuffer = make(map[int64]map[string]EncounterData)
+	// Start of the interval a digest has last been sent for, per user
+	lastDigest  = make(map[int64]time.Time)
+	digestMutex sync.Mutex
+)
+
+// Collect a matched encounter for the next digest of a user
+func addToDigest(user User, encounter EncounterData) {
+	if _, sent := sentNotifications.Get(encounter.ID, user.ID); sent {
+		return
+	}
+	digestMutex.Lock()
+	defer digestMutex.Unlock()
+	if digestBuffer[user.ID] == nil {
+		digestBuffer[user.ID] = make(map[string]EncounterData)
+	}
+	// Keep the latest data of an encounter
+	digestBuffer[user.ID][encounter.ID] = encounter
+}
+
+func clearDigest(userID int64) {
+	digestMutex.Lock()
+	defer digestMutex.Unlock()
+	delete(digestBuffer, userID)
+	delete(lastDigest, userID)
+}
+
+func setDigestInterval(userID int64, minutes int) {
+	updateUserPreference(userID, "DigestInterval", minutes)
+	clearDigest(userID)
+}
+
+// Parse a digest interval in minutes, "off" disables the digest
+func parseDigestInterval(input string) (int, error) {
+	if input == "off" {
+		return 0, nil
+	}
+	minutes, err := strconv.Atoi(strings.TrimSuffix(input, "m"))
+	if err != nil || minutes < 0 || minutes > 24*60 {
+		return 0, fmt.Errorf("invalid digest interval: %s", input)
+	}
+	return minutes, nil
+}
+
+func formatDigestInterval(minutes int, language string) string {
+	if minutes == 0 {
+		return boolToEmoji(false)
+	}
+	return fmt.Sprintf(getTranslation("every %d min", language), minutes)
+}
+
+func buildDigestButtons(language string) *telebot.ReplyMarkup {
+	var intervalButtons []telebot.InlineButton
+	for _, minutes := range digestIntervals {
+		intervalButtons = append(intervalButtons, telebot.InlineButton{Text: fmt.Sprintf("%d min", minutes), Unique: "digest", Data: strconv.Itoa(minutes)})
+	}
+	btnOff := telebot.InlineButton{Text: getTranslation("🔔 Notify about every Pokémon", language), Unique: "digest", Data: "off"}
+	btnClose := telebot.InlineButton{Text: getTranslation("Close", language), Unique: "close"}
+	return &telebot.ReplyMarkup{InlineKeyboard: [][]telebot.InlineButton{intervalButtons, {btnOff}, {btnClose}}}
+}
+
+// Send the digests of all users whose interval has passed.
+// Encounters that have expired while buffered are dropped.
+func flushDigests() {
+	now := time.Now()
+	digestMutex.Lock()
+	due := make(map[int64][]EncounterData)
+	for userID, buffered := range digestBuffer {
+		user, exists := users.All[userID]
+		if !exists || user.DigestInterval == 0 {
+			delete(digestBuffer, userID)
+			continue
+		}
+		for encounterID, encounter := range buffered {
+			if int64(*encounter.ExpireTimestamp) <= now.Unix() {
+				delete(buffered, encounterID)
+			}
+		}
+
+		// Digests are sent at the start of each interval, e.g. every full quarter hour
+		slot := now.Truncate(time.Duration(user.DigestInterval) * time.Minute)
+		last, known := lastDigest[userID]
+		if !known {
+			lastDigest[userID] = slot
+			continue
+		}
+		if !slot.After(last) || len(buffered) == 0 {
+			continue
+		}
+		if isQuietTime(user, now) && !user.QuietSilent {
+			continue
+		}
+		lastDigest[userID] = slot
+		for _, encounter := range buffered {
+			due[userID] = append(due[userID], encounter)
+		}
+		delete(digestBuffer, userID)
+	}
+	digestMutex.Unlock()
+
+	for userID, encounters := range due {
+		sendDigest(users.All[userID], encounters, isQuietTime(users.All[userID], now))
+	}
+}
+
+// Send a list of encounters sorted by distance with buttons to request each location
+func sendDigest(user User, encounters []EncounterData, silent bool) {
+	sort.SliceStable(encounters, func(i, j int) bool {
+		distanceI, _ := getUserDistance(user, encounters[i])
+		distanceJ, _ := getUserDistance(user, encounters[j])
+		return distanceI < distanceJ
+	})
+	log.Printf("📋 Sending digest with %d Pokémon to %d", len(encounters), user.ID)
+
+	var text strings.Builder
+	text.WriteString(formatHTML(getTranslation("📋 *Digest:* %d Pokémon", user.Language), len(encounters)) + "\n")
+	var inlineKeyboard [][]telebot.InlineButton
+	// The digest message is removed once all listed Pokémon have despawned
+	var lastExpiring EncounterData
+	for i, encounter := range encounters {
+		fingerprint := getEncounterFingerprint(encounter)
+		recordEncounter(Encounter{ID: encounter.ID, Expiration: *encounter.ExpireTimestamp})
+		sentNotifications.Set(encounter, user.ID, fingerprint)
+		if lastExpiring.ExpireTimestamp == nil || *encounter.ExpireTimestamp > *lastExpiring.ExpireTimestamp {
+			lastExpiring = encounter
+		}
+
+		if i >= digestMaxEntries {
+			continue
+		}
+		data := buildNotificationData(user, encounter)
+		text.WriteString("\n🔹 " + renderTemplate(user, "title", data) + "\n")
+		if data.HasDistance {
+			text.WriteString("📍 " + escapeHTML(formatDistance(data.Distance)) + " ")
+		}
+		text.WriteString("💨 " + escapeHTML(data.ExpireTime) + "\n")
+
+		btnLocation := telebot.InlineButton{
+			Text:   fmt.Sprintf("📍 %s %.0f%%", getPokemonName(encounter.PokemonID, user.Language), data.IV),
+			Unique: "open_map",
+			Data:   fmt.Sprintf("%.6f|%.6f", encounter.Lat, encounter.Lon),
+		}
+		inlineKeyboard = append(inlineKeyboard, []telebot.InlineButton{btnLocation})
+	}
+	if len(encounters) > digestMaxEntries {
+		text.WriteString("\n" + formatHTML(getTranslation("… and %d more", user.Language), len(encounters)-digestMaxEntries))
+	}
+
+	notificationsCounter.Inc()
+	deliveryQueue.Enqueue(&OutboundMessage{
+		ChatID: user.ID,
+		What:   text.String(),
+		Options: &telebot.SendOptions{
+			ParseMode:           telebot.ModeHTML,
+			ReplyMarkup:         &telebot.ReplyMarkup{InlineKeyboard: inlineKeyboard},
+			DisableNotification: silent,
+		},
+		Kind: "digest",
+		Delivery: Delivery{
+			EncounterID: lastExpiring.ID,
+			Expiration:  int64(*lastExpiring.ExpireTimestamp),
+			Priority:    PriorityNormal,
+			Silent:      silent,
+		},
+	})
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	discordColorDefault = 0x3498DB
+	discordColorHundo   = 0xF1C40F
+)
+
+// DiscordNotifier posts notifications as embeds to Discord webhooks. It is called by the
+// delivery workers, rate limited requests are retried by the delivery queue.
+// The target of a Discord user is the webhook URL.
+type DiscordNotifier struct {
+	client *http.Client
+}
+
+type DiscordWebhookMessage struct {
+	Username string         `json:"username,omitempty"`
+	Embeds   []DiscordEmbed `json:"embeds"`
+}
+
+type DiscordEmbed struct {
+	Title       string              `json:"title,omitempty"`
+	Description string              `json:"description,omitempty"`
+	URL         string              `json:"url,omitempty"`
+	Color       int                 `json:"color,omitempty"`
+	Timestamp   string              `json:"timestamp,omitempty"`
+	Thumbnail   *DiscordEmbedImage  `json:"thumbnail,omitempty"`
+	Fields      []DiscordEmbedField `json:"fields,omitempty"`
+	Footer      *DiscordEmbedFooter `json:"footer,omitempty"`
+}
+
+type DiscordEmbedImage struct {
+	URL string `json:"url"`
+}
+
+type DiscordEmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+type DiscordEmbedFooter struct {
+	Text string `json:"text"`
+}
+
+type discordRateLimit struct {
+	RetryAfter float64 `json:"retry_after"`
+}
+
+func NewDiscordNotifier() *DiscordNotifier {
+	return &DiscordNotifier{client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Build the embed of a notification
+func buildDiscordEmbed(user User, notification Notification) DiscordEmbed {
+	encounter := notification.Encounter
+	data := buildNotificationData(user, encounter)
+
+	embed := DiscordEmbed{
+		Title:       plainText(notification.Title),
+		Description: markdownText(notification.Text),
+		URL:         getMapLink(encounter.Lat, encounter.Lon),
+		Color:       discordColorDefault,
+		Timestamp:   time.Unix(int64(data.ExpireTimestamp), 0).UTC().Format(time.RFC3339),
+		Fields: []DiscordEmbedField{
+			{Name: getTranslation("IV", user.Language), Value: fmt.Sprintf("%.1f%% (%d/%d/%d)", data.IV, data.Atk, data.Def, data.Sta), Inline: true},
+			{Name: getTranslation("CP", user.Language), Value: fmt.Sprintf("%d", data.CP), Inline: true},
+			{Name: getTranslation("Level", user.Language), Value: fmt.Sprintf("%d", data.Level), Inline: true},
+		},
+		Footer: &DiscordEmbedFooter{Text: getTranslation("💨 Despawn", user.Language)},
+	}
+	if data.IV == 100 {
+		embed.Color = discordColorHundo
+	}
+	// Discord can only show icons it can download
+	if icon := getPokemonIcon(uicons, encounter); strings.HasPrefix(icon, "https://") {
+		embed.Thumbnail = &DiscordEmbedImage{URL: icon}
+	}
+	return embed
+}
+
+func (d *DiscordNotifier) Send(user User, notification Notification) error {
+	webhookURL, err := url.Parse(user.Target)
+	if err != nil {
+		return err
+	}
+	// Wait for the created message to get its ID for the cleanup
+	query := webhookURL.Query()
+	query.Set("wait", "true")
+	webhookURL.RawQuery = query.Encode()
+
+	body, err := json.Marshal(DiscordWebhookMessage{Embeds: []DiscordEmbed{buildDiscordEmbed(user, notification)}})
+	if err != nil {
+		return err
+	}
+
+	response, err := d.request(http.MethodPost, webhookURL.String(), body)
+	if err != nil {
+		return err
+	}
+
+	var message struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(response, &message); err != nil {
+		return err
+	}
+	messagesCounter.Inc()
+	if notification.Delivery.EncounterID != "" && message.ID != "" {
+		recordExternalMessage(user.ID, notification.Delivery.EncounterID, message.ID)
+	}
+	return nil
+}
+
+func (d *DiscordNotifier) Delete(user User, reference string) error {
+	webhookURL, err := url.Parse(user.Target)
+	if err != nil {
+		return err
+	}
+	webhookURL.Path = strings.TrimSuffix(webhookURL.Path, "/") + "/messages/" + url.PathEscape(reference)
+	_, err = d.request(http.MethodDelete, webhookURL.String(), nil)
+	return err
+}
+
+// Send a request to a webhook, fails with a RateLimitError if the webhook is rate limited
+func (d *DiscordNotifier) request(method string, target string, body []byte) ([]byte, error) {
+	request, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	response, err := d.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case response.StatusCode == http.StatusTooManyRequests:
+		var rateLimit discordRateLimit
+		json.Unmarshal(data, &rateLimit)
+		retryAfter := time.Duration(rateLimit.RetryAfter * float64(time.Second))
+		return nil, &RateLimitError{RetryAfter: max(retryAfter, time.Second), Message: string(data)}
+	case method == http.MethodDelete && response.StatusCode == http.StatusNotFound:
+		// Already deleted
+		return data, nil
+	case response.StatusCode >= 300:
+		return nil, fmt.Errorf("discord webhook returned %s: %s", response.Status, data)
+	}
+	return data, nil
+}
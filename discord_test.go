@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newDiscordUser(server *httptest.Server, cleanup bool) User {
+	return User{ID: testExternalChatID, Notifier: "discord", Target: server.URL + "/api/webhooks/1/token", Language: "en", Cleanup: cleanup}
+}
+
+func newTestNotification(encounter EncounterData) Notification {
+	return Notification{
+		Encounter: encounter,
+		Title:     "<b>🔔 Pikachu &lt;3</b>",
+		Text:      "<i>Thunder Shock</i> / <code>Thunderbolt</code>",
+		Delivery:  Delivery{EncounterID: encounter.ID, Priority: PriorityNormal},
+	}
+}
+
+func TestBuildDiscordEmbed(t *testing.T) {
+	setupTemplateTest(t)
+	uicons = &UIcons{Base: "https://icons.example", Extension: "png"}
+	encounter := getSampleEncounter()
+
+	embed := buildDiscordEmbed(User{Language: "en"}, newTestNotification(encounter))
+	if embed.Title != "🔔 Pikachu <3" {
+		t.Errorf("title %q", embed.Title)
+	}
+	if embed.Description != "*Thunder Shock* / `Thunderbolt`" {
+		t.Errorf("description %q", embed.Description)
+	}
+	if embed.Color != discordColorHundo {
+		t.Errorf("color %x, expected %x for a 100%% IV Pokémon", embed.Color, discordColorHundo)
+	}
+	if embed.Thumbnail == nil || embed.Thumbnail.URL != "https://icons.example/pokemon/25.png" {
+		t.Errorf("thumbnail %+v", embed.Thumbnail)
+	}
+	if embed.Timestamp != time.Unix(int64(*encounter.ExpireTimestamp), 0).UTC().Format(time.RFC3339) {
+		t.Errorf("timestamp %s", embed.Timestamp)
+	}
+	expected := []DiscordEmbedField{
+		{Name: "IV", Value: "100.0% (15/15/15)", Inline: true},
+		{Name: "CP", Value: "938", Inline: true},
+		{Name: "Level", Value: "35", Inline: true},
+	}
+	if len(embed.Fields) != len(expected) {
+		t.Fatalf("fields %+v", embed.Fields)
+	}
+	for i, field := range expected {
+		if embed.Fields[i] != field {
+			t.Errorf("field %d = %+v, expected %+v", i, embed.Fields[i], field)
+		}
+	}
+
+	if err := loadTranslationFile("translations.json"); err != nil {
+		t.Fatal(err)
+	}
+	iv := float32(91.1)
+	encounter.IV = &iv
+	embed = buildDiscordEmbed(User{Language: "de"}, newTestNotification(encounter))
+	if embed.Color != discordColorDefault {
+		t.Errorf("color %x, expected %x", embed.Color, discordColorDefault)
+	}
+	if embed.Fields[1].Name != "WP" || embed.Fields[2].Name != "Level" {
+		t.Errorf("fields are not translated: %+v", embed.Fields)
+	}
+}
+
+func TestDiscordSendRecordsMessage(t *testing.T) {
+	setupTemplateTest(t)
+	setupTestDB(t)
+	webhook, server := newRecordingServer(t, respondJSON(http.StatusOK, `{"id": "1234567890", "channel_id": "1"}`))
+	user := newDiscordUser(server, true)
+
+	if err := NewDiscordNotifier().Send(user, newTestNotification(getSampleEncounter())); err != nil {
+		t.Fatal(err)
+	}
+
+	request := webhook.requests[0]
+	if request.Method != http.MethodPost || request.URL.Path != "/api/webhooks/1/token" {
+		t.Errorf("request %s %s", request.Method, request.URL.Path)
+	}
+	if request.URL.Query().Get("wait") != "true" {
+		t.Errorf("message created without waiting for it: %s", request.URL.RawQuery)
+	}
+	if request.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("content type %s", request.Header.Get("Content-Type"))
+	}
+	var message DiscordWebhookMessage
+	if err := json.Unmarshal([]byte(webhook.bodies[0]), &message); err != nil {
+		t.Fatal(err)
+	}
+	if len(message.Embeds) != 1 || message.Embeds[0].Title != "🔔 Pikachu <3" {
+		t.Errorf("message %+v", message)
+	}
+
+	var stored []ExternalMessage
+	dbConfig.Find(&stored)
+	if len(stored) != 1 || stored[0].ChatID != user.ID || stored[0].EncounterID != "sample" || stored[0].Reference != "1234567890" {
+		t.Errorf("stored messages %+v", stored)
+	}
+}
+
+func TestDiscordRateLimitIsRetriedByTheQueue(t *testing.T) {
+	setupTemplateTest(t)
+	setupTestDB(t)
+	queue := setupTestQueue(t)
+	webhook, server := newRecordingServer(t,
+		respondJSON(http.StatusTooManyRequests, `{"message": "You are being rate limited.", "retry_after": 0.25, "global": false}`),
+		respondJSON(http.StatusOK, `{"id": "42"}`),
+	)
+	user := newDiscordUser(server, false)
+	notifiers["discord"] = NewDiscordNotifier()
+
+	if err := sendNotification(user, newTestNotification(getSampleEncounter())); err != nil {
+		t.Fatal(err)
+	}
+	if len(webhook.requests) != 0 {
+		t.Fatal("notification has been sent by the matcher instead of the delivery workers")
+	}
+
+	message := deliverNext(t, queue)
+	if message.Kind != "discord" || message.Attempts != 1 {
+		t.Errorf("%s attempted %d times", message.Kind, message.Attempts)
+	}
+	queue.mutex.Lock()
+	blocked, held := queue.blocked[user.ID], len(queue.backlogs[user.ID])
+	_, inflight := queue.inflight[user.ID]
+	queue.mutex.Unlock()
+	if wait := time.Until(blocked); wait <= 0 || wait > time.Second {
+		t.Errorf("chat blocked for %s", wait)
+	}
+	if held != 1 || inflight {
+		t.Errorf("%d messages held back, in flight: %v", held, inflight)
+	}
+
+	deliverNext(t, queue)
+	if len(webhook.requests) != 2 {
+		t.Fatalf("%d requests", len(webhook.requests))
+	}
+	if webhook.bodies[0] != webhook.bodies[1] {
+		t.Error("retry sent another message")
+	}
+	var stored ExternalMessage
+	if dbConfig.Where("reference = ?", "42").First(&stored).Error != nil {
+		t.Error("retried message has not been stored")
+	}
+}
+
+func TestDiscordDelete(t *testing.T) {
+	_, server := newRecordingServer(t, respondJSON(http.StatusNoContent, ""), respondJSON(http.StatusNotFound, `{"message": "Unknown Message", "code": 10008}`), respondJSON(http.StatusInternalServerError, ""))
+	user := newDiscordUser(server, true)
+	notifier := NewDiscordNotifier()
+
+	if err := notifier.Delete(user, "1"); err != nil {
+		t.Errorf("delete failed: %v", err)
+	}
+	// Messages deleted in Discord are gone already
+	if err := notifier.Delete(user, "2"); err != nil {
+		t.Errorf("delete of a deleted message failed: %v", err)
+	}
+	if err := notifier.Delete(user, "3"); err == nil {
+		t.Error("server error has been ignored")
+	}
+}
+
+func TestDeleteExternalMessagesOnCleanup(t *testing.T) {
+	setupTestDB(t)
+	queue := setupTestQueue(t)
+	webhook, server := newRecordingServer(t, respondJSON(http.StatusNoContent, ""))
+	notifiers["discord"] = NewDiscordNotifier()
+
+	cleanup := newDiscordUser(server, true)
+	keep := newDiscordUser(server, false)
+	keep.ID--
+	dbConfig.Create(&cleanup)
+	dbConfig.Create(&keep)
+	dbConfig.Model(&keep).Update("cleanup", false)
+	getUsersByFilters()
+	recordExternalMessage(cleanup.ID, "despawned", "100")
+	recordExternalMessage(keep.ID, "despawned", "200")
+	recordExternalMessage(cleanup.ID, "active", "300")
+
+	if deleted := deleteExternalMessages(0, "despawned", true); deleted != 1 {
+		t.Errorf("%d messages deleted, expected 1", deleted)
+	}
+	if len(webhook.requests) != 0 {
+		t.Fatal("message has been deleted by the cleanup instead of the delivery workers")
+	}
+	message := deliverNext(t, queue)
+	if message.Kind != "delete" || message.ChatID != cleanup.ID {
+		t.Errorf("delivered %s for %d", message.Kind, message.ChatID)
+	}
+	if len(webhook.requests) != 1 {
+		t.Fatalf("%d requests", len(webhook.requests))
+	}
+	if request := webhook.requests[0]; request.Method != http.MethodDelete || !strings.HasSuffix(request.URL.Path, "/api/webhooks/1/token/messages/100") {
+		t.Errorf("request %s %s", request.Method, request.URL.Path)
+	}
+
+	var remaining []ExternalMessage
+	dbConfig.Order("reference").Find(&remaining)
+	if len(remaining) != 1 || remaining[0].Reference != "300" {
+		t.Errorf("remaining messages %+v", remaining)
+	}
+}
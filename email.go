@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"html/template"
+	"log"
+	"math/big"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/telebot.v3"
+)
+
+const (
+	// Number of Pokémon listed in an email digest
+	emailDigestMaxEntries = 25
+	// Number of Pokémon kept per user until the next email digest, the lowest IV is dropped first
+	emailDigestMaxBuffered = 200
+	emailCodeValidity      = 15 * time.Minute
+	emailCodeMaxAttempts   = 5
+)
+
+// Email digest schedules offered in the settings
+var emailDigestSchedules = []string{"hourly", "daily"}
+
+// Mailer sends emails through an SMTP server.
+// STARTTLS is used if the server supports it, authentication is optional.
+type Mailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// EmailDigestData is the data available in the email digest template.
+// Further sections like raids or quests can be added as additional lists.
+type EmailDigestData struct {
+	Language string
+	Schedule string // "hourly" or "daily"
+	Since    string
+	Total    int
+	Pokemon  []EmailDigestPokemon
+}
+
+// EmailDigestPokemon is a Pokémon listed in an email digest
+type EmailDigestPokemon struct {
+	NotificationData
+	MapURL  string
+	IconURL string
+}
+
+// Pending verification of an email address
+type emailVerification struct {
+	Address  string
+	Code     string
+	Expires  time.Time
+	Attempts int
+}
+
+var (
+	mailer              *Mailer
+	emailDigestTemplate *template.Template
+	// Matched encounters per user waiting for the next email digest
+	emailDigestBuffer = make(map[int64]map[string]EncounterData)
+	// Start of the period an email digest has last been sent for, per user
+	lastEmailDigest    = make(map[int64]time.Time)
+	emailVerifications = make(map[int64]emailVerification)
+	emailMutex         sync.Mutex
+)
+
+// Default email digest template, can be replaced with the file set in EMAIL_TEMPLATE
+const defaultEmailDigestTemplate = `<!DOCTYPE html>
+<html lang="{{.Language}}">
+<head><meta charset="utf-8"><title>{{translate "Pokémon Digest" .Language}}</title></head>
+<body style="font-family: sans-serif; color: #222;">
+<h2>{{translate "Pokémon Digest" .Language}}</h2>
+<p>{{printf (translate "%d Pokémon matched your filters since %s" .Language) .Total .Since}}{{if gt .Total (len .Pokemon)}}, {{printf (translate "these are the top %d" .Language) (len .Pokemon)}}{{end}}:</p>
+<table cellpadding="6" style="border-collapse: collapse;">
+{{range .Pokemon}}<tr style="border-bottom: 1px solid #ddd;">
+<td>{{if .IconURL}}<img src="{{.IconURL}}" width="48" height="48" alt="">{{end}}</td>
+<td><b>{{pokemonName .PokemonID .Language}}{{if .Form}} ({{.Form}}){{end}} {{.Gender}}</b><br>
+{{printf "%.1f" .IV}}% {{.Atk}}|{{.Def}}|{{.Sta}} {{.CP}} {{translate "CP" .Language}} L{{.Level}}{{range .PVP}}<br>
+🏅 {{.League}} #{{.Rank}}{{end}}</td>
+<td>{{if .HasDistance}}📍 {{distance .Distance}}<br>{{end}}💨 {{.ExpireTime}}</td>
+<td><a href="{{.MapURL}}">{{translate "📍 Open map" .Language}}</a></td>
+</tr>
+{{end}}</table>
+<p style="color: #888; font-size: small;">{{translate "You receive this email because you enabled the email digest in the settings of the bot." .Language}}</p>
+</body>
+</html>
+`
+
+// Enable emails if an SMTP server is configured
+func loadMailer() {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return
+	}
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	from := os.Getenv("SMTP_FROM")
+	if _, err := mail.ParseAddress(from); err != nil {
+		log.Printf("❌ Invalid SMTP_FROM address, emails are disabled: %v", err)
+		return
+	}
+
+	text := defaultEmailDigestTemplate
+	if path := os.Getenv("EMAIL_TEMPLATE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("❌ Failed to read email template, using the default one: %v", err)
+		} else {
+			text = string(data)
+		}
+	}
+	parsed, err := template.New("email").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		log.Printf("❌ Failed to parse email template, using the default one: %v", err)
+		parsed = template.Must(template.New("email").Funcs(templateFuncs).Parse(defaultEmailDigestTemplate))
+	}
+	emailDigestTemplate = parsed
+
+	mailer = &Mailer{Host: host, Port: port, Username: os.Getenv("SMTP_USER"), Password: os.Getenv("SMTP_PASS"), From: from}
+	log.Printf("✅ Emails enabled via %s", net.JoinHostPort(host, port))
+}
+
+// Send an HTML email
+func (m *Mailer) Send(to string, subject string, body string) error {
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return err
+	}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", from.String())
+	fmt.Fprintf(&message, "To: %s\r\n", to)
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	message.WriteString("MIME-Version: 1.0\r\n")
+	message.WriteString("Content-Type: text/html; charset=utf-8\r\n")
+	message.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	writer := quotedprintable.NewWriter(&message)
+	if _, err := writer.Write([]byte(body)); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	return smtp.SendMail(net.JoinHostPort(m.Host, m.Port), auth, from.Address, []string{to}, message.Bytes())
+}
+
+// Send a confirmation code to a new email address of a user
+func startEmailVerification(user User, input string) (string, error) {
+	address, err := mail.ParseAddress(strings.TrimSpace(input))
+	if err != nil {
+		return "", err
+	}
+	number, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	code := fmt.Sprintf("%06d", number.Int64())
+
+	body := fmt.Sprintf("<p>%s</p><h2>%s</h2>",
+		template.HTMLEscapeString(getTranslation("Enter this code in the bot to confirm your email address:", user.Language)), code)
+	if err := mailer.Send(address.Address, getTranslation("Confirm your email address", user.Language), body); err != nil {
+		return "", err
+	}
+
+	emailMutex.Lock()
+	emailVerifications[user.ID] = emailVerification{Address: address.Address, Code: code, Expires: time.Now().Add(emailCodeValidity)}
+	emailMutex.Unlock()
+	log.Printf("📧 Sent email confirmation code to %d", user.ID)
+	return address.Address, nil
+}
+
+// Check a confirmation code, the address is stored if it matches.
+// Returns the confirmed address and whether the user may try again.
+func confirmEmail(userID int64, code string) (string, bool) {
+	emailMutex.Lock()
+	defer emailMutex.Unlock()
+	verification, exists := emailVerifications[userID]
+	if !exists || time.Now().After(verification.Expires) {
+		delete(emailVerifications, userID)
+		return "", false
+	}
+	if strings.TrimSpace(code) != verification.Code {
+		verification.Attempts++
+		if verification.Attempts >= emailCodeMaxAttempts {
+			delete(emailVerifications, userID)
+			return "", false
+		}
+		emailVerifications[userID] = verification
+		return "", true
+	}
+	delete(emailVerifications, userID)
+	updateUserPreference(userID, "Email", verification.Address)
+	return verification.Address, false
+}
+
+func setEmailDigest(userID int64, schedule string) {
+	updateUserPreference(userID, "EmailDigest", schedule)
+	emailMutex.Lock()
+	delete(emailDigestBuffer, userID)
+	delete(lastEmailDigest, userID)
+	emailMutex.Unlock()
+}
+
+func removeEmail(userID int64) {
+	dbConfig.Model(&User{}).Where("id = ?", userID).Updates(map[string]interface{}{"Email": "", "EmailDigest": ""})
+	getUsersByFilters()
+	emailMutex.Lock()
+	delete(emailDigestBuffer, userID)
+	delete(lastEmailDigest, userID)
+	emailMutex.Unlock()
+}
+
+func formatEmailDigest(user User) string {
+	if user.Email == "" {
+		return boolToEmoji(false)
+	}
+	if user.EmailDigest == "" {
+		return fmt.Sprintf("%s (%s)", boolToEmoji(false), user.Email)
+	}
+	return fmt.Sprintf("%s (%s)", getTranslation(user.EmailDigest, user.Language), user.Email)
+}
+
+func buildEmailDigestButtons(user User) *telebot.ReplyMarkup {
+	btnSetEmail := telebot.InlineButton{Text: getTranslation("📧 Set Email Address", user.Language), Unique: "set_email"}
+	inlineKeyboard := [][]telebot.InlineButton{{btnSetEmail}}
+	if user.Email != "" {
+		var scheduleButtons []telebot.InlineButton
+		for _, schedule := range emailDigestSchedules {
+			scheduleButtons = append(scheduleButtons, telebot.InlineButton{Text: getTranslation(schedule, user.Language), Unique: "email_digest", Data: schedule})
+		}
+		btnOff := telebot.InlineButton{Text: boolToEmoji(false), Unique: "email_digest", Data: "off"}
+		btnRemove := telebot.InlineButton{Text: getTranslation("🗑️ Remove Email Address", user.Language), Unique: "remove_email"}
+		inlineKeyboard = append(inlineKeyboard, append(scheduleButtons, btnOff), []telebot.InlineButton{btnRemove})
+	}
+	btnClose := telebot.InlineButton{Text: getTranslation("Close", user.Language), Unique: "close"}
+	return &telebot.ReplyMarkup{InlineKeyboard: append(inlineKeyboard, []telebot.InlineButton{btnClose})}
+}
+
+// Collect a matched encounter for the next email digest of a user
+func addToEmailDigest(user User, encounter EncounterData) {
+	emailMutex.Lock()
+	defer emailMutex.Unlock()
+	bufferEmailDigest(user.ID, encounter)
+}
+
+// Add an encounter to the email digest buffer of a user, must be called with the mutex held
+func bufferEmailDigest(userID int64, encounter EncounterData) {
+	buffered := emailDigestBuffer[userID]
+	if buffered == nil {
+		buffered = make(map[string]EncounterData)
+		emailDigestBuffer[userID] = buffered
+	}
+	if _, exists := buffered[encounter.ID]; !exists && len(buffered) >= emailDigestMaxBuffered {
+		// Replace the Pokémon with the lowest IV if the new one is better
+		var lowest EncounterData
+		for _, other := range buffered {
+			if lowest.ID == "" || getIV(other) < getIV(lowest) {
+				lowest = other
+			}
+		}
+		if getIV(encounter) <= getIV(lowest) {
+			return
+		}
+		delete(buffered, lowest.ID)
+	}
+	buffered[encounter.ID] = encounter
+}
+
+func getIV(encounter EncounterData) float32 {
+	if encounter.IV == nil {
+		return 0
+	}
+	return *encounter.IV
+}
+
+// Get the start of the current email digest period of a user.
+// Daily digests are sent at EMAIL_DIGEST_HOUR in the timezone of the user.
+func getEmailDigestPeriod(user User, now time.Time) time.Time {
+	if user.EmailDigest == "hourly" {
+		return now.Truncate(time.Hour)
+	}
+	local := now.In(getUserTimezone(user))
+	period := time.Date(local.Year(), local.Month(), local.Day(), int(getEnvFloat("EMAIL_DIGEST_HOUR", 20)), 0, 0, 0, local.Location())
+	if local.Before(period) {
+		period = period.AddDate(0, 0, -1)
+	}
+	return period
+}
+
+// Send the email digests of all users whose period has passed
+func flushEmailDigests() {
+	if mailer == nil {
+		return
+	}
+	now := time.Now()
+	type dueDigest struct {
+		User       User
+		Since      time.Time
+		Encounters []EncounterData
+	}
+	var due []dueDigest
+
+	emailMutex.Lock()
+	for userID, buffered := range emailDigestBuffer {
+		user, exists := users.All[userID]
+		if !exists || user.Email == "" || user.EmailDigest == "" {
+			delete(emailDigestBuffer, userID)
+			continue
+		}
+		period := getEmailDigestPeriod(user, now)
+		last, known := lastEmailDigest[userID]
+		if !known {
+			lastEmailDigest[userID] = period
+			continue
+		}
+		if !period.After(last) || len(buffered) == 0 {
+			continue
+		}
+		lastEmailDigest[userID] = period
+		digest := dueDigest{User: user, Since: last}
+		for _, encounter := range buffered {
+			digest.Encounters = append(digest.Encounters, encounter)
+		}
+		due = append(due, digest)
+		delete(emailDigestBuffer, userID)
+	}
+	emailMutex.Unlock()
+
+	for _, digest := range due {
+		// Do not block the encounter processing on a slow SMTP server
+		go sendEmailDigest(digest.User, digest.Since, digest.Encounters)
+	}
+}
+
+// Render the email digest of a user, listing the best Pokémon first
+func renderEmailDigest(user User, since time.Time, encounters []EncounterData) (string, error) {
+	sort.SliceStable(encounters, func(i, j int) bool {
+		if getIV(encounters[i]) != getIV(encounters[j]) {
+			return getIV(encounters[i]) > getIV(encounters[j])
+		}
+		distanceI, _ := getUserDistance(user, encounters[i])
+		distanceJ, _ := getUserDistance(user, encounters[j])
+		return distanceI < distanceJ
+	})
+
+	data := EmailDigestData{
+		Language: user.Language,
+		Schedule: user.EmailDigest,
+		Since:    formatUserDateTime(user, since),
+		Total:    len(encounters),
+	}
+	for i, encounter := range encounters {
+		if i >= emailDigestMaxEntries {
+			break
+		}
+		entry := EmailDigestPokemon{NotificationData: buildNotificationData(user, encounter), MapURL: getMapLink(encounter.Lat, encounter.Lon)}
+		// Mail clients can only show icons they can download
+		if icon := getPokemonIcon(uicons, encounter); strings.HasPrefix(icon, "https://") {
+			entry.IconURL = icon
+		}
+		data.Pokemon = append(data.Pokemon, entry)
+	}
+
+	var output strings.Builder
+	if err := emailDigestTemplate.Execute(&output, data); err != nil {
+		return "", err
+	}
+	return output.String(), nil
+}
+
+func sendEmailDigest(user User, since time.Time, encounters []EncounterData) {
+	body, err := renderEmailDigest(user, since, encounters)
+	if err != nil {
+		log.Printf("❌ Failed to render email digest for %d: %v", user.ID, err)
+		return
+	}
+	log.Printf("📧 Sending email digest with %d Pokémon to %d", len(encounters), user.ID)
+	subject := fmt.Sprintf(getTranslation("Pokémon Digest: %d Pokémon", user.Language), len(encounters))
+	if err := mailer.Send(user.Email, subject, body); err != nil {
+		log.Printf("❌ Failed to send email digest to %d, retrying later: %v", user.ID, err)
+		restoreEmailDigest(user.ID, since, encounters)
+		return
+	}
+	messagesCounter.Inc()
+}
+
+// Put the Pokémon of a digest that could not be sent back into the buffer, so they are sent
+// with the next attempt. Nothing is restored if the user changed the digest in the meantime.
+func restoreEmailDigest(userID int64, since time.Time, encounters []EncounterData) {
+	emailMutex.Lock()
+	defer emailMutex.Unlock()
+	last, known := lastEmailDigest[userID]
+	if !known {
+		return
+	}
+	if since.Before(last) {
+		lastEmailDigest[userID] = since
+	}
+	for _, encounter := range encounters {
+		bufferEmailDigest(userID, encounter)
+	}
+}
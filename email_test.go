@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpMessage is an email received by the test SMTP server
+type smtpMessage struct {
+	Auth string
+	From string
+	To   []string
+	Data string
+}
+
+// Start an SMTP server accepting all emails and use it for the mailer
+func setupTestSMTPServer(t *testing.T) <-chan smtpMessage {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	messages := make(chan smtpMessage, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, messages)
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	previous := mailer
+	mailer = &Mailer{Host: host, Port: port, Username: "bot", Password: "secret", From: "PoGo Bot <bot@example.com>"}
+	t.Cleanup(func() { mailer = previous })
+	return messages
+}
+
+func serveSMTP(conn net.Conn, messages chan<- smtpMessage) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 localhost ESMTP test")
+	var message smtpMessage
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch command {
+		case "EHLO":
+			text.PrintfLine("250-localhost")
+			text.PrintfLine("250 AUTH PLAIN")
+		case "AUTH":
+			message.Auth = strings.TrimPrefix(line, "AUTH PLAIN ")
+			text.PrintfLine("235 2.7.0 Authentication successful")
+		case "MAIL":
+			message.From = strings.TrimSuffix(strings.TrimPrefix(line, "MAIL FROM:<"), ">")
+			text.PrintfLine("250 OK")
+		case "RCPT":
+			message.To = append(message.To, strings.TrimSuffix(strings.TrimPrefix(line, "RCPT TO:<"), ">"))
+			text.PrintfLine("250 OK")
+		case "DATA":
+			text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := io.ReadAll(text.DotReader())
+			if err != nil {
+				return
+			}
+			message.Data = string(data)
+			text.PrintfLine("250 OK")
+			messages <- message
+			message = smtpMessage{}
+		case "QUIT":
+			text.PrintfLine("221 Bye")
+			return
+		default:
+			text.PrintfLine("250 OK")
+		}
+	}
+}
+
+func receiveEmail(t *testing.T, messages <-chan smtpMessage) (smtpMessage, *mail.Message, string) {
+	t.Helper()
+	select {
+	case received := <-messages:
+		parsed, err := mail.ReadMessage(bufio.NewReader(strings.NewReader(received.Data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if encoding := parsed.Header.Get("Content-Transfer-Encoding"); encoding != "quoted-printable" {
+			t.Fatalf("transfer encoding %s", encoding)
+		}
+		body, err := io.ReadAll(quotedprintable.NewReader(parsed.Body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return received, parsed, string(body)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email received")
+		return smtpMessage{}, nil, ""
+	}
+}
+
+func TestEmailConfirmationCode(t *testing.T) {
+	setupTestDB(t)
+	messages := setupTestSMTPServer(t)
+	user := User{ID: 7, Language: "en"}
+	dbConfig.Create(&user)
+
+	address, err := startEmailVerification(user, " Ash <ash@example.com> ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address != "ash@example.com" {
+		t.Errorf("address %s", address)
+	}
+	received, parsed, body := receiveEmail(t, messages)
+
+	if auth, _ := base64.StdEncoding.DecodeString(received.Auth); string(auth) != "\x00bot\x00secret" {
+		t.Errorf("authentication %q", auth)
+	}
+	if received.From != "bot@example.com" || len(received.To) != 1 || received.To[0] != "ash@example.com" {
+		t.Errorf("envelope from %s to %v", received.From, received.To)
+	}
+	if from := parsed.Header.Get("From"); from != `"PoGo Bot" <bot@example.com>` {
+		t.Errorf("from %s", from)
+	}
+	if subject := parsed.Header.Get("Subject"); subject != "Confirm your email address" {
+		t.Errorf("subject %s", subject)
+	}
+	if _, err := parsed.Header.Date(); err != nil {
+		t.Errorf("date: %v", err)
+	}
+	if contentType := parsed.Header.Get("Content-Type"); contentType != "text/html; charset=utf-8" {
+		t.Errorf("content type %s", contentType)
+	}
+
+	code := regexp.MustCompile(`<h2>(\d{6})</h2>`).FindStringSubmatch(body)
+	if code == nil {
+		t.Fatalf("no code in %q", body)
+	}
+	if emailVerifications[user.ID].Code != code[1] {
+		t.Errorf("code %s, expected %s", code[1], emailVerifications[user.ID].Code)
+	}
+	if _, retry := confirmEmail(user.ID, "wrong"); !retry {
+		t.Error("wrong code ended the verification")
+	}
+	if confirmed, _ := confirmEmail(user.ID, code[1]); confirmed != "ash@example.com" {
+		t.Errorf("confirmed %q", confirmed)
+	}
+	if users.All[user.ID].Email != "ash@example.com" {
+		t.Errorf("stored address %q", users.All[user.ID].Email)
+	}
+}
+
+func TestEmailDigest(t *testing.T) {
+	setupTemplateTest(t)
+	messages := setupTestSMTPServer(t)
+	emailDigestTemplate = template.Must(template.New("email").Funcs(templateFuncs).Parse(defaultEmailDigestTemplate))
+	uicons = &UIcons{Base: "https://icons.example", Extension: "png"}
+	user := User{ID: 8, Language: "en", Email: "misty@example.com", EmailDigest: "hourly", Latitude: 52.52, Longitude: 13.40}
+
+	// 30 Pokémon with distinct IV and two 100% IV Pokémon, the nearer one is listed first
+	var encounters []EncounterData
+	for i := 0; i < 30; i++ {
+		encounter := getSampleEncounter()
+		encounter.ID = strconv.Itoa(i)
+		iv := float32(i * 3)
+		encounter.IV = &iv
+		encounters = append(encounters, encounter)
+	}
+	far, near := getSampleEncounter(), getSampleEncounter()
+	far.ID, far.Lat, far.Lon = "far", 52.40, 13.10
+	near.ID, near.PokemonID = "near", 26
+	encounters = append(encounters, far, near)
+
+	since := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	sendEmailDigest(user, since, encounters)
+	received, parsed, body := receiveEmail(t, messages)
+
+	if len(received.To) != 1 || received.To[0] != "misty@example.com" {
+		t.Errorf("envelope to %v", received.To)
+	}
+	if to := parsed.Header.Get("To"); to != "misty@example.com" {
+		t.Errorf("to %s", to)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil || subject != "Pokémon Digest: 32 Pokémon" {
+		t.Errorf("subject %q: %v", subject, err)
+	}
+	// Lines of the quoted-printable body are wrapped and non-ASCII characters are encoded,
+	// the line endings have been converted by the test server
+	for _, line := range strings.Split(received.Data, "\n") {
+		if len(line) > 76 {
+			t.Errorf("line too long: %q", line)
+		}
+	}
+	if strings.Contains(received.Data, "é") || !strings.Contains(received.Data, "Pok=C3=A9mon") {
+		t.Error("body is not quoted-printable encoded")
+	}
+
+	if !strings.Contains(body, fmt.Sprintf("32 Pokémon matched your filters since %s, these are the top 25:", formatUserDateTime(user, since))) {
+		t.Errorf("summary missing in %q", body)
+	}
+	if rows := strings.Count(body, "<tr "); rows != emailDigestMaxEntries {
+		t.Errorf("%d Pokémon listed, expected %d", rows, emailDigestMaxEntries)
+	}
+	names := regexp.MustCompile(`<b>(\w+)`).FindAllStringSubmatch(body, 2)
+	if len(names) != 2 || names[0][1] != "Raichu" || names[1][1] != "Pikachu" {
+		t.Errorf("100%% IV Pokémon are not sorted by distance: %v", names)
+	}
+	var ivs []float64
+	for _, match := range regexp.MustCompile(`(\d+\.\d)% \d+\|`).FindAllStringSubmatch(body, -1) {
+		iv, _ := strconv.ParseFloat(match[1], 64)
+		ivs = append(ivs, iv)
+	}
+	expected := []float64{100, 100, 87, 84, 81}
+	if len(ivs) != emailDigestMaxEntries {
+		t.Fatalf("IVs %v", ivs)
+	}
+	for i, iv := range expected {
+		if ivs[i] != iv {
+			t.Errorf("IV %d = %.1f, expected %.1f", i, ivs[i], iv)
+		}
+	}
+	if ivs[len(ivs)-1] != 21 {
+		t.Errorf("lowest listed IV %.1f, expected 21", ivs[len(ivs)-1])
+	}
+	if !strings.Contains(body, `<img src="https://icons.example/pokemon/25.png"`) {
+		t.Error("icon missing")
+	}
+}
+
+func TestFailedEmailDigestIsRestored(t *testing.T) {
+	setupTemplateTest(t)
+	emailDigestTemplate = template.Must(template.New("email").Funcs(templateFuncs).Parse(defaultEmailDigestTemplate))
+	uicons = &UIcons{Base: "https://icons.example", Extension: "png"}
+	user := User{ID: 9, Language: "en", Email: "brock@example.com", EmailDigest: "hourly"}
+	previousUsers := users.All
+	users.All = map[int64]User{user.ID: user}
+	t.Cleanup(func() {
+		users.All = previousUsers
+		emailMutex.Lock()
+		delete(emailDigestBuffer, user.ID)
+		delete(lastEmailDigest, user.ID)
+		emailMutex.Unlock()
+	})
+
+	// No SMTP server is listening on a closed port
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+	previous := mailer
+	mailer = &Mailer{Host: host, Port: port, From: "bot@example.com"}
+	t.Cleanup(func() { mailer = previous })
+
+	since := time.Now().Truncate(time.Hour).Add(-time.Hour)
+	addToEmailDigest(user, getSampleEncounter())
+	emailMutex.Lock()
+	lastEmailDigest[user.ID] = since
+	emailMutex.Unlock()
+
+	flushEmailDigests()
+	// The digest is sent in the background
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		emailMutex.Lock()
+		buffered, last := len(emailDigestBuffer[user.ID]), lastEmailDigest[user.ID]
+		emailMutex.Unlock()
+		if buffered == 1 && last.Equal(since) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d Pokémon buffered since %s after the failed digest", buffered, last)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Sent with the next attempt
+	messages := setupTestSMTPServer(t)
+	flushEmailDigests()
+	if _, _, body := receiveEmail(t, messages); !strings.Contains(body, "Pikachu") {
+		t.Errorf("digest %q", body)
+	}
+	emailMutex.Lock()
+	buffered := len(emailDigestBuffer[user.ID])
+	emailMutex.Unlock()
+	if buffered != 0 {
+		t.Errorf("%d Pokémon buffered after the digest", buffered)
+	}
+}
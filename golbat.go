@@ -0,0 +1,292 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Poll the scanner database again if Golbat has not sent any Pokémon for this long
+const golbatWebhookTimeout = 2 * time.Minute
+
+// GolbatWebhook is a single message of a Golbat webhook request, which contains a list of them
+type GolbatWebhook struct {
+	Type    string          `json:"type"`
+	Message json.RawMessage `json:"message"`
+}
+
+// GolbatPokemon is the message of a "pokemon" webhook
+type GolbatPokemon struct {
+	EncounterID           string          `json:"encounter_id"`
+	PokestopID            string          `json:"pokestop_id"`
+	SpawnpointID          string          `json:"spawnpoint_id"`
+	PokemonID             int             `json:"pokemon_id"`
+	Latitude              float64         `json:"latitude"`
+	Longitude             float64         `json:"longitude"`
+	DisappearTime         int             `json:"disappear_time"`
+	DisappearTimeVerified bool            `json:"disappear_time_verified"`
+	FirstSeen             int             `json:"first_seen"`
+	LastModifiedTime      int             `json:"last_modified_time"`
+	Gender                *int            `json:"gender"`
+	CP                    *int            `json:"cp"`
+	Form                  *int            `json:"form"`
+	Costume               *int            `json:"costume"`
+	IndividualAttack      *int            `json:"individual_attack"`
+	IndividualDefense     *int            `json:"individual_defense"`
+	IndividualStamina     *int            `json:"individual_stamina"`
+	PokemonLevel          *int            `json:"pokemon_level"`
+	Move1                 *int            `json:"move_1"`
+	Move2                 *int            `json:"move_2"`
+	Weight                *float32        `json:"weight"`
+	Height                *float32        `json:"height"`
+	Size                  *int            `json:"size"`
+	Weather               *int            `json:"weather"`
+	Capture1              *float32        `json:"capture_1"`
+	Capture2              *float32        `json:"capture_2"`
+	Capture3              *float32        `json:"capture_3"`
+	Shiny                 *bool           `json:"shiny"`
+	Username              *string         `json:"username"`
+	DisplayPokemonID      *int            `json:"display_pokemon_id"`
+	IsEvent               int             `json:"is_event"`
+	SeenType              *string         `json:"seen_type"`
+	PVP                   json.RawMessage `json:"pvp"`
+}
+
+// GolbatGym is the message of a "gym" or "raid" webhook, raid messages use the raid fields
+type GolbatGym struct {
+	GymID               string  `json:"gym_id"`
+	Name                *string `json:"name"`
+	GymName             *string `json:"gym_name"`
+	URL                 *string `json:"url"`
+	GymURL              *string `json:"gym_url"`
+	Description         *string `json:"description"`
+	Latitude            float64 `json:"latitude"`
+	Longitude           float64 `json:"longitude"`
+	TeamID              *int    `json:"team_id"`
+	GuardPokemonID      *int    `json:"guard_pokemon_id"`
+	SlotsAvailable      *int    `json:"slots_available"`
+	ExRaidEligible      *int    `json:"ex_raid_eligible"`
+	InBattle            *int    `json:"in_battle"`
+	SponsorID           *int    `json:"sponsor_id"`
+	PartnerID           *string `json:"partner_id"`
+	PowerUpPoints       *int    `json:"power_up_points"`
+	PowerUpLevel        *int    `json:"power_up_level"`
+	PowerUpEndTimestamp *int    `json:"power_up_end_timestamp"`
+	ArScanEligible      *int    `json:"ar_scan_eligible"`
+	Spawn               *int    `json:"spawn"`
+	Start               *int    `json:"start"`
+	End                 *int    `json:"end"`
+	Level               *int    `json:"level"`
+	PokemonID           *int    `json:"pokemon_id"`
+	CP                  *int    `json:"cp"`
+	Gender              *int    `json:"gender"`
+	Form                *int    `json:"form"`
+	Alignment           *int    `json:"alignment"`
+	Costume             *int    `json:"costume"`
+	Evolution           *int    `json:"evolution"`
+	Move1               *int    `json:"move_1"`
+	Move2               *int    `json:"move_2"`
+	IsExclusive         *int    `json:"is_exclusive"`
+}
+
+var (
+	// Encounters received from Golbat, processed by the background processing
+	scannerEncounters = make(chan ReceivedEncounters, 100)
+
+	golbatWebhooksCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "bot_golbat_webhooks_total",
+			Help: "Total number of webhook messages received from Golbat by type",
+		},
+		[]string{"type"},
+	)
+)
+
+func handleGolbatWebhook(w http.ResponseWriter, r *http.Request, source *ScannerSource) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if source.Secret != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Golbat-Secret")), []byte(source.Secret)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var webhooks []GolbatWebhook
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<20)).Decode(&webhooks); err != nil {
+		log.Printf("❌ Failed to decode Golbat webhook of %s: %v", source.Name, err)
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	var encounters []EncounterData
+	invalid := 0
+	for _, webhook := range webhooks {
+		golbatWebhooksCounter.WithLabelValues(webhook.Type).Inc()
+		switch webhook.Type {
+		case "pokemon":
+			var pokemon GolbatPokemon
+			if !decodeGolbatMessage(webhook, &pokemon) {
+				invalid++
+				continue
+			}
+			source.lastPokemon.Store(time.Now().Unix())
+			// Only encountered Pokémon can be matched, like when polling the database
+			if encounter, ok := pokemon.toEncounter(); ok {
+				encounters = append(encounters, encounter)
+			}
+		case "gym", "raid":
+			var gym GolbatGym
+			if !decodeGolbatMessage(webhook, &gym) {
+				invalid++
+				continue
+			}
+			handleGolbatGym(gym.toGym())
+		// Accepted so Golbat can send all types to the bot, there are no notifications for them yet
+		case "pokestop", "quest", "invasion", "weather":
+		default:
+			log.Printf("⚠️ Ignoring Golbat webhook of unknown type %s", webhook.Type)
+		}
+	}
+
+	if len(encounters) > 0 {
+		select {
+		case scannerEncounters <- ReceivedEncounters{Source: source, Encounters: encounters}:
+		case <-r.Context().Done():
+			http.Error(w, "encounters not processed in time", http.StatusServiceUnavailable)
+			return
+		}
+	}
+	// The valid messages have been processed, but Golbat should show that others were not
+	if invalid > 0 {
+		http.Error(w, fmt.Sprintf("%d invalid messages", invalid), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Decode the message of a webhook into the struct of its type
+func decodeGolbatMessage(webhook GolbatWebhook, message interface{}) bool {
+	if err := json.Unmarshal(webhook.Message, message); err != nil {
+		log.Printf("❌ Failed to decode Golbat %s: %v", webhook.Type, err)
+		return false
+	}
+	return true
+}
+
+// Handle a received gym or raid. There are no gym or raid notifications yet, new raids are only logged.
+func handleGolbatGym(gym GymData) {
+	if gym.RaidPokemonID != nil && *gym.RaidPokemonID > 0 && gym.RaidLevel != nil {
+		log.Printf("🏟️ Level %d raid of Pokémon #%d at gym %s", *gym.RaidLevel, *gym.RaidPokemonID, gym.ID)
+	}
+}
+
+// Convert a Golbat Pokémon to an encounter, fails if the Pokémon has no IVs or has despawned
+func (pokemon GolbatPokemon) toEncounter() (EncounterData, bool) {
+	if pokemon.IndividualAttack == nil || pokemon.IndividualDefense == nil || pokemon.IndividualStamina == nil || pokemon.PokemonLevel == nil {
+		return EncounterData{}, false
+	}
+	if int64(pokemon.DisappearTime) <= time.Now().Unix() {
+		return EncounterData{}, false
+	}
+	iv := float32(*pokemon.IndividualAttack+*pokemon.IndividualDefense+*pokemon.IndividualStamina) / 45 * 100
+
+	encounter := EncounterData{
+		ID:                      pokemon.EncounterID,
+		Lat:                     float32(pokemon.Latitude),
+		Lon:                     float32(pokemon.Longitude),
+		Weight:                  pokemon.Weight,
+		Size:                    pokemon.Size,
+		Height:                  pokemon.Height,
+		ExpireTimestamp:         &pokemon.DisappearTime,
+		Updated:                 &pokemon.LastModifiedTime,
+		PokemonID:               pokemon.PokemonID,
+		Move1:                   pokemon.Move1,
+		Move2:                   pokemon.Move2,
+		Gender:                  pokemon.Gender,
+		CP:                      pokemon.CP,
+		AtkIV:                   pokemon.IndividualAttack,
+		DefIV:                   pokemon.IndividualDefense,
+		StaIV:                   pokemon.IndividualStamina,
+		Form:                    pokemon.Form,
+		Level:                   pokemon.PokemonLevel,
+		Weather:                 pokemon.Weather,
+		Costume:                 pokemon.Costume,
+		FirstSeenTimestamp:      pokemon.FirstSeen,
+		Changed:                 pokemon.LastModifiedTime,
+		ExpireTimestampVerified: pokemon.DisappearTimeVerified,
+		DisplayPokemonID:        pokemon.DisplayPokemonID,
+		IsDitto:                 pokemon.DisplayPokemonID != nil && pokemon.PokemonID == 132,
+		SeenType:                pokemon.SeenType,
+		Shiny:                   pokemon.Shiny,
+		Username:                pokemon.Username,
+		Capture1:                pokemon.Capture1,
+		Capture2:                pokemon.Capture2,
+		Capture3:                pokemon.Capture3,
+		IsEvent:                 pokemon.IsEvent,
+		IV:                      &iv,
+	}
+	// Golbat sends "None" for unknown spawnpoints and pokestops
+	if spawnID, err := strconv.ParseInt(pokemon.SpawnpointID, 16, 64); err == nil {
+		encounter.SpawnID = &spawnID
+	}
+	if pokemon.PokestopID != "" && pokemon.PokestopID != "None" {
+		encounter.PokestopID = &pokemon.PokestopID
+	}
+	if len(pokemon.PVP) > 0 && string(pokemon.PVP) != "null" {
+		pvp := string(pokemon.PVP)
+		encounter.PVP = &pvp
+	}
+	return encounter, true
+}
+
+// Convert a Golbat gym or raid to a gym
+func (gym GolbatGym) toGym() GymData {
+	data := GymData{
+		ID:                   gym.GymID,
+		Lat:                  gym.Latitude,
+		Lon:                  gym.Longitude,
+		Name:                 gym.Name,
+		Url:                  gym.URL,
+		Description:          gym.Description,
+		Updated:              time.Now().Unix(),
+		TeamID:               gym.TeamID,
+		GuardingPokemonID:    gym.GuardPokemonID,
+		AvailableSlots:       gym.SlotsAvailable,
+		ExRaidEligible:       gym.ExRaidEligible,
+		InBattle:             gym.InBattle,
+		SponsorID:            gym.SponsorID,
+		PartnerID:            gym.PartnerID,
+		PowerUpPoints:        gym.PowerUpPoints,
+		PowerUpLevel:         gym.PowerUpLevel,
+		PowerUpEndTimestamp:  gym.PowerUpEndTimestamp,
+		ArScanEligible:       gym.ArScanEligible,
+		RaidSpawnTimestamp:   gym.Spawn,
+		RaidBattleTimestamp:  gym.Start,
+		RaidEndTimestamp:     gym.End,
+		RaidLevel:            gym.Level,
+		RaidPokemonID:        gym.PokemonID,
+		RaidPokemonCp:        gym.CP,
+		RaidPokemonGender:    gym.Gender,
+		RaidPokemonForm:      gym.Form,
+		RaidPokemonAlignment: gym.Alignment,
+		RaidPokemonCostume:   gym.Costume,
+		RaidPokemonEvolution: gym.Evolution,
+		RaidPokemonMove1:     gym.Move1,
+		RaidPokemonMove2:     gym.Move2,
+		RaidIsExclusive:      gym.IsExclusive,
+	}
+	// Raid messages name the gym fields differently
+	if data.Name == nil {
+		data.Name = gym.GymName
+	}
+	if data.Url == nil {
+		data.Url = gym.GymURL
+	}
+	return data
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func readGolbatFixture(t *testing.T) []byte {
+	t.Helper()
+	data, err := os.ReadFile("testdata/golbat/webhook.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func postGolbatWebhook(source *ScannerSource, body []byte, secret string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, "/golbat", bytes.NewReader(body))
+	if secret != "" {
+		request.Header.Set("X-Golbat-Secret", secret)
+	}
+	recorder := httptest.NewRecorder()
+	handleGolbatWebhook(recorder, request, source)
+	return recorder
+}
+
+func TestGolbatWebhook(t *testing.T) {
+	source := &ScannerSource{Name: "golbat", Type: "webhook", Secret: "secret"}
+
+	response := postGolbatWebhook(source, readGolbatFixture(t), "secret")
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", response.Code, response.Body)
+	}
+	if !source.isActive() {
+		t.Error("source is not active after receiving Pokémon")
+	}
+
+	var received ReceivedEncounters
+	select {
+	case received = <-scannerEncounters:
+	default:
+		t.Fatal("no encounters received")
+	}
+	// The Pokémon seen near a pokestop has not been encountered
+	if received.Source != source || len(received.Encounters) != 1 {
+		t.Fatalf("received %d encounters of %s", len(received.Encounters), received.Source.Name)
+	}
+	encounter := received.Encounters[0]
+	if encounter.ID != "13792481920483718223" || encounter.PokemonID != 132 || !encounter.IsDitto || *encounter.DisplayPokemonID != 16 {
+		t.Errorf("encounter %s of #%d, Ditto %v", encounter.ID, encounter.PokemonID, encounter.IsDitto)
+	}
+	if *encounter.IV != float32(42)/45*100 || *encounter.Level != 30 || *encounter.CP != 1024 {
+		t.Errorf("IV %.2f, level %d, CP %d", *encounter.IV, *encounter.Level, *encounter.CP)
+	}
+	if encounter.SpawnID == nil || *encounter.SpawnID != 0x4D7F2A1 || encounter.PokestopID != nil {
+		t.Errorf("spawnpoint %v, pokestop %v", encounter.SpawnID, encounter.PokestopID)
+	}
+	if *encounter.ExpireTimestamp != 4102444800 || !encounter.ExpireTimestampVerified || *encounter.Updated != 1760780100 {
+		t.Errorf("expiration %d, updated %d", *encounter.ExpireTimestamp, *encounter.Updated)
+	}
+	if encounter.PVP == nil {
+		t.Fatal("PVP rankings missing")
+	}
+	var pvp PVP
+	if err := json.Unmarshal([]byte(*encounter.PVP), &pvp); err != nil || len(pvp["little"]) != 1 || pvp["little"][0].Rank != 12 {
+		t.Errorf("PVP rankings %s: %v", *encounter.PVP, err)
+	}
+}
+
+func TestGolbatWebhookMessages(t *testing.T) {
+	var webhooks []GolbatWebhook
+	if err := json.Unmarshal(readGolbatFixture(t), &webhooks); err != nil {
+		t.Fatal(err)
+	}
+	messages := make(map[string]GolbatWebhook)
+	for _, webhook := range webhooks {
+		messages[webhook.Type] = webhook
+	}
+
+	var gym GolbatGym
+	if !decodeGolbatMessage(messages["raid"], &gym) {
+		t.Fatal("raid not decoded")
+	}
+	raid := gym.toGym()
+	if raid.Name == nil || *raid.Name != "Brandenburger Tor" || raid.Url == nil || *raid.RaidLevel != 5 || *raid.RaidPokemonID != 150 || *raid.RaidEndTimestamp != 1760782700 {
+		t.Errorf("raid %+v", raid)
+	}
+
+	// Messages of another format are noticed
+	invalid := GolbatWebhook{Type: "raid", Message: json.RawMessage(`{"gym_id": "g1", "level": "5"}`)}
+	if decodeGolbatMessage(invalid, &GolbatGym{}) {
+		t.Error("raid with a string level decoded")
+	}
+}
+
+func TestGolbatWebhookReportsInvalidMessages(t *testing.T) {
+	source := &ScannerSource{Name: "golbat", Type: "webhook"}
+	var webhooks []json.RawMessage
+	if err := json.Unmarshal(readGolbatFixture(t), &webhooks); err != nil {
+		t.Fatal(err)
+	}
+	webhooks = append(webhooks, json.RawMessage(`{"type": "pokemon", "message": {"encounter_id": 1}}`))
+	// Types without notifications are not decoded
+	webhooks = append(webhooks, json.RawMessage(`{"type": "weather", "message": {"s2_cell_id": "1"}}`))
+	body, _ := json.Marshal(webhooks)
+
+	response := postGolbatWebhook(source, body, "")
+	if response.Code != http.StatusBadRequest || !bytes.Contains(response.Body.Bytes(), []byte("1 invalid messages")) {
+		t.Errorf("status %d: %s", response.Code, response.Body)
+	}
+	// The valid Pokémon are matched anyway
+	select {
+	case received := <-scannerEncounters:
+		if len(received.Encounters) != 1 {
+			t.Errorf("received %d encounters", len(received.Encounters))
+		}
+	default:
+		t.Error("valid encounters have not been received")
+	}
+}
+
+func TestGolbatWebhookCanceledWhileBusy(t *testing.T) {
+	source := &ScannerSource{Name: "golbat", Type: "webhook"}
+	// The background processing does not take any encounters
+	for len(scannerEncounters) < cap(scannerEncounters) {
+		scannerEncounters <- ReceivedEncounters{}
+	}
+	t.Cleanup(func() {
+		for len(scannerEncounters) > 0 {
+			<-scannerEncounters
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	request := httptest.NewRequest(http.MethodPost, "/golbat", bytes.NewReader(readGolbatFixture(t))).WithContext(ctx)
+	recorder := httptest.NewRecorder()
+	handleGolbatWebhook(recorder, request, source)
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("status %d", recorder.Code)
+	}
+}
+
+func TestGolbatWebhookRejectsRequests(t *testing.T) {
+	source := &ScannerSource{Name: "golbat", Type: "webhook", Secret: "secret"}
+
+	if response := postGolbatWebhook(source, readGolbatFixture(t), "wrong"); response.Code != http.StatusUnauthorized {
+		t.Errorf("wrong secret: status %d", response.Code)
+	}
+	if response := postGolbatWebhook(source, []byte(`{"type": "pokemon"}`), "secret"); response.Code != http.StatusBadRequest {
+		t.Errorf("invalid payload: status %d", response.Code)
+	}
+	request := httptest.NewRequest(http.MethodGet, "/golbat", nil)
+	recorder := httptest.NewRecorder()
+	handleGolbatWebhook(recorder, request, source)
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status %d", recorder.Code)
+	}
+	if source.isActive() || len(scannerEncounters) > 0 {
+		t.Error("rejected requests have been processed")
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/telebot.v3"
+)
+
+// LiveLocation holds the last position reported by a Telegram live location
+type LiveLocation struct {
+	Latitude  float32
+	Longitude float32
+	Timezone  string
+	Until     time.Time
+}
+
+var (
+	liveLocations      = make(map[int64]LiveLocation)
+	liveLocationsMutex sync.RWMutex
+)
+
+// Store or refresh the live location of a user
+func setLiveLocation(userID int64, message *telebot.Message) {
+	location := message.Location
+	liveLocationsMutex.Lock()
+	defer liveLocationsMutex.Unlock()
+	liveLocations[userID] = LiveLocation{
+		Latitude:  location.Lat,
+		Longitude: location.Lng,
+		Timezone:  getTimezoneByLocation(location.Lat, location.Lng),
+		Until:     message.Time().Add(time.Duration(location.LivePeriod) * time.Second),
+	}
+}
+
+// Stop using the live location of a user, returns true if one was active
+func clearLiveLocation(userID int64) bool {
+	liveLocationsMutex.Lock()
+	defer liveLocationsMutex.Unlock()
+	_, exists := liveLocations[userID]
+	delete(liveLocations, userID)
+	return exists
+}
+
+// Get the live location of a user if it is still being shared
+func getLiveLocation(userID int64) (LiveLocation, bool) {
+	liveLocationsMutex.RLock()
+	location, exists := liveLocations[userID]
+	liveLocationsMutex.RUnlock()
+	if !exists {
+		return LiveLocation{}, false
+	}
+	if time.Now().After(location.Until) {
+		clearLiveLocation(userID)
+		return LiveLocation{}, false
+	}
+	return location, true
+}
+
+// Get the effective location of a user: the live location while shared, the saved home location otherwise
+func getUserLocation(user User) (float32, float32) {
+	if location, active := getLiveLocation(user.ID); active {
+		return location.Latitude, location.Longitude
+	}
+	return user.Latitude, user.Longitude
+}
+
+// Get the distance between the effective user location and an encounter.
+// Returns false if the user has no location set.
+func getUserDistance(user User, encounter EncounterData) (float64, bool) {
+	lat, lon := getUserLocation(user)
+	if lat == 0 || lon == 0 {
+		return 0, false
+	}
+	return haversine(float64(lat), float64(lon), float64(encounter.Lat), float64(encounter.Lon)), true
+}
+
+// Sort the pending notifications of users with an active live location by their current distance.
+// The order between users and for all other users is kept as is.
+func sortByLiveDistance(notifications []PendingNotification) []PendingNotification {
+	grouped := make(map[int64][]PendingNotification)
+	var order []int64
+	for _, notification := range notifications {
+		if _, exists := grouped[notification.User.ID]; !exists {
+			order = append(order, notification.User.ID)
+		}
+		grouped[notification.User.ID] = append(grouped[notification.User.ID], notification)
+	}
+
+	sorted := make([]PendingNotification, 0, len(notifications))
+	for _, userID := range order {
+		group := grouped[userID]
+		if _, active := getLiveLocation(userID); active {
+			sort.SliceStable(group, func(i, j int) bool {
+				distanceI, _ := getUserDistance(group[i].User, group[i].Encounter)
+				distanceJ, _ := getUserDistance(group[j].User, group[j].Encounter)
+				return distanceI < distanceJ
+			})
+		}
+		sorted = append(sorted, group...)
+	}
+	return sorted
+}
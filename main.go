@@ -7,13 +7,17 @@ import (
 	"log"
 	"math"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"slices"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
+	"unicode"
 
+	"github.com/glebarez/sqlite"
 	"github.com/joho/godotenv"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -24,20 +28,32 @@ import (
 
 // Models
 type User struct {
-	ID          int64   `gorm:"primaryKey;autoIncrement:false"`
-	Notify      bool    `gorm:"not null;default:true"`
-	Language    string  `gorm:"not null;default:'de';type:varchar(5)"`
-	Stickers    bool    `gorm:"not null;default:true"`
-	OnlyMap     bool    `gorm:"not null;default:false"`
-	Cleanup     bool    `gorm:"not null;default:true"`
-	Latitude    float32 `gorm:"not null;default:0;type:double(14,10)"`
-	Longitude   float32 `gorm:"not null;default:0;type:double(14,10)"`
-	MaxDistance int     `gorm:"not null;default:0;type:mediumint(6)"`
-	HundoIV     bool    `gorm:"not null;default:false"`
-	ZeroIV      bool    `gorm:"not null;default:false"`
-	TopPVP      bool    `gorm:"not null;default:false"`
-	MinIV       int     `gorm:"not null;default:0;type:tinyint(3)"`
-	MinLevel    int     `gorm:"not null;default:0;type:tinyint(2)"`
+	ID             int64   `gorm:"primaryKey;autoIncrement:false"`
+	Notify         bool    `gorm:"not null;default:true"`
+	Language       string  `gorm:"not null;default:'de';size:5"`
+	Stickers       bool    `gorm:"not null;default:true"`
+	OnlyMap        bool    `gorm:"not null;default:false"`
+	Cleanup        bool    `gorm:"not null;default:true"`
+	Latitude       float32 `gorm:"not null;default:0;size:64"`
+	Longitude      float32 `gorm:"not null;default:0;size:64"`
+	MaxDistance    int     `gorm:"not null;default:0;size:24"`
+	HundoIV        bool    `gorm:"not null;default:false"`
+	ZeroIV         bool    `gorm:"not null;default:false"`
+	TopPVP         bool    `gorm:"not null;default:false"`
+	MinIV          int     `gorm:"not null;default:0;size:8"`
+	MinLevel       int     `gorm:"not null;default:0;size:8"`
+	TravelMode     string  `gorm:"not null;default:'';size:5"`
+	TravelSpeed    int     `gorm:"not null;default:0;size:8"`
+	QuietSilent    bool    `gorm:"not null;default:false"`
+	QuietHundo     bool    `gorm:"not null;default:true"`
+	Timezone       string  `gorm:"not null;default:'';size:64"`
+	SnoozedUntil   int64   `gorm:"not null;default:0"`
+	Compact        bool    `gorm:"not null;default:false"`
+	DigestInterval int     `gorm:"not null;default:0;size:16"`
+	Notifier       string  `gorm:"not null;default:'';size:10"`
+	Target         string  `gorm:"not null;default:'';size:255"`
+	Email          string  `gorm:"not null;default:'';size:254"`
+	EmailDigest    string  `gorm:"not null;default:'';size:6"`
 }
 
 type FilteredUsers struct {
@@ -48,23 +64,30 @@ type FilteredUsers struct {
 	Channels []User
 }
 
+type PendingNotification struct {
+	User      User
+	Encounter EncounterData
+	Silent    bool
+}
+
 type Subscription struct {
 	UserID      int64 `gorm:"primaryKey;autoIncrement:false"`
 	PokemonID   int   `gorm:"primaryKey;autoIncrement:false;type=smallint(5)"`
-	MinIV       int   `gorm:"not null;default:0;type:tinyint(3)"`
-	MinLevel    int   `gorm:"not null;default:0;type:tinyint(2)"`
-	MaxDistance int   `gorm:"not null;default:0;type:mediumint(6)"`
+	MinIV       int   `gorm:"not null;default:0;size:8"`
+	MinLevel    int   `gorm:"not null;default:0;size:8"`
+	MaxDistance int   `gorm:"not null;default:0;size:24"`
 }
 
 type Encounter struct {
-	ID         string `gorm:"primaryKey;autoIncrement:false;type:varchar(25)"`
-	Expiration int    `gorm:"index;not null;type:int(10)"`
+	ID         string `gorm:"primaryKey;autoIncrement:false;size:25"`
+	Expiration int    `gorm:"index;not null;size:32"`
 }
 
 type Message struct {
 	ChatID      int64  `gorm:"primaryKey;autoIncrement:false"`
 	MessageID   int    `gorm:"primaryKey;autoIncrement:false"`
-	EncounterID string `gorm:"index;not null;type:varchar(25)"`
+	EncounterID string `gorm:"index;not null;size:25"`
+	Kind        string `gorm:"not null;default:'';size:10"`
 }
 
 type EncounterData struct {
@@ -103,13 +126,71 @@ type EncounterData struct {
 	Username                *string
 	Capture1                *float32 `gorm:"column:capture_1"`
 	Capture2                *float32 `gorm:"column:capture_2"`
-	Capture3                *float32 `gorm:"column:capture_2"`
+	Capture3                *float32 `gorm:"column:capture_3"`
 	PVP                     *string
 	IsEvent                 int
 	IV                      *float32
 	PVPData                 PVP `gorm:"-"`
 }
 
+// Version of the webhook payload, increased on incompatible changes
+const webhookPayloadVersion = 1
+
+// WebhookPayload is the JSON body sent to webhook subscribers for every match
+type WebhookPayload struct {
+	Version   int              `json:"version"`
+	Type      string           `json:"type"`      // Always "match" in version 1
+	Timestamp int64            `json:"timestamp"` // Unix time the match was found
+	Encounter WebhookEncounter `json:"encounter"`
+	Rule      MatchRule        `json:"rule"`
+	Recipient WebhookRecipient `json:"recipient"`
+}
+
+// WebhookEncounter is the encounter of a webhook payload, unknown values are null
+type WebhookEncounter struct {
+	ID              string   `json:"id"`
+	PokemonID       int      `json:"pokemon_id"`
+	PokemonName     string   `json:"pokemon_name"` // English name
+	Form            *int     `json:"form"`
+	Costume         *int     `json:"costume"`
+	Gender          *int     `json:"gender"`
+	Shiny           *bool    `json:"shiny"`
+	Lat             float32  `json:"lat"`
+	Lon             float32  `json:"lon"`
+	IV              *float32 `json:"iv"`
+	AtkIV           *int     `json:"atk_iv"`
+	DefIV           *int     `json:"def_iv"`
+	StaIV           *int     `json:"sta_iv"`
+	CP              *int     `json:"cp"`
+	Level           *int     `json:"level"`
+	Move1           *int     `json:"move_1"`
+	Move2           *int     `json:"move_2"`
+	Size            *int     `json:"size"`
+	Weather         *int     `json:"weather"`
+	ExpireTimestamp int      `json:"expire_timestamp"`
+	ExpireVerified  bool     `json:"expire_timestamp_verified"`
+	PVP             PVP      `json:"pvp,omitempty"`
+	MapURL          string   `json:"map_url"`
+}
+
+// MatchRule is the rule an encounter matched for a recipient
+type MatchRule struct {
+	Type        string `json:"type"` // "subscription", "hundo", "zero", "pvp" or "channel"
+	PokemonID   int    `json:"pokemon_id,omitempty"`
+	MinIV       int    `json:"min_iv,omitempty"`
+	MinLevel    int    `json:"min_level,omitempty"`
+	MaxDistance int    `json:"max_distance,omitempty"` // Meters
+	League      string `json:"league,omitempty"`
+}
+
+// WebhookRecipient is the chat an encounter matched for
+type WebhookRecipient struct {
+	ChatID   int64    `json:"chat_id"`
+	Notifier string   `json:"notifier"` // "telegram", "discord" or "matrix"
+	Language string   `json:"language"`
+	Distance *float64 `json:"distance"` // Meters, null without a known location
+}
+
 type GymData struct {
 	ID                     string
 	Lat                    float64
@@ -130,8 +211,8 @@ type GymData struct {
 	Enabled                *int
 	ExRaidEligible         *int
 	InBattle               *int
-	RaidPokemonMove1       *int
-	RaidPokemonMove2       *int
+	RaidPokemonMove1       *int `gorm:"column:raid_pokemon_move_1"`
+	RaidPokemonMove2       *int `gorm:"column:raid_pokemon_move_2"`
 	RaidPokemonForm        *int
 	RaidPokemonAlignment   *int
 	RaidPokemonCp          *int
@@ -212,13 +293,12 @@ type PVP map[string][]PokemonEntry
 
 var (
 	dbConfig            *gorm.DB // Stores user subscriptions
-	dbScanner           *gorm.DB // Fetches Pokémon encounters
 	bot                 *telebot.Bot
 	botAdmins           map[int64]int64
 	userStates          map[int64]string
 	users               FilteredUsers
 	activeSubscriptions map[int][]Subscription
-	sentNotifications   map[string]map[int64]struct{}
+	sentNotifications   *SentNotificationCache
 	pokemonNameToID     map[string]int
 	MasterFileData      MasterFile
 	TranslationData     map[string]map[string]string
@@ -257,11 +337,12 @@ var (
 			Help: "Total number of expired messages cleaned up",
 		},
 	)
-	encounterGauge = prometheus.NewGauge(
+	encounterGauge = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "bot_encounters_count",
-			Help: "Total number of Pokémon encounters retrieved",
+			Help: "Total number of Pokémon encounters retrieved per scanner source",
 		},
+		[]string{"source"},
 	)
 	usersGauge = prometheus.NewGauge(
 		prometheus.GaugeOpts{
@@ -291,6 +372,16 @@ func (GymData) TableName() string {
 	return "gym"
 }
 
+// Split off the first word of a text, the rest keeps its line breaks
+func cutWord(text string) (string, string) {
+	text = strings.TrimSpace(text)
+	index := strings.IndexFunc(text, unicode.IsSpace)
+	if index < 0 {
+		return text, ""
+	}
+	return text[:index], strings.TrimSpace(text[index:])
+}
+
 func boolToEmoji(value bool) string {
 	if value {
 		return "✅"
@@ -312,6 +403,19 @@ func haversine(lat1, lon1, lat2, lon2 float64) float64 {
 	return R * c
 }
 
+// Get an optional numeric environment variable, falls back to the default if unset
+func getEnvFloat(name string, defaultValue float64) float64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Fatalf("❌ Invalid value for environment variable %s: %v", name, err)
+	}
+	return number
+}
+
 // Check if all required environment variables are set
 func checkEnvVars(vars []string) {
 	for _, v := range vars {
@@ -321,37 +425,45 @@ func checkEnvVars(vars []string) {
 	}
 }
 
-// Initialize Database
-func initDB() {
-	// Load environment variables
-	configDBUser := os.Getenv("BOT_DB_USER")
-	configDBPass := os.Getenv("BOT_DB_PASS")
-	configDBName := os.Getenv("BOT_DB_NAME")
-	configDBHost := os.Getenv("BOT_DB_HOST")
+// Open the bot database selected by BOT_DB_DRIVER, MySQL by default or SQLite for small deployments
+func openConfigDB() (*gorm.DB, error) {
+	switch driver := os.Getenv("BOT_DB_DRIVER"); driver {
+	case "", "mysql":
+		configDBUser := os.Getenv("BOT_DB_USER")
+		configDBPass := os.Getenv("BOT_DB_PASS")
+		configDBName := os.Getenv("BOT_DB_NAME")
+		configDBHost := os.Getenv("BOT_DB_HOST")
+		configDSN := fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=utf8mb4&parseTime=True&loc=Local", configDBUser, configDBPass, configDBHost, configDBName)
+		return gorm.Open(mysql.Open(configDSN), &gorm.Config{})
+	case "sqlite":
+		path := os.Getenv("BOT_DB_PATH")
+		if path == "" {
+			path = "pogobot.db"
+		}
+		// The database is written concurrently, writers wait for each other instead of failing
+		return gorm.Open(sqlite.Open(path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"), &gorm.Config{})
+	default:
+		return nil, fmt.Errorf("unknown database driver: %s", driver)
+	}
+}
 
-	scannerDBUser := os.Getenv("SCANNER_DB_USER")
-	scannerDBPass := os.Getenv("SCANNER_DB_PASS")
-	scannerDBName := os.Getenv("SCANNER_DB_NAME")
-	scannerDBHost := os.Getenv("SCANNER_DB_HOST")
+// Tables of the bot database
+var configModels = []interface{}{&User{}, &Subscription{}, &Message{}, &Encounter{}, &Schedule{}, &NotificationTemplate{}, &ExternalMessage{}, &WebhookSubscriber{}, &WebhookDeadLetter{}, &ScannerCursor{}, &SentNotification{}}
 
+// Initialize Database
+func initDB() {
 	// Bot-specific database (for user subscriptions)
-	configDSN := fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=utf8mb4&parseTime=True&loc=Local", configDBUser, configDBPass, configDBHost, configDBName)
 	var err error
-	dbConfig, err = gorm.Open(mysql.Open(configDSN), &gorm.Config{})
+	dbConfig, err = openConfigDB()
 	if err != nil {
 		log.Fatalf("❌ Failed to connect to bot database: %v", err)
 	}
-	log.Println("✅ Connected to bot database")
+	log.Printf("✅ Connected to bot database (%s)", dbConfig.Name())
 
-	dbConfig.AutoMigrate(&User{}, &Subscription{}, &Message{}, &Encounter{})
+	dbConfig.AutoMigrate(configModels...)
 
-	// Existing Pokémon encounter database
-	scannerDSN := fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=utf8mb4&parseTime=True&loc=Local", scannerDBUser, scannerDBPass, scannerDBHost, scannerDBName)
-	dbScanner, err = gorm.Open(mysql.Open(scannerDSN), &gorm.Config{})
-	if err != nil {
-		log.Fatalf("❌ Failed to connect to encounter database: %v", err)
-	}
-	log.Println("✅ Connected to encounter database")
+	// Existing Pokémon encounter databases
+	connectScannerSources()
 }
 
 func loadMasterFile(filename string) error {
@@ -485,7 +597,7 @@ func getUsersByFilters() {
 			if user.TopPVP {
 				users.TopPVP = append(users.TopPVP, user)
 			}
-			if strings.HasPrefix(strconv.FormatInt(user.ID, 10), "-100") {
+			if isChannel(user) {
 				users.Channels = append(users.Channels, user)
 			}
 		}
@@ -498,7 +610,7 @@ func getActiveSubscriptions() {
 	var subscriptions []Subscription
 	dbConfig.Find(&subscriptions)
 	for _, subscription := range subscriptions {
-		if users.All[subscription.UserID].Notify {
+		if user := users.All[subscription.UserID]; user.Notify && !isSnoozed(user) {
 			activeSubscriptionCount++
 			activeSubscriptions[subscription.PokemonID] = append(activeSubscriptions[subscription.PokemonID], subscription)
 		}
@@ -508,191 +620,108 @@ func getActiveSubscriptions() {
 	activeSubscriptionGauge.Set(float64(activeSubscriptionCount))
 }
 
-func sendSticker(UserID int64, URL string, EncounterID string) error {
-	message, err := bot.Send(&telebot.User{ID: UserID}, &telebot.Sticker{File: telebot.FromURL(URL)}, &telebot.SendOptions{DisableNotification: true})
-	if err != nil {
-		log.Printf("❌ Failed to send sticker: %v", err)
-	} else {
-		messagesCounter.Inc()
-		// Store message ID for cleanup
-		dbConfig.Create(&Message{ChatID: UserID, MessageID: message.ID, EncounterID: EncounterID})
+// Build a sticker message, stickers and locations are sent silently
+func newStickerMessage(UserID int64, Icon string, delivery Delivery) *OutboundMessage {
+	return &OutboundMessage{
+		ChatID:   UserID,
+		What:     &telebot.Sticker{File: getIconFile(Icon)},
+		Options:  &telebot.SendOptions{DisableNotification: true},
+		Kind:     "sticker",
+		Delivery: delivery,
 	}
-	return err
 }
 
-func sendLocation(UserID int64, Lat float32, Lon float32, EncounterID string) error {
-	message, err := bot.Send(&telebot.User{ID: UserID}, &telebot.Location{Lat: Lat, Lng: Lon}, &telebot.SendOptions{DisableNotification: true})
-	if err != nil {
-		log.Printf("❌ Failed to send location: %v", err)
-	} else {
-		messagesCounter.Inc()
-		// Store message ID for cleanup
-		dbConfig.Create(&Message{ChatID: UserID, MessageID: message.ID, EncounterID: EncounterID})
+func newLocationMessage(UserID int64, Lat float32, Lon float32, delivery Delivery) *OutboundMessage {
+	return &OutboundMessage{
+		ChatID:   UserID,
+		What:     &telebot.Location{Lat: Lat, Lng: Lon},
+		Options:  &telebot.SendOptions{DisableNotification: true},
+		Kind:     "location",
+		Delivery: delivery,
 	}
-	return err
 }
 
-func sendVenue(UserID int64, Lat float32, Lon float32, Title string, Address string, EncounterID string) error {
-	message, err := bot.Send(&telebot.User{ID: UserID}, &telebot.Venue{Location: telebot.Location{Lat: Lat, Lng: Lon}, Title: Title, Address: Address})
-	if err != nil {
-		log.Printf("❌ Failed to send venue: %v", err)
-	} else {
-		messagesCounter.Inc()
-		// Store message ID for cleanup
-		dbConfig.Create(&Message{ChatID: UserID, MessageID: message.ID, EncounterID: EncounterID})
+func newVenueMessage(UserID int64, Lat float32, Lon float32, Title string, Address string, delivery Delivery) *OutboundMessage {
+	return &OutboundMessage{
+		ChatID:   UserID,
+		What:     &telebot.Venue{Location: telebot.Location{Lat: Lat, Lng: Lon}, Title: Title, Address: Address},
+		Options:  &telebot.SendOptions{DisableNotification: delivery.Silent},
+		Kind:     "venue",
+		Delivery: delivery,
 	}
-	return err
 }
 
-func sendMessage(UserID int64, Text string, EncounterID string) error {
-	message, err := bot.Send(&telebot.User{ID: UserID}, Text, telebot.ModeMarkdown)
-	if err != nil {
-		log.Printf("❌ Failed to send message: %v", err)
-	} else {
-		messagesCounter.Inc()
-		// Store message ID for cleanup
-		dbConfig.Create(&Message{ChatID: UserID, MessageID: message.ID, EncounterID: EncounterID})
+// Build a photo message, its file is only loaded by the delivery workers
+func newPhotoMessage(UserID int64, PhotoFile func() telebot.File, Caption string, ReplyMarkup *telebot.ReplyMarkup, delivery Delivery) *OutboundMessage {
+	return &OutboundMessage{
+		ChatID:    UserID,
+		What:      &telebot.Photo{Caption: Caption},
+		Options:   &telebot.SendOptions{ParseMode: telebot.ModeHTML, ReplyMarkup: ReplyMarkup, DisableNotification: delivery.Silent},
+		Kind:      "photo",
+		Delivery:  delivery,
+		PhotoFile: PhotoFile,
 	}
-	return err
 }
 
-func sendEncounterNotification(user User, encounter EncounterData) {
+func newTextMessage(UserID int64, Text string, delivery Delivery) *OutboundMessage {
+	return &OutboundMessage{
+		ChatID:   UserID,
+		What:     Text,
+		Options:  &telebot.SendOptions{ParseMode: telebot.ModeHTML, DisableNotification: delivery.Silent},
+		Kind:     "message",
+		Delivery: delivery,
+	}
+}
+
+func sendEncounterNotification(user User, encounter EncounterData, silent bool) {
+	if _, err := getNotifier(user); err != nil {
+		log.Printf("⚠️ Skipping notification for Pokémon #%d to %d: %v", encounter.PokemonID, user.ID, err)
+		return
+	}
 	// Check if encounter has already been notified
-	if _, exists := sentNotifications[encounter.ID][user.ID]; exists {
+	fingerprint := getEncounterFingerprint(encounter)
+	if sentFingerprint, exists := sentNotifications.Get(encounter.ID, user.ID); exists {
+		if sentFingerprint != fingerprint {
+			updateEncounterNotification(user, encounter, fingerprint)
+			return
+		}
 		log.Printf("🔕 Skipping notification for Pokémon #%d to %d (already sent)", encounter.PokemonID, user.ID)
 		return
 	}
 	log.Printf("🔔 Sending notification for Pokémon #%d to %d", encounter.PokemonID, user.ID)
-	dbConfig.Save(&Encounter{ID: encounter.ID, Expiration: *encounter.ExpireTimestamp})
-	if sentNotifications[encounter.ID] == nil {
-		sentNotifications[encounter.ID] = make(map[int64]struct{})
+	delivery := Delivery{
+		EncounterID: encounter.ID,
+		Expiration:  int64(*encounter.ExpireTimestamp),
+		Priority:    getNotificationPriority(user, encounter),
+		Silent:      silent,
 	}
-	sentNotifications[encounter.ID][user.ID] = struct{}{}
-	notificationsCounter.Inc()
 
-	if !user.OnlyMap && user.Stickers {
-		var formSuffix string
-		// Determine if a non-default form sticker should be used.
-		if encounter.Form != nil && *encounter.Form > 0 {
-			pokemonKey := strconv.Itoa(encounter.PokemonID)
-			formKey := strconv.Itoa(*encounter.Form)
-			if pkm, exists := MasterFileData.Pokemon[pokemonKey]; exists {
-				if form, exists := pkm.Forms[formKey]; exists && form.Name != "Normal" {
-					formSuffix = fmt.Sprintf("_f%s", formKey)
-				}
-			}
-		}
-		// Build and send the sticker URL.
-		stickerURL := fmt.Sprintf("https://raw.githubusercontent.com/WatWowMap/wwm-uicons-webp/main/pokemon/%d%s.webp", encounter.PokemonID, formSuffix)
-		sendSticker(user.ID, stickerURL, encounter.ID)
-	}
-	if !user.OnlyMap {
-		sendLocation(user.ID, encounter.Lat, encounter.Lon, encounter.ID)
+	notificationTitle, notificationText := buildEncounterNotification(user, encounter)
+	notification := Notification{Encounter: encounter, Title: notificationTitle, Text: notificationText, Delivery: delivery}
+	if err := sendNotification(user, notification); err != nil {
+		// Not remembered as sent, so it is tried again on the next poll
+		log.Printf("❌ Failed to send notification for Pokémon #%d to %d: %v", encounter.PokemonID, user.ID, err)
+		return
 	}
+	recordEncounter(Encounter{ID: encounter.ID, Expiration: *encounter.ExpireTimestamp})
+	sentNotifications.Set(encounter, user.ID, fingerprint)
+	notificationsCounter.Inc()
+}
 
-	expireTime := time.Unix(int64(*encounter.ExpireTimestamp), 0).In(timezone)
-	timeLeft := time.Until(expireTime)
-
-	notificationTitle := func() string {
-		// Retrieve Pokémon name and form (if applicable)
-		name := getPokemonName(encounter.PokemonID, user.Language)
-
-		formSuffix := ""
-		if encounter.Form != nil && *encounter.Form > 0 {
-			pkm := MasterFileData.Pokemon[strconv.Itoa(encounter.PokemonID)]
-			if form, exists := pkm.Forms[strconv.Itoa(*encounter.Form)]; exists && form.Name != "Normal" {
-				costumeEmoji := ""
-				if form.IsCostume {
-					costumeEmoji = "👕 "
-				}
-				formSuffix = fmt.Sprintf(" (%s%s)", costumeEmoji, getTranslation(form.Name, user.Language))
-			}
-		}
-
-		// Retrieve gender emoji
-		genderEmoji := genderMap[*encounter.Gender]
-
-		// Determine CP / WP string based on language
-		cpLabel := "CP"
-		if user.Language != "en" {
-			cpLabel = "WP"
-		}
-
-		var sizeEmoji string
-		if encounter.Size != nil {
-			switch *encounter.Size {
-			case 1:
-				sizeEmoji = " 🔹"
-			case 5:
-				sizeEmoji = " 🔶"
-			default:
-				sizeEmoji = ""
-			}
-		}
-
-		// Retrieve weather emoji
-		weatherEmoji := " " + weatherMap[*encounter.Weather]
-
-		return fmt.Sprintf("*🔔 %s%s %s %.1f%% %d|%d|%d %d%s L%d*%s%s",
-			name,
-			formSuffix,
-			genderEmoji,
-			*encounter.IV,
-			*encounter.AtkIV,
-			*encounter.DefIV,
-			*encounter.StaIV,
-			*encounter.CP,
-			cpLabel,
-			*encounter.Level,
-			sizeEmoji,
-			weatherEmoji,
-		)
-	}()
-
-	var notificationText strings.Builder
-	if user.Latitude != 0 && user.Longitude != 0 {
-		distance := haversine(float64(user.Latitude), float64(user.Longitude), float64(encounter.Lat), float64(encounter.Lon))
-		if distance < 1000 {
-			notificationText.WriteString(fmt.Sprintf("📍 %.0fm\n", distance))
-		} else {
-			notificationText.WriteString(fmt.Sprintf("📍 %.2fkm\n", distance/1000))
-		}
-	}
-
-	notificationText.WriteString(fmt.Sprintf("💨 %s ⏳ %s\n",
-		expireTime.Format(time.TimeOnly),
-		timeLeft.Truncate(time.Second).String()))
-
-	if encounter.Move1 != nil && encounter.Move2 != nil {
-		notificationText.WriteString(fmt.Sprintf("💥 %s / %s",
-			getMoveName(*encounter.Move1, user.Language),
-			getMoveName(*encounter.Move2, user.Language)))
-	}
-
-	if encounter.PVPData != nil {
-		for league, entries := range encounter.PVPData {
-			// Capitalize the league name
-			leagueName := strings.ToUpper(string(league[0])) + league[1:]
-			for _, entry := range entries {
-				if entry.Rank < 4 {
-					notificationText.WriteString("\n" +
-						getTranslation(fmt.Sprintf("🏅 *%s League Rank", leagueName), user.Language) +
-						fmt.Sprintf(getTranslation(" %d*: %s %dCP L%.1f", user.Language),
-							entry.Rank,
-							getPokemonName(entry.Pokemon, user.Language),
-							entry.CP,
-							entry.Level,
-						))
-				}
-			}
-		}
+// Build the button to send the location of an encounter on demand
+func buildOpenMapButton(user User, encounter EncounterData) *telebot.ReplyMarkup {
+	btnOpenMap := telebot.InlineButton{
+		Text:   getTranslation("📍 Open map", user.Language),
+		Unique: "open_map",
+		Data:   fmt.Sprintf("%.6f|%.6f", encounter.Lat, encounter.Lon),
 	}
+	return &telebot.ReplyMarkup{InlineKeyboard: [][]telebot.InlineButton{{btnOpenMap}}}
+}
 
-	if !user.OnlyMap {
-		sendMessage(user.ID, notificationTitle+"\n"+notificationText.String(), encounter.ID)
-	} else {
-		sendVenue(user.ID, encounter.Lat, encounter.Lon, notificationTitle, notificationText.String(), encounter.ID)
-	}
+// Build the title and text of an encounter notification for a user
+func buildEncounterNotification(user User, encounter EncounterData) (string, string) {
+	data := buildNotificationData(user, encounter)
+	return renderTemplate(user, "title", data), renderTemplate(user, "text", data)
 }
 
 func buildSettings(user User) (string, *telebot.ReplyMarkup) {
@@ -702,6 +731,9 @@ func buildSettings(user User) (string, *telebot.ReplyMarkup) {
 	btnSetDistance := telebot.InlineButton{Text: getTranslation("📏 Set Maximal Distance", user.Language), Unique: "set_distance"}
 	btnSetMinIV := telebot.InlineButton{Text: getTranslation("✨ Set Minimal IV", user.Language), Unique: "set_min_iv"}
 	btnSetMinLevel := telebot.InlineButton{Text: getTranslation("🔢 Set Minimal Level", user.Language), Unique: "set_min_level"}
+	btnSetTravelMode := telebot.InlineButton{Text: getTranslation("🚶 Set Travel Mode", user.Language), Unique: "set_travel_mode"}
+	btnSetTravelSpeed := telebot.InlineButton{Text: getTranslation("⏱️ Set Travel Speed", user.Language), Unique: "set_travel_speed"}
+	btnSetTimezone := telebot.InlineButton{Text: getTranslation("🕐 Set Timezone", user.Language), Unique: "set_timezone"}
 	btnAddSubscription := telebot.InlineButton{Text: getTranslation("📣 Add Pokémon Subscription", user.Language), Unique: "add_subscription"}
 	btnListSubscriptions := telebot.InlineButton{Text: getTranslation("📋 List all Pokémon Subscriptions", user.Language), Unique: "list_subscriptions"}
 	btnClearSubscriptions := telebot.InlineButton{Text: getTranslation("🗑️ Clear all Pokémon Subscriptions", user.Language), Unique: "clear_subscriptions"}
@@ -710,11 +742,20 @@ func buildSettings(user User) (string, *telebot.ReplyMarkup) {
 		notificationsText = getTranslation("🔕 Enable all Notifications", user.Language)
 	}
 	btnToggleNotifications := telebot.InlineButton{Text: notificationsText, Unique: "toggle_notifications"}
+	btnSnooze := telebot.InlineButton{Text: getTranslation("😴 Snooze Notifications", user.Language), Unique: "show_snooze"}
+	if isSnoozed(user) {
+		btnSnooze = telebot.InlineButton{Text: getTranslation("⏰ Resume Notifications", user.Language), Unique: "resume"}
+	}
 	stickersText := getTranslation("🎭 Do not show Pokémon Stickers", user.Language)
 	if !user.Stickers {
 		stickersText = getTranslation("🎭 Show Pokémon Stickers", user.Language)
 	}
 	btnToggleStickers := telebot.InlineButton{Text: stickersText, Unique: "toggle_stickers"}
+	compactText := getTranslation("🖼️ Send separate Messages", user.Language)
+	if !user.Compact {
+		compactText = getTranslation("🖼️ Send a single Message", user.Language)
+	}
+	btnToggleCompact := telebot.InlineButton{Text: compactText, Unique: "toggle_compact"}
 	hundoText := getTranslation("💯 Disable 100% IV Notifications", user.Language)
 	if !user.HundoIV {
 		hundoText = getTranslation("💯 Enable 100% IV Notifications", user.Language)
@@ -735,75 +776,125 @@ func buildSettings(user User) (string, *telebot.ReplyMarkup) {
 		cleanupText = getTranslation("🗑️ Remove Expired Notifications", user.Language)
 	}
 	btnToggleCleanup := telebot.InlineButton{Text: cleanupText, Unique: "toggle_cleanup"}
+	btnSetDigest := telebot.InlineButton{Text: getTranslation("📋 Set Digest", user.Language), Unique: "show_digest"}
+	btnListSchedules := telebot.InlineButton{Text: getTranslation("📅 List Schedules", user.Language), Unique: "list_schedules"}
+	quietSilentText := getTranslation("🌙 Drop Notifications in Quiet Hours", user.Language)
+	if !user.QuietSilent {
+		quietSilentText = getTranslation("🌙 Silence Notifications in Quiet Hours", user.Language)
+	}
+	btnToggleQuietSilent := telebot.InlineButton{Text: quietSilentText, Unique: "toggle_quiet_silent"}
+	quietHundoText := getTranslation("💯 Respect Quiet Hours for 100% IV", user.Language)
+	if !user.QuietHundo {
+		quietHundoText = getTranslation("💯 Ignore Quiet Hours for 100% IV", user.Language)
+	}
+	btnToggleQuietHundo := telebot.InlineButton{Text: quietHundoText, Unique: "toggle_quiet_hundo"}
+	btnLinkNtfy := telebot.InlineButton{Text: getTranslation("📲 Link ntfy Topic", user.Language), Unique: "link_ntfy"}
+	ntfyText := boolToEmoji(false)
+	if user.Notifier == "ntfy" {
+		btnLinkNtfy = telebot.InlineButton{Text: getTranslation("📲 Unlink ntfy Topic", user.Language), Unique: "unlink_ntfy"}
+		ntfyText = user.Target
+	}
+	btnEmailDigest := telebot.InlineButton{Text: getTranslation("📧 Set Email Digest", user.Language), Unique: "show_email"}
 	btnClose := telebot.InlineButton{Text: getTranslation("Close", user.Language), Unique: "close"}
 
+	snoozeText := boolToEmoji(false)
+	if isSnoozed(user) {
+		snoozeText = formatUserDateTime(user, time.Unix(user.SnoozedUntil, 0))
+	}
+
 	// Settings message
-	settingsMessage := fmt.Sprintf(
+	settingsMessage := formatHTML(
 		getTranslation("⚙️ *Your Settings:*", user.Language)+"\n"+
 			"----------------------------------------------\n"+
 			getTranslation("🌍 *Language:* %s", user.Language)+"\n"+
+			getTranslation("🕐 *Timezone:* %s", user.Language)+"\n"+
 			getTranslation("📍 *Location:* %.5f, %.5f", user.Language)+"\n"+
 			getTranslation("📏 *Maximal Distance:* %dm", user.Language)+"\n"+
 			getTranslation("✨ *Minimal IV:* %d%%", user.Language)+"\n"+
 			getTranslation("🔢 *Minimal Level:* %d", user.Language)+"\n"+
+			getTranslation("🚶 *Travel Mode:* %s (%d km/h)", user.Language)+"\n"+
 			getTranslation("🔔 *Notifications:* %s", user.Language)+"\n"+
+			getTranslation("😴 *Snoozed:* %s", user.Language)+"\n"+
+			getTranslation("📋 *Digest:* %s", user.Language)+"\n"+
 			getTranslation("🎭 *Pokémon Stickers:* %s", user.Language)+"\n"+
+			getTranslation("🖼️ *Single Message Notifications:* %s", user.Language)+"\n"+
+			getTranslation("📲 *ntfy Topic:* %s", user.Language)+"\n"+
+			getTranslation("📧 *Email Digest:* %s", user.Language)+"\n"+
 			getTranslation("💯 *100%% IV Notifications:* %s", user.Language)+"\n"+
 			getTranslation("🚫 *0%% IV Notifications:* %s", user.Language)+"\n"+
 			getTranslation("🏅 *Top PVP Notifications:* %s", user.Language)+"\n"+
-			getTranslation("🗑️ *Cleanup Expired Notifications:* %s", user.Language)+"\n\n"+
+			getTranslation("🗑️ *Cleanup Expired Notifications:* %s", user.Language)+"\n"+
+			getTranslation("📅 *Schedules:* %d", user.Language)+"\n"+
+			getTranslation("🌙 *Silent Notifications in Quiet Hours:* %s", user.Language)+"\n"+
+			getTranslation("💯 *100%% IV ignores Quiet Hours:* %s", user.Language)+"\n\n"+
 			getTranslation("Use the buttons below to update the settings", user.Language),
-		user.Language, user.Latitude, user.Longitude,
+		user.Language, getUserTimezone(user), user.Latitude, user.Longitude,
 		user.MaxDistance, user.MinIV, user.MinLevel,
-		boolToEmoji(user.Notify), boolToEmoji(user.Stickers),
-		boolToEmoji(user.HundoIV), boolToEmoji(user.ZeroIV),
+		getTravelModeName(user.TravelMode, user.Language), getTravelSpeed(user),
+		boolToEmoji(user.Notify), snoozeText, formatDigestInterval(user.DigestInterval, user.Language), boolToEmoji(user.Stickers), boolToEmoji(user.Compact),
+		ntfyText, formatEmailDigest(user), boolToEmoji(user.HundoIV), boolToEmoji(user.ZeroIV),
 		boolToEmoji(user.TopPVP), boolToEmoji(user.Cleanup),
+		len(schedules[user.ID]), boolToEmoji(user.QuietSilent), boolToEmoji(user.QuietHundo),
 	)
 
-	if strings.HasPrefix(strconv.FormatInt(user.ID, 10), "-100") {
-		chat, _ := bot.ChatByID(user.ID)
+	if isChannel(user) {
 		// Settings message
-		settingsMessage = fmt.Sprintf(
+		settingsMessage = formatHTML(
 			getTranslation("⚙️ *Channel Settings:*", user.Language)+"\n"+
 				"----------------------------------------------\n"+
 				getTranslation("#️⃣ *Channel ID:* %d", user.Language)+"\n"+
 				getTranslation("#️⃣ *Channel Name:* %s", user.Language)+"\n"+
 				getTranslation("🌍 *Language:* %s", user.Language)+"\n"+
+				getTranslation("🕐 *Timezone:* %s", user.Language)+"\n"+
 				getTranslation("✨ *Minimal IV:* %d%%", user.Language)+"\n"+
 				getTranslation("🔢 *Minimal Level:* %d", user.Language)+"\n"+
 				getTranslation("🔔 *Notifications:* %s", user.Language)+"\n"+
+				getTranslation("📋 *Digest:* %s", user.Language)+"\n"+
 				getTranslation("🎭 *Pokémon Stickers:* %s", user.Language)+"\n"+
+				getTranslation("🖼️ *Single Message Notifications:* %s", user.Language)+"\n"+
 				getTranslation("💯 *100%% IV Notifications:* %s", user.Language)+"\n"+
 				getTranslation("🚫 *0%% IV Notifications:* %s", user.Language)+"\n"+
 				getTranslation("🏅 *Top PVP Notifications:* %s", user.Language)+"\n"+
 				getTranslation("🗑️ *Cleanup Expired Notifications:* %s", user.Language)+"\n\n"+
 				getTranslation("Use the buttons below to update the settings", user.Language),
-			user.ID, chat.Title, user.Language, user.MinIV, user.MinLevel,
-			boolToEmoji(user.Notify), boolToEmoji(user.Stickers),
+			user.ID, getChatTitle(user), user.Language, getUserTimezone(user), user.MinIV, user.MinLevel,
+			boolToEmoji(user.Notify), formatDigestInterval(user.DigestInterval, user.Language), boolToEmoji(user.Stickers), boolToEmoji(user.Compact),
 			boolToEmoji(user.HundoIV), boolToEmoji(user.ZeroIV),
 			boolToEmoji(user.TopPVP), boolToEmoji(user.Cleanup),
 		)
 	}
 
 	inlineKeyboard := [][]telebot.InlineButton{
-		{btnChangeLanguage},
+		{btnChangeLanguage, btnSetTimezone},
 		{btnUpdateLocation},
 		{btnSetDistance},
 		{btnSetMinIV},
 		{btnSetMinLevel},
+		{btnSetTravelMode, btnSetTravelSpeed},
 		{btnAddSubscription},
 		{btnListSubscriptions},
 		{btnClearSubscriptions},
 		{btnToggleNotifications},
+		{btnSnooze},
+		{btnSetDigest},
 		{btnToggleStickers},
+		{btnToggleCompact},
 		{btnToogleHundoIV},
 		{btnToogleZeroIV},
 		{btnToogleTopPVP},
 		{btnToggleCleanup},
+		{btnListSchedules},
+		{btnToggleQuietSilent},
+		{btnToggleQuietHundo},
 		{btnClose},
 	}
 
-	if strings.HasPrefix(strconv.FormatInt(user.ID, 10), "-100") {
+	if !isChannel(user) {
+		// Channels are linked to other notifiers by admins
+		inlineKeyboard = slices.Insert(inlineKeyboard, len(inlineKeyboard)-1, []telebot.InlineButton{btnLinkNtfy}, []telebot.InlineButton{btnEmailDigest})
+	}
+
+	if isChannel(user) {
 		btnReset := telebot.InlineButton{Text: getTranslation("🔄 Reset", user.Language), Unique: "reset"}
 		inlineKeyboard = append(inlineKeyboard, []telebot.InlineButton{btnReset})
 	} else if _, ok := botAdmins[user.ID]; ok {
@@ -882,14 +973,14 @@ func setupBotHandlers() {
 		user := getUserPreferences(getUserID(c))
 
 		var text strings.Builder
-		text.WriteString(getTranslation("📋 *Your Pokémon Subscriptions:*", user.Language) + "\n\n")
+		text.WriteString(markupHTML(getTranslation("📋 *Your Pokémon Subscriptions:*", user.Language)) + "\n\n")
 		if user.HundoIV {
-			text.WriteString(fmt.Sprintf(getTranslation("🔹 *All* (Min IV: 100%%, Min Level: 0, Max Distance: %dm)", user.Language)+"\n", user.MaxDistance))
+			text.WriteString(formatHTML(getTranslation("🔹 *All* (Min IV: 100%%, Min Level: 0, Max Distance: %dm)", user.Language)+"\n", user.MaxDistance))
 		}
 		if user.ZeroIV {
-			text.WriteString(fmt.Sprintf(getTranslation("🔹 *All* (Max IV: 0%%, Min Level: 0, Max Distance: %dm", user.Language)+"\n", user.MaxDistance))
+			text.WriteString(formatHTML(getTranslation("🔹 *All* (Max IV: 0%%, Min Level: 0, Max Distance: %dm", user.Language)+"\n", user.MaxDistance))
 		}
-		c.Send(text.String(), telebot.ModeMarkdown)
+		c.Send(text.String(), telebot.ModeHTML)
 		text.Reset()
 
 		var subs []Subscription
@@ -939,6 +1030,119 @@ func setupBotHandlers() {
 		return c.Send(fmt.Sprintf(getTranslation("✅ Unsubscribed from %s alerts", language), getPokemonName(pokemonID, user.Language)))
 	})
 
+	// /snooze [duration|tomorrow|off]
+	bot.Handle("/snooze", func(c telebot.Context) error {
+		userID := getUserID(c)
+		user := getUserPreferences(userID)
+
+		args := c.Args()
+		if len(args) < 1 {
+			return c.Send(getTranslation("😴 How long do you want to pause notifications?", user.Language), buildSnoozeButtons(user.Language))
+		}
+		if args[0] == "off" {
+			resumeUser(userID)
+			return c.Send(getTranslation("⏰ Notifications resumed", user.Language))
+		}
+
+		until, err := parseSnooze(user, args[0])
+		if err != nil {
+			return c.Send(getTranslation("ℹ️ Usage: /snooze [30m|2h|1d|tomorrow|off]", user.Language))
+		}
+		snoozeUser(userID, until)
+		return c.Send(fmt.Sprintf(getTranslation("😴 Notifications paused until %s", user.Language), formatUserDateTime(user, until)))
+	})
+
+	// /digest [minutes|off]
+	bot.Handle("/digest", func(c telebot.Context) error {
+		userID := getUserID(c)
+		user := getUserPreferences(userID)
+
+		args := c.Args()
+		if len(args) < 1 {
+			return c.Send(getTranslation("📋 How often do you want to receive a digest of all matching Pokémon?", user.Language), buildDigestButtons(user.Language))
+		}
+		minutes, err := parseDigestInterval(args[0])
+		if err != nil {
+			return c.Send(getTranslation("ℹ️ Usage: /digest [minutes|off]", user.Language))
+		}
+		setDigestInterval(userID, minutes)
+		if minutes == 0 {
+			return c.Send(getTranslation("🔔 You will be notified about every Pokémon again", user.Language))
+		}
+		return c.Send(fmt.Sprintf(getTranslation("📋 You will receive a digest every %d minutes", user.Language), minutes))
+	})
+
+	// /template [preview | set <kind> <template> | reset <kind>]
+	bot.Handle("/template", func(c telebot.Context) error {
+		user := getUserPreferences(getUserID(c))
+		usage := getTranslation("ℹ️ Usage: /template [preview] [set <title|text> <template>] [reset <title|text>]", user.Language)
+
+		action, rest := cutWord(c.Message().Payload)
+		switch action {
+		case "":
+			var text strings.Builder
+			text.WriteString(markupHTML(getTranslation("📝 *Your Notification Templates:*", user.Language)) + "\n")
+			for _, kind := range templateKinds {
+				text.WriteString(formatHTML("\n*%s*\n```\n%s\n```\n", kind, getTemplateText(user, kind)))
+			}
+			text.WriteString("\n" + escapeHTML(usage))
+			return c.Send(text.String(), telebot.ModeHTML)
+		case "preview":
+			title, text := buildEncounterNotification(user, getSampleEncounter())
+			if err := c.Send(title+"\n"+text, telebot.ModeHTML); err != nil {
+				return c.Send(fmt.Sprintf(getTranslation("❌ Failed to send preview: %v", user.Language), err))
+			}
+			return nil
+		case "set", "reset":
+			kind, template := cutWord(rest)
+			if !slices.Contains(templateKinds, kind) || (action == "set" && template == "") {
+				return c.Send(usage)
+			}
+			if action == "reset" {
+				resetTemplate(user.ID, kind)
+				return c.Send(fmt.Sprintf(getTranslation("✅ Template %s reset to default", user.Language), kind))
+			}
+			if err := setTemplate(user, kind, template); err != nil {
+				return c.Send(fmt.Sprintf(getTranslation("❌ Invalid template: %v", user.Language), err))
+			}
+			return c.Send(fmt.Sprintf(getTranslation("✅ Template %s saved, use /template preview to check it", user.Language), kind))
+		}
+		return c.Send(usage)
+	})
+
+	// /schedule [quiet|only <days> <from>-<to> | clear]
+	bot.Handle("/schedule", func(c telebot.Context) error {
+		userID := getUserID(c)
+		language := users.All[userID].Language
+
+		args := c.Args()
+		if len(args) == 1 && args[0] == "clear" {
+			clearSchedules(userID)
+			return c.Send(getTranslation("🗑️ All schedules cleared", language))
+		}
+
+		if len(args) == 3 && (args[0] == "quiet" || args[0] == "only") {
+			days, err := parseDays(args[1])
+			if err != nil {
+				return c.Send(fmt.Sprintf(getTranslation("❌ Invalid days: %s", language), args[1]))
+			}
+			start, end, err := parseTimeRange(args[2])
+			if err != nil {
+				return c.Send(fmt.Sprintf(getTranslation("❌ Invalid time range: %s", language), args[2]))
+			}
+			schedule := Schedule{UserID: userID, Quiet: args[0] == "quiet", Days: days, Start: start, End: end}
+			addSchedule(schedule)
+			return c.Send(fmt.Sprintf(getTranslation("✅ Schedule added: %s", language), formatSchedule(schedule, language)))
+		}
+
+		if len(args) > 0 {
+			return c.Send(getTranslation("ℹ️ Usage: /schedule [quiet|only <days> <from>-<to>] [clear]", language) + "\n" +
+				getTranslation("ℹ️ Example: /schedule quiet daily 23:00-07:00 or /schedule only mon-fri 17:00-24:00", language))
+		}
+
+		return c.Send(buildScheduleList(userID, language), telebot.ModeHTML)
+	})
+
 	bot.Handle("/wo", func(c telebot.Context) error {
 		return bot.Trigger("/locate", c)
 	})
@@ -954,8 +1158,7 @@ func setupBotHandlers() {
 
 		gymName := strings.Join(args, " ")
 
-		var gyms []GymData
-		dbScanner.Where("lower(name) LIKE ?", "%"+strings.ToLower(gymName)+"%").Find(&gyms)
+		gyms := searchGyms(gymName)
 		if len(gyms) == 0 {
 			return c.Send(fmt.Sprintf(getTranslation("❌ Can't find gym: %s", language), gymName))
 		} else if len(gyms) > 1 {
@@ -972,7 +1175,7 @@ func setupBotHandlers() {
 			btnClose := telebot.InlineButton{Text: getTranslation("Close", language), Unique: "close"}
 			inlineKeyboard = append(inlineKeyboard, []telebot.InlineButton{btnClose})
 
-			return c.Send(text, &telebot.ReplyMarkup{InlineKeyboard: inlineKeyboard}, telebot.ModeMarkdown)
+			return c.Send(escapeHTML(text), &telebot.ReplyMarkup{InlineKeyboard: inlineKeyboard}, telebot.ModeHTML)
 		}
 		gym := gyms[0]
 		return c.Send(&telebot.Venue{Location: telebot.Location{Lat: float32(gym.Lat), Lng: float32(gym.Lon)}, Title: *gym.Name})
@@ -983,23 +1186,14 @@ func setupBotHandlers() {
 		if gymID == "" {
 			return c.Send("❌ Invalid Gym ID")
 		}
-		var gym GymData
-		dbScanner.First(&gym, GymData{ID: gymID})
+		gym, ok := getGym(gymID)
 		c.Delete()
+		if !ok {
+			return c.Send("❌ Invalid Gym ID")
+		}
 		return c.Send(&telebot.Venue{Location: telebot.Location{Lat: float32(gym.Lat), Lng: float32(gym.Lon)}, Title: *gym.Name})
 	})
 
-	bot.Handle(telebot.OnLocation, func(c telebot.Context) error {
-		userID := getUserID(c)
-		language := users.All[userID].Language
-		location := c.Message().Location
-
-		updateUserPreference(userID, "Latitude", location.Lat)
-		updateUserPreference(userID, "Longitude", location.Lng)
-
-		return c.Send(getTranslation("📍 Location updated! Your preferences will now consider this", language))
-	})
-
 	bot.Handle("/start", func(c telebot.Context) error {
 		user := getUserPreferences(getUserID(c))
 
@@ -1010,7 +1204,7 @@ func setupBotHandlers() {
 		updateUserPreference(user.ID, "Language", lang)
 
 		// Welcome message
-		startMessage := fmt.Sprintf(
+		startMessage := formatHTML(
 			getTranslation("👋 Welcome to the PoGo Notification Bot!", lang)+"\n\n"+
 				getTranslation("ℹ️ Language detected: *%s*", lang)+"\n"+
 				getTranslation("ℹ️ Use /settings to update your preferences", lang)+"\n"+
@@ -1019,14 +1213,14 @@ func setupBotHandlers() {
 			lang,
 		)
 
-		return c.Send(startMessage)
+		return c.Send(startMessage, telebot.ModeHTML)
 	})
 
 	bot.Handle("/settings", func(c telebot.Context) error {
 		userID := getUserID(c)
 		user := getUserPreferences(userID)
 		settingsMessage, replyMarkup := buildSettings(user)
-		return c.Send(settingsMessage, replyMarkup, telebot.ModeMarkdown)
+		return c.Send(settingsMessage, replyMarkup, telebot.ModeHTML)
 	})
 
 	bot.Handle("/help", func(c telebot.Context) error {
@@ -1036,8 +1230,141 @@ func setupBotHandlers() {
 			getTranslation("🔔 /settings - Update your preferences", language) + "\n" +
 			getTranslation("📋 /list - List your Pokémon subscriptions", language) + "\n" +
 			getTranslation("📣 /subscribe <pokemon-name> [min-iv] [min-level] [max-distance] - Subscribe to Pokémon alerts", language) + "\n" +
-			getTranslation("🚫 /unsubscribe <pokemon-name> - Unsubscribe from Pokémon alerts", language)
-		return c.Send(helpMessage, telebot.ModeMarkdown)
+			getTranslation("🚫 /unsubscribe <pokemon-name> - Unsubscribe from Pokémon alerts", language) + "\n" +
+			getTranslation("😴 /snooze [30m|2h|1d|tomorrow|off] - Pause notifications for a while", language) + "\n" +
+			getTranslation("📋 /digest [minutes|off] - Receive a periodic summary instead of single notifications", language) + "\n" +
+			getTranslation("📅 /schedule [quiet|only <days> <from>-<to>] [clear] - Manage quiet hours and notification schedules", language) + "\n" +
+			getTranslation("📝 /template [preview] [set <title|text> <template>] [reset <title|text>] - Customise your notifications", language)
+		return c.Send(markupHTML(helpMessage), telebot.ModeHTML)
+	})
+
+	// /discord <webhook-url>
+	bot.Handle("/discord", func(c telebot.Context) error {
+		userID := c.Sender().ID
+		language := users.All[userID].Language
+		if _, ok := botAdmins[userID]; !ok {
+			return c.Send(getTranslation("❌ You are not authorized to use this command", language))
+		}
+
+		args := c.Args()
+		if len(args) < 1 {
+			return c.Send(getTranslation("ℹ️ Usage: /discord <webhook-url>", language))
+		}
+		webhookURL, err := url.Parse(args[0])
+		if err != nil || webhookURL.Scheme != "https" || !strings.Contains(webhookURL.Path, "/api/webhooks/") {
+			return c.Send(getTranslation("❌ Invalid Discord webhook URL", language))
+		}
+		c.Delete() // The webhook URL contains its token
+
+		// Edit the new channel like a Telegram channel
+		channel := addExternalTarget("discord", webhookURL.String(), language)
+		botAdmins[userID] = channel.ID
+		return bot.Trigger("/settings", c)
+	})
+
+	// /matrix <room-id|room-alias>
+	bot.Handle("/matrix", func(c telebot.Context) error {
+		userID := c.Sender().ID
+		language := users.All[userID].Language
+		if _, ok := botAdmins[userID]; !ok {
+			return c.Send(getTranslation("❌ You are not authorized to use this command", language))
+		}
+
+		matrix, enabled := notifiers["matrix"].(*MatrixNotifier)
+		if !enabled {
+			return c.Send(getTranslation("❌ Matrix is not configured", language))
+		}
+		args := c.Args()
+		if len(args) < 1 {
+			return c.Send(getTranslation("ℹ️ Usage: /matrix <room-id>", language))
+		}
+		roomID, err := matrix.Join(args[0])
+		if err != nil {
+			return c.Send(fmt.Sprintf(getTranslation("❌ Failed to join Matrix room: %v", language), err))
+		}
+
+		// Edit the new channel like a Telegram channel
+		channel := addExternalTarget("matrix", roomID, language)
+		botAdmins[userID] = channel.ID
+		return bot.Trigger("/settings", c)
+	})
+
+	// /webhook [list|add <url> [chat-id]|remove <id>|retry]
+	bot.Handle("/webhook", func(c telebot.Context) error {
+		userID := c.Sender().ID
+		language := users.All[userID].Language
+		if _, ok := botAdmins[userID]; !ok {
+			return c.Send(getTranslation("❌ You are not authorized to use this command", language))
+		}
+		usage := getTranslation("ℹ️ Usage: /webhook [list|add <url> [chat-id]|remove <id>|retry]", language)
+
+		args := c.Args()
+		action := "list"
+		if len(args) > 0 {
+			action = args[0]
+		}
+		switch action {
+		case "list":
+			webhookMutex.RLock()
+			subscribers := webhookSubscribers
+			webhookMutex.RUnlock()
+			if len(subscribers) == 0 {
+				return c.Send(getTranslation("ℹ️ No webhooks configured", language))
+			}
+			deadLetters := countDeadLetters()
+			var text strings.Builder
+			text.WriteString(markupHTML(getTranslation("🔗 *Webhooks:*", language)) + "\n")
+			for _, subscriber := range subscribers {
+				chats := getTranslation("all chats", language)
+				if subscriber.ChatID != 0 {
+					chats = fmt.Sprintf(getTranslation("chat %d", language), subscriber.ChatID)
+				}
+				text.WriteString(formatHTML("\n🔹 *%d:* %s (%s)", subscriber.ID, subscriber.URL, chats))
+				if deadLetters[subscriber.ID] > 0 {
+					text.WriteString(formatHTML(getTranslation(", %d failed", language), deadLetters[subscriber.ID]))
+				}
+			}
+			return c.Send(text.String(), telebot.ModeHTML)
+
+		case "add":
+			if len(args) < 2 {
+				return c.Send(usage)
+			}
+			webhookURL, err := url.Parse(args[1])
+			if err != nil || (webhookURL.Scheme != "https" && webhookURL.Scheme != "http") || webhookURL.Host == "" {
+				return c.Send(getTranslation("❌ Invalid webhook URL", language))
+			}
+			var chatID int64
+			if len(args) > 2 {
+				if chatID, err = strconv.ParseInt(args[2], 10, 64); err != nil {
+					return c.Send(usage)
+				}
+			}
+			subscriber, err := addWebhookSubscriber(webhookURL.String(), chatID)
+			if err != nil {
+				log.Printf("❌ Failed to add webhook: %v", err)
+				return c.Send(getTranslation("❌ Failed to add webhook", language))
+			}
+			log.Printf("🔗 Webhook %d added for %s", subscriber.ID, webhookURL.Host)
+			return c.Send(formatHTML(getTranslation("✅ Webhook %d added, requests are signed with the secret `%s`", language), subscriber.ID, subscriber.Secret), telebot.ModeHTML)
+
+		case "remove":
+			if len(args) < 2 {
+				return c.Send(usage)
+			}
+			id, err := strconv.ParseUint(args[1], 10, 32)
+			if err != nil {
+				return c.Send(usage)
+			}
+			if !removeWebhookSubscriber(uint(id)) {
+				return c.Send(fmt.Sprintf(getTranslation("❌ Webhook %d not found", language), id))
+			}
+			return c.Send(fmt.Sprintf(getTranslation("✅ Webhook %d removed", language), id))
+
+		case "retry":
+			return c.Send(fmt.Sprintf(getTranslation("🔁 Retrying %d failed webhook requests", language), retryDeadLetters()))
+		}
+		return c.Send(usage)
 	})
 
 	bot.Handle("/reset", func(c telebot.Context) error {
@@ -1047,7 +1374,7 @@ func setupBotHandlers() {
 			return c.Send(getTranslation("❌ You are not authorized to use this command", language))
 		}
 		if botAdmins[userID] == userID {
-			return c.Send(getTranslation("🔒 You are not impersonating another user", language), telebot.ModeMarkdown)
+			return c.Send(getTranslation("🔒 You are not impersonating another user", language))
 		}
 		botAdmins[userID] = userID
 		return c.Send(getTranslation("🔒 You are now back as yourself", language))
@@ -1088,7 +1415,89 @@ func setupBotHandlers() {
 		updateUserPreference(user.ID, "Notify", user.Notify)
 		getActiveSubscriptions()
 		settingsMessage, replyMarkup := buildSettings(user)
-		return c.Edit(settingsMessage, replyMarkup, telebot.ModeMarkdown)
+		return c.Edit(settingsMessage, replyMarkup, telebot.ModeHTML)
+	})
+
+	bot.Handle(&telebot.InlineButton{Unique: "show_snooze"}, func(c telebot.Context) error {
+		userID := c.Sender().ID
+		language := users.All[userID].Language
+		return c.Edit(getTranslation("😴 How long do you want to pause notifications?", language), buildSnoozeButtons(language))
+	})
+
+	bot.Handle(&telebot.InlineButton{Unique: "snooze"}, func(c telebot.Context) error {
+		user := getUserPreferences(getUserID(c))
+		until, err := parseSnooze(user, c.Callback().Data)
+		if err != nil {
+			return c.Edit(getTranslation("❌ Invalid snooze duration", user.Language))
+		}
+		snoozeUser(user.ID, until)
+		return c.Edit(fmt.Sprintf(getTranslation("😴 Notifications paused until %s", user.Language), formatUserDateTime(user, until)))
+	})
+
+	bot.Handle(&telebot.InlineButton{Unique: "show_digest"}, func(c telebot.Context) error {
+		userID := c.Sender().ID
+		language := users.All[userID].Language
+		return c.Edit(getTranslation("📋 How often do you want to receive a digest of all matching Pokémon?", language), buildDigestButtons(language))
+	})
+
+	bot.Handle(&telebot.InlineButton{Unique: "digest"}, func(c telebot.Context) error {
+		user := getUserPreferences(getUserID(c))
+		minutes, err := parseDigestInterval(c.Callback().Data)
+		if err != nil {
+			return c.Edit(getTranslation("❌ Invalid digest interval", user.Language))
+		}
+		setDigestInterval(user.ID, minutes)
+		user.DigestInterval = minutes
+		settingsMessage, replyMarkup := buildSettings(user)
+		return c.Edit(settingsMessage, replyMarkup, telebot.ModeHTML)
+	})
+
+	bot.Handle(&telebot.InlineButton{Unique: "show_email"}, func(c telebot.Context) error {
+		user := getUserPreferences(getUserID(c))
+		if mailer == nil {
+			return c.Edit(getTranslation("❌ Emails are not configured", user.Language))
+		}
+		return c.Edit(
+			formatHTML(getTranslation("📧 *Email Digest:* %s", user.Language)+"\n\n"+getTranslation("Receive an hourly or daily email listing the best matching Pokémon. Set and confirm your email address first.", user.Language), formatEmailDigest(user)),
+			buildEmailDigestButtons(user), telebot.ModeHTML,
+		)
+	})
+
+	bot.Handle(&telebot.InlineButton{Unique: "set_email"}, func(c telebot.Context) error {
+		userID := c.Sender().ID
+		language := users.All[userID].Language
+		userStates[userID] = "set_email"
+		return c.Edit(getTranslation("📧 Enter your email address:", language))
+	})
+
+	bot.Handle(&telebot.InlineButton{Unique: "email_digest"}, func(c telebot.Context) error {
+		user := getUserPreferences(getUserID(c))
+		schedule := c.Callback().Data
+		if schedule == "off" {
+			schedule = ""
+		} else if !slices.Contains(emailDigestSchedules, schedule) || user.Email == "" {
+			return c.Edit(getTranslation("❌ Invalid email digest schedule", user.Language))
+		}
+		setEmailDigest(user.ID, schedule)
+		user.EmailDigest = schedule
+		settingsMessage, replyMarkup := buildSettings(user)
+		return c.Edit(settingsMessage, replyMarkup, telebot.ModeHTML)
+	})
+
+	bot.Handle(&telebot.InlineButton{Unique: "remove_email"}, func(c telebot.Context) error {
+		user := getUserPreferences(getUserID(c))
+		removeEmail(user.ID)
+		user.Email, user.EmailDigest = "", ""
+		settingsMessage, replyMarkup := buildSettings(user)
+		return c.Edit(settingsMessage, replyMarkup, telebot.ModeHTML)
+	})
+
+	bot.Handle(&telebot.InlineButton{Unique: "resume"}, func(c telebot.Context) error {
+		user := getUserPreferences(getUserID(c))
+		user.SnoozedUntil = 0
+		resumeUser(user.ID)
+		settingsMessage, replyMarkup := buildSettings(user)
+		return c.Edit(settingsMessage, replyMarkup, telebot.ModeHTML)
 	})
 
 	bot.Handle(&telebot.InlineButton{Unique: "toggle_stickers"}, func(c telebot.Context) error {
@@ -1096,7 +1505,41 @@ func setupBotHandlers() {
 		user.Stickers = !user.Stickers
 		updateUserPreference(user.ID, "Stickers", user.Stickers)
 		settingsMessage, replyMarkup := buildSettings(user)
-		return c.Edit(settingsMessage, replyMarkup, telebot.ModeMarkdown)
+		return c.Edit(settingsMessage, replyMarkup, telebot.ModeHTML)
+	})
+
+	bot.Handle(&telebot.InlineButton{Unique: "toggle_compact"}, func(c telebot.Context) error {
+		user := getUserPreferences(getUserID(c))
+		user.Compact = !user.Compact
+		updateUserPreference(user.ID, "Compact", user.Compact)
+		settingsMessage, replyMarkup := buildSettings(user)
+		return c.Edit(settingsMessage, replyMarkup, telebot.ModeHTML)
+	})
+
+	bot.Handle(&telebot.InlineButton{Unique: "open_map"}, func(c telebot.Context) error {
+		lat, lon, found := strings.Cut(c.Data(), "|")
+		latitude, err := strconv.ParseFloat(lat, 32)
+		if err != nil || !found {
+			return c.Respond()
+		}
+		longitude, err := strconv.ParseFloat(lon, 32)
+		if err != nil {
+			return c.Respond()
+		}
+		c.Respond()
+		sent, err := bot.Send(c.Chat(), &telebot.Location{Lat: float32(latitude), Lng: float32(longitude)}, &telebot.SendOptions{
+			ReplyTo:             c.Message(),
+			DisableNotification: true,
+		})
+		if err != nil {
+			return err
+		}
+		// Remove the location together with the notification
+		var notification Message
+		if dbConfig.Where("chat_id = ? AND message_id = ?", c.Chat().ID, c.Message().ID).Limit(1).Find(&notification).RowsAffected > 0 {
+			recordMessage(Message{ChatID: sent.Chat.ID, MessageID: sent.ID, EncounterID: notification.EncounterID, Kind: "location"})
+		}
+		return nil
 	})
 
 	bot.Handle(&telebot.InlineButton{Unique: "toggle_hundo_iv"}, func(c telebot.Context) error {
@@ -1104,7 +1547,7 @@ func setupBotHandlers() {
 		user.HundoIV = !user.HundoIV
 		updateUserPreference(user.ID, "HundoIV", user.HundoIV)
 		settingsMessage, replyMarkup := buildSettings(user)
-		return c.Edit(settingsMessage, replyMarkup, telebot.ModeMarkdown)
+		return c.Edit(settingsMessage, replyMarkup, telebot.ModeHTML)
 	})
 
 	bot.Handle(&telebot.InlineButton{Unique: "toggle_zero_iv"}, func(c telebot.Context) error {
@@ -1112,7 +1555,7 @@ func setupBotHandlers() {
 		user.ZeroIV = !user.ZeroIV
 		updateUserPreference(user.ID, "ZeroIV", user.ZeroIV)
 		settingsMessage, replyMarkup := buildSettings(user)
-		return c.Edit(settingsMessage, replyMarkup, telebot.ModeMarkdown)
+		return c.Edit(settingsMessage, replyMarkup, telebot.ModeHTML)
 	})
 
 	bot.Handle(&telebot.InlineButton{Unique: "toggle_top_pvp"}, func(c telebot.Context) error {
@@ -1120,7 +1563,7 @@ func setupBotHandlers() {
 		user.TopPVP = !user.TopPVP
 		updateUserPreference(user.ID, "TopPVP", user.TopPVP)
 		settingsMessage, replyMarkup := buildSettings(user)
-		return c.Edit(settingsMessage, replyMarkup, telebot.ModeMarkdown)
+		return c.Edit(settingsMessage, replyMarkup, telebot.ModeHTML)
 	})
 
 	bot.Handle(&telebot.InlineButton{Unique: "toggle_cleanup"}, func(c telebot.Context) error {
@@ -1128,7 +1571,29 @@ func setupBotHandlers() {
 		user.Cleanup = !user.Cleanup
 		updateUserPreference(user.ID, "Cleanup", user.Cleanup)
 		settingsMessage, replyMarkup := buildSettings(user)
-		return c.Edit(settingsMessage, replyMarkup, telebot.ModeMarkdown)
+		return c.Edit(settingsMessage, replyMarkup, telebot.ModeHTML)
+	})
+
+	bot.Handle(&telebot.InlineButton{Unique: "toggle_quiet_silent"}, func(c telebot.Context) error {
+		user := getUserPreferences(getUserID(c))
+		user.QuietSilent = !user.QuietSilent
+		updateUserPreference(user.ID, "QuietSilent", user.QuietSilent)
+		settingsMessage, replyMarkup := buildSettings(user)
+		return c.Edit(settingsMessage, replyMarkup, telebot.ModeHTML)
+	})
+
+	bot.Handle(&telebot.InlineButton{Unique: "toggle_quiet_hundo"}, func(c telebot.Context) error {
+		user := getUserPreferences(getUserID(c))
+		user.QuietHundo = !user.QuietHundo
+		updateUserPreference(user.ID, "QuietHundo", user.QuietHundo)
+		settingsMessage, replyMarkup := buildSettings(user)
+		return c.Edit(settingsMessage, replyMarkup, telebot.ModeHTML)
+	})
+
+	bot.Handle(&telebot.InlineButton{Unique: "list_schedules"}, func(c telebot.Context) error {
+		userID := getUserID(c)
+		c.Delete()
+		return c.Send(buildScheduleList(userID, users.All[userID].Language), telebot.ModeHTML)
 	})
 
 	bot.Handle(&telebot.InlineButton{Unique: "change_lang"}, func(c telebot.Context) error {
@@ -1136,19 +1601,19 @@ func setupBotHandlers() {
 		language := users.All[userID].Language
 		btnEn := telebot.InlineButton{Text: "🇬🇧 English", Unique: "set_lang_en"}
 		btnDe := telebot.InlineButton{Text: "🇩🇪 Deutsch", Unique: "set_lang_de"}
-		return c.Edit(getTranslation("🌍 *Select a language:*", language), &telebot.ReplyMarkup{
+		return c.Edit(markupHTML(getTranslation("🌍 *Select a language:*", language)), &telebot.ReplyMarkup{
 			InlineKeyboard: [][]telebot.InlineButton{{btnEn, btnDe}},
-		}, telebot.ModeMarkdown)
+		}, telebot.ModeHTML)
 	})
 
 	bot.Handle(&telebot.InlineButton{Unique: "set_lang_en"}, func(c telebot.Context) error {
 		updateUserPreference(getUserID(c), "Language", "en")
-		return c.Edit("✅ Language set to *English*", telebot.ModeMarkdown)
+		return c.Edit(markupHTML("✅ Language set to *English*"), telebot.ModeHTML)
 	})
 
 	bot.Handle(&telebot.InlineButton{Unique: "set_lang_de"}, func(c telebot.Context) error {
 		updateUserPreference(getUserID(c), "Language", "de")
-		return c.Edit("✅ Sprache auf *Deutsch* gestellt", telebot.ModeMarkdown)
+		return c.Edit(markupHTML("✅ Sprache auf *Deutsch* gestellt"), telebot.ModeHTML)
 	})
 
 	bot.Handle(&telebot.InlineButton{Unique: "update_location"}, func(c telebot.Context) error {
@@ -1180,6 +1645,21 @@ func setupBotHandlers() {
 		return c.Edit(getTranslation("✨ Enter the minimal IV percentage (0-100):", language))
 	})
 
+	bot.Handle(&telebot.InlineButton{Unique: "link_ntfy"}, func(c telebot.Context) error {
+		userID := c.Sender().ID
+		language := users.All[userID].Language
+		userStates[userID] = "link_ntfy"
+		return c.Edit(getTranslation("📲 Enter the ntfy topic to receive your notifications on. Anyone who knows the topic can read them, so choose one that is hard to guess:", language))
+	})
+
+	bot.Handle(&telebot.InlineButton{Unique: "unlink_ntfy"}, func(c telebot.Context) error {
+		userID := getUserID(c)
+		language := users.All[userID].Language
+		dbConfig.Model(&User{}).Where("id = ?", userID).Updates(map[string]interface{}{"Notifier": "", "Target": ""})
+		getUsersByFilters()
+		return c.Edit(getTranslation("✅ ntfy topic unlinked, notifications are sent here again", language))
+	})
+
 	bot.Handle(&telebot.InlineButton{Unique: "set_min_level"}, func(c telebot.Context) error {
 		userID := c.Sender().ID
 		language := users.All[userID].Language
@@ -1187,6 +1667,43 @@ func setupBotHandlers() {
 		return c.Edit(getTranslation("🔢 Enter the minimal Pokémon level (1-40):", language))
 	})
 
+	bot.Handle(&telebot.InlineButton{Unique: "set_travel_mode"}, func(c telebot.Context) error {
+		userID := c.Sender().ID
+		language := users.All[userID].Language
+		btnOff := telebot.InlineButton{Text: getTravelModeName("", language), Unique: "set_travel_mode_off"}
+		btnWalk := telebot.InlineButton{Text: getTravelModeName("walk", language), Unique: "set_travel_mode_walk"}
+		btnBike := telebot.InlineButton{Text: getTravelModeName("bike", language), Unique: "set_travel_mode_bike"}
+		return c.Edit(markupHTML(getTranslation("🚶 *Select a travel mode:*", language)), &telebot.ReplyMarkup{
+			InlineKeyboard: [][]telebot.InlineButton{{btnOff, btnWalk, btnBike}},
+		}, telebot.ModeHTML)
+	})
+
+	bot.Handle(&telebot.InlineButton{Unique: "set_travel_mode_off"}, func(c telebot.Context) error {
+		return setTravelMode(c, "")
+	})
+
+	bot.Handle(&telebot.InlineButton{Unique: "set_travel_mode_walk"}, func(c telebot.Context) error {
+		return setTravelMode(c, "walk")
+	})
+
+	bot.Handle(&telebot.InlineButton{Unique: "set_travel_mode_bike"}, func(c telebot.Context) error {
+		return setTravelMode(c, "bike")
+	})
+
+	bot.Handle(&telebot.InlineButton{Unique: "set_travel_speed"}, func(c telebot.Context) error {
+		userID := c.Sender().ID
+		language := users.All[userID].Language
+		userStates[userID] = "set_travel_speed"
+		return c.Edit(getTranslation("⏱️ Enter your travel speed (in km/h, 0 for the default of the travel mode):", language))
+	})
+
+	bot.Handle(&telebot.InlineButton{Unique: "set_timezone"}, func(c telebot.Context) error {
+		userID := c.Sender().ID
+		language := users.All[userID].Language
+		userStates[userID] = "set_timezone"
+		return c.Edit(getTranslation("🕐 Enter your timezone (e.g. Europe/Berlin) or 'auto' to detect it from your location:", language))
+	})
+
 	bot.Handle(&telebot.InlineButton{Unique: "broadcast"}, func(c telebot.Context) error {
 		userID := c.Sender().ID
 		language := users.All[userID].Language
@@ -1206,10 +1723,10 @@ func setupBotHandlers() {
 		}
 
 		var text strings.Builder
-		c.Send(fmt.Sprintf(getTranslation("📋 *All Users:* %d", language)+"\n\n", len(users.All)), telebot.ModeMarkdown)
+		c.Send(formatHTML(getTranslation("📋 *All Users:* %d", language)+"\n\n", len(users.All)), telebot.ModeHTML)
 
 		for _, user := range users.All {
-			if strings.HasPrefix(strconv.FormatInt(user.ID, 10), "-100") {
+			if isChannel(user) {
 				continue
 			}
 			chat, _ := bot.ChatByID(user.ID)
@@ -1232,14 +1749,19 @@ func setupBotHandlers() {
 		}
 
 		var text strings.Builder
-		text.WriteString(fmt.Sprintf(getTranslation("📋 *All Channels:* %d", language)+"\n\n", len(users.Channels)))
+		text.WriteString(formatHTML(getTranslation("📋 *All Channels:* %d", language)+"\n\n", len(users.Channels)))
 
 		inlineKeyboard := [][]telebot.InlineButton{}
 		for _, channel := range users.Channels {
-			chat, _ := bot.ChatByID(channel.ID)
-			text.WriteString(fmt.Sprintf("🔹 %s @%s (%d) - Notify: %s\n", chat.Title, chat.Username, channel.ID, boolToEmoji(channel.Notify)))
+			title := getChatTitle(channel)
+			if !hasTelegramChat(channel) {
+				text.WriteString(formatHTML("🔹 %s (%d) - Notify: %s\n", title, channel.ID, boolToEmoji(channel.Notify)))
+			} else {
+				chat, _ := bot.ChatByID(channel.ID)
+				text.WriteString(formatHTML("🔹 %s @%s (%d) - Notify: %s\n", title, chat.Username, channel.ID, boolToEmoji(channel.Notify)))
+			}
 			btnEditChannel := telebot.InlineButton{
-				Text:   fmt.Sprintf(getTranslation("✏️ Edit %s", language), chat.Title),
+				Text:   fmt.Sprintf(getTranslation("✏️ Edit %s", language), title),
 				Unique: "edit_channel",
 				Data:   strconv.FormatInt(channel.ID, 10),
 			}
@@ -1248,7 +1770,7 @@ func setupBotHandlers() {
 		btnClose := telebot.InlineButton{Text: getTranslation("Close", language), Unique: "close"}
 		inlineKeyboard = append(inlineKeyboard, []telebot.InlineButton{btnClose})
 
-		return c.Edit(text.String(), &telebot.ReplyMarkup{InlineKeyboard: inlineKeyboard}, telebot.ModeMarkdown)
+		return c.Edit(text.String(), &telebot.ReplyMarkup{InlineKeyboard: inlineKeyboard}, telebot.ModeHTML)
 	})
 
 	bot.Handle(&telebot.InlineButton{Unique: "edit_channel"}, func(c telebot.Context) error {
@@ -1276,15 +1798,45 @@ func setupBotHandlers() {
 
 	// Handle location input
 	bot.Handle(telebot.OnLocation, func(c telebot.Context) error {
+		location := c.Message().Location
+		// Live locations are only followed in memory, the saved location stays untouched.
+		// They belong to the sender, also while an admin impersonates another user.
+		if location.LivePeriod > 0 {
+			senderID := c.Sender().ID
+			setLiveLocation(senderID, c.Message())
+			return c.Send(getTranslation("📍 Live location received! Your notifications will follow you while you share it", users.All[senderID].Language))
+		}
 		userID := getUserID(c)
 		language := users.All[userID].Language
-		location := c.Message().Location
 		// Update user location in the database
 		updateUserPreference(userID, "Latitude", location.Lat)
 		updateUserPreference(userID, "Longitude", location.Lng)
+		if timezoneName := getTimezoneByLocation(location.Lat, location.Lng); timezoneName != "" {
+			updateUserPreference(userID, "Timezone", timezoneName)
+		}
 		return c.Send(getTranslation("✅ Location updated", language))
 	})
 
+	// Handle live location updates
+	bot.Handle(telebot.OnEdited, func(c telebot.Context) error {
+		location := c.Message().Location
+		if location == nil {
+			return nil
+		}
+		// Live location updates arrive every few seconds, impersonation notices are not sent for them
+		userID := c.Sender().ID
+		language := users.All[userID].Language
+		// Telegram sends a final update without live period once sharing has been stopped
+		if location.LivePeriod == 0 {
+			if clearLiveLocation(userID) {
+				return c.Send(getTranslation("📍 Live location ended, using your saved location again", language))
+			}
+			return nil
+		}
+		setLiveLocation(userID, c.Message())
+		return nil
+	})
+
 	// Handle text input
 	bot.Handle(telebot.OnText, func(c telebot.Context) error {
 		userID := c.Sender().ID
@@ -1396,6 +1948,45 @@ func setupBotHandlers() {
 			return c.Send(fmt.Sprintf(getTranslation("✅ Minimal IV updated to %d%%", language), minIV))
 		}
 
+		if userStates[userID] == "set_email" {
+			address, err := startEmailVerification(getUserPreferences(getUserID(c)), c.Text())
+			if err != nil {
+				log.Printf("❌ Failed to send email confirmation code: %v", err)
+				return c.Send(getTranslation("❌ Failed to send a confirmation code, please check the email address and enter it again", language))
+			}
+
+			userStates[userID] = "confirm_email"
+
+			return c.Send(fmt.Sprintf(getTranslation("📧 A confirmation code has been sent to %s, please enter it:", language), address))
+		}
+
+		if userStates[userID] == "confirm_email" {
+			address, retry := confirmEmail(getUserID(c), c.Text())
+			if retry {
+				return c.Send(getTranslation("❌ Wrong code, please try again", language))
+			}
+
+			userStates[userID] = ""
+
+			if address == "" {
+				return c.Send(getTranslation("❌ The confirmation code has expired, please set your email address again", language))
+			}
+			return c.Send(fmt.Sprintf(getTranslation("✅ Email address %s confirmed, choose the email digest schedule in the settings", language), address))
+		}
+
+		if userStates[userID] == "link_ntfy" {
+			topic := strings.TrimSpace(c.Text())
+			if !isValidNtfyTopic(topic) {
+				return c.Send(getTranslation("❌ Invalid topic! Use up to 64 letters, digits, - and _", language))
+			}
+			dbConfig.Model(&User{}).Where("id = ?", getUserID(c)).Updates(map[string]interface{}{"Notifier": "ntfy", "Target": topic})
+			getUsersByFilters()
+
+			userStates[userID] = ""
+
+			return c.Send(fmt.Sprintf(getTranslation("✅ Notifications are now sent to the ntfy topic %s", language), topic))
+		}
+
 		if userStates[userID] == "set_min_level" {
 			// Parse user input
 			var minLevel int
@@ -1412,6 +2003,42 @@ func setupBotHandlers() {
 			return c.Send(fmt.Sprintf(getTranslation("✅ Minimal Level updated to %d", language), minLevel))
 		}
 
+		if userStates[userID] == "set_travel_speed" {
+			// Parse user input
+			var travelSpeed int
+			_, err := fmt.Sscanf(c.Text(), "%d", &travelSpeed)
+			if err != nil || travelSpeed < 0 || travelSpeed > 100 {
+				return c.Send(getTranslation("❌ Invalid input! Please enter a valid speed (0-100 km/h)", language))
+			}
+
+			// Update travel speed in the database
+			updateUserPreference(getUserID(c), "TravelSpeed", travelSpeed)
+
+			userStates[userID] = ""
+
+			return c.Send(fmt.Sprintf(getTranslation("✅ Travel speed updated to %d km/h", language), travelSpeed))
+		}
+
+		if userStates[userID] == "set_timezone" {
+			timezoneName := strings.TrimSpace(c.Text())
+			if strings.ToLower(timezoneName) == "auto" {
+				user := getUserPreferences(getUserID(c))
+				timezoneName = getTimezoneByLocation(user.Latitude, user.Longitude)
+				if timezoneName == "" {
+					return c.Send(getTranslation("❌ Can't detect a timezone, please send your location first", language))
+				}
+			} else if _, err := time.LoadLocation(timezoneName); err != nil || timezoneName == "" || timezoneName == "Local" {
+				return c.Send(getTranslation("❌ Invalid input! Please enter a valid timezone (e.g. Europe/Berlin)", language))
+			}
+
+			// Update timezone in the database
+			updateUserPreference(getUserID(c), "Timezone", timezoneName)
+
+			userStates[userID] = ""
+
+			return c.Send(fmt.Sprintf(getTranslation("✅ Timezone updated to %s", language), timezoneName))
+		}
+
 		if userStates[userID] == "broadcast" {
 			if _, ok := botAdmins[userID]; !ok {
 				return c.Send(getTranslation("❌ You are not authorized to use this command", language))
@@ -1419,8 +2046,8 @@ func setupBotHandlers() {
 
 			message := c.Text()
 			for _, user := range users.All {
-				if user.Notify {
-					bot.Send(&telebot.User{ID: user.ID}, message, telebot.ModeMarkdown)
+				if user.Notify && hasTelegramChat(user) {
+					bot.Send(&telebot.User{ID: user.ID}, markupHTML(message), telebot.ModeHTML)
 				}
 			}
 
@@ -1445,37 +2072,102 @@ func setupBotHandlers() {
 			user := getUserPreferences(int64(impersonatedUserID))
 			settingsMessage, replyMarkup := buildSettings(user)
 
-			return c.Send(settingsMessage, replyMarkup, telebot.ModeMarkdown)
+			return c.Send(settingsMessage, replyMarkup, telebot.ModeHTML)
 		}
 
 		return nil
 	})
 }
 
-func processEncounters() {
-	var lastCheck = time.Now().Unix() - 30
-	// Fetch current Pokémon encounters
-	var encounters []EncounterData
-	if err := dbScanner.Where("iv IS NOT NULL AND updated > ? AND expire_timestamp > ?", lastCheck, lastCheck).Find(&encounters).Error; err != nil {
-		log.Printf("❌ Failed to fetch Pokémon encounters: %v", err)
-	} else {
-		encounterGauge.Set(float64(len(encounters)))
-		log.Printf("✅ Found %d Pokémon", len(encounters))
-		filterAndSendEncounters(users, encounters)
+// Fetch the Pokémon encounters of a database source updated since the last poll, in pages ordered by (updated, id)
+func processEncounters(source *ScannerSource) {
+	cursor := getScannerCursor(source.cursor)
+	now := time.Now().Unix()
+	total := 0
+	lastUpdated, lastID := cursor-pollOverlap, ""
+	for {
+		encounters, err := source.adapter.Encounters(source.db, lastUpdated, lastID, now, pollPageSize)
+		if err != nil {
+			// The cursor is kept, so the remaining encounters are fetched with the next poll
+			log.Printf("❌ Failed to fetch Pokémon encounters of %s: %v", source.Name, err)
+			break
+		}
+		if len(encounters) == 0 {
+			break
+		}
+		total += len(encounters)
+		receiveEncounters(source, encounters)
+
+		last := encounters[len(encounters)-1]
+		lastUpdated, lastID = *last.Updated, last.ID
+		if lastUpdated > cursor {
+			cursor = lastUpdated
+			setScannerCursor(source.cursor, cursor)
+		}
+		if len(encounters) < pollPageSize {
+			break
+		}
 	}
+	encounterGauge.WithLabelValues(source.Name).Set(float64(total))
+	log.Printf("✅ Found %d Pokémon in %s", total, source.Name)
 }
 
 // Helper function to check if the encounter is within the user's allowed distance.
 // Returns true if the check passes or if no distance filtering is set.
 func withinDistance(user User, encounter EncounterData, maxDistance int) bool {
-	if user.Latitude == 0 || user.Longitude == 0 || maxDistance == 0 {
+	if maxDistance == 0 {
+		return true
+	}
+	distance, ok := getUserDistance(user, encounter)
+	if !ok {
 		return true
 	}
-	distance := haversine(float64(user.Latitude), float64(user.Longitude), float64(encounter.Lat), float64(encounter.Lon))
 	return distance <= float64(maxDistance)
 }
 
 func filterAndSendEncounters(users FilteredUsers, encounters []EncounterData) {
+	var notifications []PendingNotification
+	matched := make(map[string]map[int64]struct{})
+	queueNotification := func(user User, encounter EncounterData, rule MatchRule) {
+		if matched[encounter.ID] == nil {
+			matched[encounter.ID] = make(map[int64]struct{})
+		}
+		matched[encounter.ID][user.ID] = struct{}{}
+		// Webhook subscribers receive every match, even if the user is not notified
+		publishMatch(user, encounter, rule)
+		if isSnoozed(user) {
+			return
+		}
+		if user.EmailDigest != "" && user.Email != "" {
+			addToEmailDigest(user, encounter)
+		}
+		if !reachableInTime(user, encounter) {
+			log.Printf("🐌 Skipping notification for Pokémon #%d to %d (not reachable in time)", encounter.PokemonID, user.ID)
+			return
+		}
+		silent := false
+		if isQuietTime(user, time.Now()) && !(user.QuietHundo && encounter.IV != nil && *encounter.IV == 100) {
+			if !user.QuietSilent {
+				log.Printf("🌙 Skipping notification for Pokémon #%d to %d (quiet hours)", encounter.PokemonID, user.ID)
+				return
+			}
+			silent = true
+		}
+		// Digests are sent to the Telegram chat, also of users who linked another notifier
+		if user.DigestInterval > 0 && hasTelegramChat(user) {
+			addToDigest(user, encounter)
+			return
+		}
+		notifications = append(notifications, PendingNotification{User: user, Encounter: encounter, Silent: silent})
+	}
+
+	// Load the sent notifications of all encounters at once
+	encounterIDs := make([]string, len(encounters))
+	for i, encounter := range encounters {
+		encounterIDs[i] = encounter.ID
+	}
+	sentNotifications.Preload(encounterIDs)
+
 	// Match encounters with subscriptions
 	for _, encounter := range encounters {
 
@@ -1496,7 +2188,7 @@ func filterAndSendEncounters(users FilteredUsers, encounters []EncounterData) {
 							league, getPokemonName(entry.Pokemon, "en"), entry.CP, entry.Rank, entry.Percentage, entry.Level)
 						for _, user := range users.TopPVP {
 							if withinDistance(user, encounter, user.MaxDistance) {
-								sendEncounterNotification(user, encounter)
+								queueNotification(user, encounter, MatchRule{Type: "pvp", League: league, MaxDistance: user.MaxDistance})
 							}
 						}
 					}
@@ -1508,7 +2200,7 @@ func filterAndSendEncounters(users FilteredUsers, encounters []EncounterData) {
 		if encounter.IV != nil && *encounter.IV == 100 {
 			for _, user := range users.HundoIV {
 				if withinDistance(user, encounter, user.MaxDistance) {
-					sendEncounterNotification(user, encounter)
+					queueNotification(user, encounter, MatchRule{Type: "hundo", MaxDistance: user.MaxDistance})
 				}
 			}
 		}
@@ -1517,7 +2209,7 @@ func filterAndSendEncounters(users FilteredUsers, encounters []EncounterData) {
 		if encounter.IV != nil && *encounter.IV == 0 {
 			for _, user := range users.ZeroIV {
 				if withinDistance(user, encounter, user.MaxDistance) {
-					sendEncounterNotification(user, encounter)
+					queueNotification(user, encounter, MatchRule{Type: "zero", MaxDistance: user.MaxDistance})
 				}
 			}
 		}
@@ -1532,7 +2224,7 @@ func filterAndSendEncounters(users FilteredUsers, encounters []EncounterData) {
 				(user.MinIV == 0 && *encounter.Level >= user.MinLevel) ||
 				(*encounter.IV >= float32(user.MinIV) && *encounter.Level >= user.MinLevel)
 			if ivOk {
-				sendEncounterNotification(user, encounter)
+				queueNotification(user, encounter, MatchRule{Type: "channel", MinIV: user.MinIV, MinLevel: user.MinLevel})
 			}
 		}
 
@@ -1565,7 +2257,28 @@ func filterAndSendEncounters(users FilteredUsers, encounters []EncounterData) {
 				if !withinDistance(user, encounter, effectiveMaxDistance) {
 					continue
 				}
-				sendEncounterNotification(user, encounter)
+				queueNotification(user, encounter, MatchRule{
+					Type:        "subscription",
+					PokemonID:   sub.PokemonID,
+					MinIV:       effectiveMinIV,
+					MinLevel:    effectiveMinLevel,
+					MaxDistance: effectiveMaxDistance,
+				})
+			}
+		}
+	}
+
+	// Send notifications, nearest first for users sharing a live location
+	for _, notification := range sortByLiveDistance(notifications) {
+		sendEncounterNotification(notification.User, notification.Encounter, notification.Silent)
+	}
+
+	// Retract notifications of changed encounters that do not match anymore
+	for _, encounter := range encounters {
+		fingerprint := getEncounterFingerprint(encounter)
+		for userID, sentFingerprint := range sentNotifications.Chats(encounter.ID) {
+			if _, exists := matched[encounter.ID][userID]; !exists && sentFingerprint != fingerprint {
+				retractEncounterNotification(userID, encounter)
 			}
 		}
 	}
@@ -1592,20 +2305,41 @@ func cleanupMessages() {
 			}
 			dbConfig.Delete(&message)
 		}
+		deletedMessagesCount += deleteExternalMessages(0, encounter.ID, true)
 		dbConfig.Delete(&encounter)
-		sentNotifications[encounter.ID] = nil
+		sentNotifications.Forget(encounter.ID)
 	}
+	dbConfig.Where("expiration < ?", time.Now().Unix()).Delete(&SentNotification{})
 
 	cleanupCounter.Add(float64(deletedMessagesCount))
 }
 
 func startBackgroundProcessing() {
-	// Background process to match encounters with subscriptions
+	// Background process to match encounters with subscriptions.
+	// Encounters received from Golbat are processed in between, so the state is only used by this goroutine.
 	go func() {
+		ticker := time.NewTicker(30 * time.Second)
 		for {
-			time.Sleep(30 * time.Second)
+			select {
+			case received := <-scannerEncounters:
+				encounterGauge.WithLabelValues(received.Source.Name).Set(float64(len(received.Encounters)))
+				receiveEncounters(received.Source, received.Encounters)
+				continue
+			case <-ticker.C:
+			}
+			checkSnoozeExpiry()
+			pruneReceivedEncounters()
+			flushDigests()
+			prunePublishedMatches()
+			flushEmailDigests()
+			if mapRenderer != nil {
+				mapRenderer.Cleanup()
+			}
+			// Make sure all sent messages are known before cleaning up
+			flushBookkeeping()
 			cleanupMessages()
-			processEncounters()
+			// Scanner databases are the fallback if Golbat does not send webhooks
+			pollScannerSources()
 		}
 	}()
 }
@@ -1618,6 +2352,11 @@ func init() {
 	customRegistry.MustRegister(usersGauge)
 	customRegistry.MustRegister(subscriptionGauge)
 	customRegistry.MustRegister(activeSubscriptionGauge)
+	customRegistry.MustRegister(queueLengthGauge)
+	customRegistry.MustRegister(queueDroppedCounter)
+	customRegistry.MustRegister(queueRetriesCounter)
+	customRegistry.MustRegister(webhookRequestsCounter)
+	customRegistry.MustRegister(golbatWebhooksCounter)
 }
 
 func main() {
@@ -1629,8 +2368,15 @@ func main() {
 	}
 	// Check required environment variables.
 	requiredVars := []string{
-		"BOT_TOKEN", "BOT_ADMINS", "BOT_DB_USER", "BOT_DB_PASS", "BOT_DB_NAME", "BOT_DB_HOST",
-		"SCANNER_DB_USER", "SCANNER_DB_PASS", "SCANNER_DB_NAME", "SCANNER_DB_HOST",
+		"BOT_TOKEN", "BOT_ADMINS",
+	}
+	// A SQLite bot database needs no server
+	if os.Getenv("BOT_DB_DRIVER") != "sqlite" {
+		requiredVars = append(requiredVars, "BOT_DB_USER", "BOT_DB_PASS", "BOT_DB_NAME", "BOT_DB_HOST")
+	}
+	// Without configured scanner sources, the scanner database is set by environment variables
+	if os.Getenv("SCANNER_SOURCES") == "" {
+		requiredVars = append(requiredVars, "SCANNER_DB_USER", "SCANNER_DB_PASS", "SCANNER_DB_NAME", "SCANNER_DB_HOST")
 	}
 	checkEnvVars(requiredVars)
 
@@ -1646,7 +2392,7 @@ func main() {
 
 	// Initialize state maps.
 	userStates = make(map[int64]string)
-	sentNotifications = make(map[string]map[int64]struct{})
+	sentNotifications = NewSentNotificationCache(int(getEnvFloat("BOT_DEDUPE_CACHE", 20000)))
 
 	// Load static files.
 	if err := loadMasterFile("masterfile.json"); err != nil {
@@ -1656,11 +2402,24 @@ func main() {
 		log.Fatalf("❌ Unable to load translations: %v", err)
 	}
 	loadPokemonNameMappings()
+	loadTimezoneFinder()
+	loadDefaultTemplates()
+	loadUIconsRepositories()
+	loadMapRenderer()
+	loadMatrixNotifier()
+	loadNtfyNotifier()
+	loadMailer()
+	if err := loadScannerSources(); err != nil {
+		log.Fatalf("❌ Unable to load scanner sources: %v", err)
+	}
 
 	// Initialize databases.
 	initDB()
 	getUsersByFilters()
 	getActiveSubscriptions()
+	getSchedules()
+	getTemplates()
+	getWebhookSubscribers()
 
 	// Set timezone.
 	var err error
@@ -1680,9 +2439,22 @@ func main() {
 		log.Fatalf("❌ Failed to initialize bot: %v", err)
 	}
 
+	// Setup delivery queue, Telegram allows about 30 messages per second overall and 1 per second per chat.
+	deliveryQueue = NewDeliveryQueue(
+		int(getEnvFloat("BOT_QUEUE_SIZE", 1000)),
+		int(getEnvFloat("BOT_WORKERS", 4)),
+		getEnvFloat("BOT_RATE_GLOBAL", 25),
+		getEnvFloat("BOT_RATE_CHAT", 1),
+		getEnvFloat("BOT_RATE_CHAT_BURST", 3),
+	)
+	deliveryQueue.Start()
+	startBookkeeping()
+	startWebhookWorkers()
+
 	// Setup bot handlers and background processes.
 	setupBotHandlers()
 	startBackgroundProcessing()
+	startWebhookSources()
 
 	// Start Prometheus metrics server in a new goroutine.
 	server := &http.Server{Addr: ":9001"}
@@ -1705,6 +2477,7 @@ func main() {
 		sig := <-sigChan
 		log.Printf("🛑 Caught signal %v: shutting down", sig)
 		bot.Stop()
+		flushBookkeeping()
 		// Shutdown the metrics server gracefully.
 		ctx, cancel := context.WithTimeout(shutdownCtx, 5*time.Second)
 		defer cancel()
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/telebot.v3"
+)
+
+const (
+	tileSize        = 256
+	mapWidth        = 600
+	mapHeight       = 400
+	mapPadding      = 48
+	mapMinZoom      = 10
+	mapMaxZoom      = 17
+	mapIconSize     = 64
+	mapCacheMaxAge  = 2 * time.Hour
+	tileUserAgent   = "PoGoBot (+https://github.com/michikrug/PoGoBot)"
+	tileHTTPTimeout = 10 * time.Second
+
+	defaultMapLinkURL = "https://www.google.com/maps/search/?api=1&query={lat},{lon}"
+)
+
+var (
+	mapRenderer *MapRenderer
+
+	mapSpawnColor = color.RGBA{R: 220, G: 40, B: 40, A: 255}
+	mapUserColor  = color.RGBA{R: 30, G: 110, B: 230, A: 255}
+	mapRouteColor = color.RGBA{R: 30, G: 110, B: 230, A: 160}
+	mapEmptyColor = color.RGBA{R: 230, G: 230, B: 225, A: 255}
+
+	errNoTiles = errors.New("no map tiles available")
+)
+
+// TileSource provides OSM raster tiles in the usual z/x/y scheme
+type TileSource interface {
+	Tile(z int, x int, y int) (image.Image, error)
+}
+
+// TileDirectory reads tiles from a local directory laid out as {z}/{x}/{y}.png
+type TileDirectory struct {
+	Path string
+}
+
+func (d TileDirectory) Tile(z int, x int, y int) (image.Image, error) {
+	return decodeImageFile(filepath.Join(d.Path, strconv.Itoa(z), strconv.Itoa(x), strconv.Itoa(y)+".png"))
+}
+
+// TileServer downloads tiles from a tile server URL with {z}, {x} and {y} placeholders
+// and keeps them in a local cache directory
+type TileServer struct {
+	URL      string
+	CacheDir string
+	client   *http.Client
+}
+
+func NewTileServer(url string, cacheDir string) *TileServer {
+	return &TileServer{URL: url, CacheDir: cacheDir, client: &http.Client{Timeout: tileHTTPTimeout}}
+}
+
+func (s *TileServer) Tile(z int, x int, y int) (image.Image, error) {
+	path := filepath.Join(s.CacheDir, strconv.Itoa(z), strconv.Itoa(x), strconv.Itoa(y)+".png")
+	if tile, err := decodeImageFile(path); err == nil {
+		return tile, nil
+	}
+
+	url := strings.NewReplacer("{z}", strconv.Itoa(z), "{x}", strconv.Itoa(x), "{y}", strconv.Itoa(y)).Replace(s.URL)
+	data, err := downloadFile(s.client, url)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return nil, err
+	}
+	return decodeImageFile(path)
+}
+
+// MapRenderer draws small maps of a spawn and the user location from raster tiles.
+// Rendered maps are cached as PNG files in the cache directory.
+type MapRenderer struct {
+	Tiles    TileSource
+	CacheDir string
+	Width    int
+	Height   int
+}
+
+// MapPoint is a geographic position on a map
+type MapPoint struct {
+	Lat float64
+	Lon float64
+}
+
+func loadMapRenderer() {
+	cacheDir := os.Getenv("MAP_CACHE_DIR")
+	if cacheDir == "" {
+		cacheDir = filepath.Join(os.TempDir(), "pogobot-maps")
+	}
+
+	var tiles TileSource
+	if path := os.Getenv("MAP_TILE_DIR"); path != "" {
+		tiles = TileDirectory{Path: path}
+	} else if url := os.Getenv("MAP_TILE_URL"); url != "" {
+		tiles = NewTileServer(url, filepath.Join(cacheDir, "tiles"))
+	} else {
+		log.Println("⚠️ No map tiles configured, notifications are sent without maps")
+		return
+	}
+
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		log.Printf("❌ Failed to create map cache directory: %v", err)
+		return
+	}
+	mapRenderer = &MapRenderer{Tiles: tiles, CacheDir: cacheDir, Width: mapWidth, Height: mapHeight}
+	log.Printf("✅ Rendering notification maps to %s", cacheDir)
+}
+
+// Convert a position to global pixel coordinates at a zoom level (Web Mercator)
+func projectMapPoint(point MapPoint, zoom int) (float64, float64) {
+	scale := float64(tileSize) * math.Exp2(float64(zoom))
+	lat := point.Lat * math.Pi / 180
+	x := (point.Lon + 180) / 360 * scale
+	y := (1 - math.Log(math.Tan(lat)+1/math.Cos(lat))/math.Pi) / 2 * scale
+	return x, y
+}
+
+// Get the highest zoom level showing all points
+func (r *MapRenderer) fitZoom(points []MapPoint) int {
+	for zoom := mapMaxZoom; zoom > mapMinZoom; zoom-- {
+		minX, minY := math.Inf(1), math.Inf(1)
+		maxX, maxY := math.Inf(-1), math.Inf(-1)
+		for _, point := range points {
+			x, y := projectMapPoint(point, zoom)
+			minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+			minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+		}
+		if maxX-minX <= float64(r.Width-2*mapPadding) && maxY-minY <= float64(r.Height-2*mapPadding) {
+			return zoom
+		}
+	}
+	return mapMinZoom
+}
+
+// Render a map of a spawn with an optional user location and Pokémon icon.
+// Returns the path of the cached PNG file, maps are only rendered once per key.
+func (r *MapRenderer) Render(key string, spawn MapPoint, user *MapPoint, icon image.Image) (string, error) {
+	path := filepath.Join(r.CacheDir, key+".png")
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	points := []MapPoint{spawn}
+	if user != nil {
+		points = append(points, *user)
+	}
+	zoom := r.fitZoom(points)
+
+	// Center the map between all points
+	var centerX, centerY float64
+	for _, point := range points {
+		x, y := projectMapPoint(point, zoom)
+		centerX += x / float64(len(points))
+		centerY += y / float64(len(points))
+	}
+	left := int(math.Round(centerX)) - r.Width/2
+	top := int(math.Round(centerY)) - r.Height/2
+
+	canvas := image.NewRGBA(image.Rect(0, 0, r.Width, r.Height))
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(mapEmptyColor), image.Point{}, draw.Src)
+
+	tileCount := 1 << zoom
+	loaded := 0
+	for tileY := floorDiv(top, tileSize); tileY <= floorDiv(top+r.Height-1, tileSize); tileY++ {
+		if tileY < 0 || tileY >= tileCount {
+			continue
+		}
+		for tileX := floorDiv(left, tileSize); tileX <= floorDiv(left+r.Width-1, tileSize); tileX++ {
+			tile, err := r.Tiles.Tile(zoom, (tileX%tileCount+tileCount)%tileCount, tileY)
+			if err != nil {
+				log.Printf("❌ Failed to load map tile %d/%d/%d: %v", zoom, tileX, tileY, err)
+				continue
+			}
+			loaded++
+			offset := image.Pt(tileX*tileSize-left, tileY*tileSize-top)
+			draw.Draw(canvas, tile.Bounds().Sub(tile.Bounds().Min).Add(offset), tile, tile.Bounds().Min, draw.Src)
+		}
+	}
+	if loaded == 0 {
+		return "", errNoTiles
+	}
+
+	toCanvas := func(point MapPoint) (int, int) {
+		x, y := projectMapPoint(point, zoom)
+		return int(math.Round(x)) - left, int(math.Round(y)) - top
+	}
+	spawnX, spawnY := toCanvas(spawn)
+	if user != nil {
+		userX, userY := toCanvas(*user)
+		drawLine(canvas, userX, userY, spawnX, spawnY, 2, mapRouteColor)
+		drawMarker(canvas, userX, userY, mapUserColor)
+	}
+	drawMarker(canvas, spawnX, spawnY, mapSpawnColor)
+	if icon != nil {
+		iconBounds := image.Rect(spawnX-mapIconSize/2, spawnY-mapIconSize-8, spawnX+mapIconSize/2, spawnY-8)
+		draw.Draw(canvas, iconBounds, scaleImage(icon, mapIconSize, mapIconSize), image.Point{}, draw.Over)
+	}
+
+	file, err := os.CreateTemp(r.CacheDir, key+"-*.png")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+	if err := png.Encode(file, canvas); err != nil {
+		file.Close()
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+	// Rename to make the map visible to concurrent renders only once it is complete
+	return path, os.Rename(file.Name(), path)
+}
+
+// Remove rendered maps of encounters that have despawned
+func (r *MapRenderer) Cleanup() {
+	entries, err := os.ReadDir(r.CacheDir)
+	if err != nil {
+		log.Printf("❌ Failed to read map cache: %v", err)
+		return
+	}
+	removed := 0
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".png" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < mapCacheMaxAge {
+			continue
+		}
+		if err := os.Remove(filepath.Join(r.CacheDir, entry.Name())); err == nil {
+			removed++
+		}
+	}
+	if removed > 0 {
+		log.Printf("🗑️ Removed %d expired maps", removed)
+	}
+}
+
+// Get a link to a location on a map, the map service can be configured with MAP_LINK_URL
+func getMapLink(lat float32, lon float32) string {
+	link := os.Getenv("MAP_LINK_URL")
+	if link == "" {
+		link = defaultMapLinkURL
+	}
+	return strings.NewReplacer("{lat}", fmt.Sprintf("%.6f", lat), "{lon}", fmt.Sprintf("%.6f", lon)).Replace(link)
+}
+
+// Get the photo of a notification: a map of the encounter if maps are configured, the Pokémon icon otherwise
+func getNotificationPhoto(user User, encounter EncounterData) telebot.File {
+	iconLocation := getPokemonIcon(uicons, encounter)
+	if mapRenderer == nil {
+		return getIconFile(iconLocation)
+	}
+
+	icon, err := loadIcon(iconLocation)
+	if err != nil {
+		log.Printf("❌ Failed to load icon %s: %v", iconLocation, err)
+	}
+	key := encounter.ID
+	var userPoint *MapPoint
+	if lat, lon := getUserLocation(user); lat != 0 && lon != 0 {
+		userPoint = &MapPoint{Lat: float64(lat), Lon: float64(lon)}
+		// Rounded to about 10m, so nearby users and small live location updates share a map
+		key = fmt.Sprintf("%s_%.4f_%.4f", encounter.ID, lat, lon)
+	}
+	path, err := mapRenderer.Render(key, MapPoint{Lat: float64(encounter.Lat), Lon: float64(encounter.Lon)}, userPoint, icon)
+	if err != nil {
+		log.Printf("❌ Failed to render map for encounter %s: %v", encounter.ID, err)
+		return getIconFile(iconLocation)
+	}
+	return telebot.FromDisk(path)
+}
+
+var (
+	iconCache      = make(map[string]image.Image)
+	iconCacheMutex sync.Mutex
+	iconClient     = &http.Client{Timeout: tileHTTPTimeout}
+)
+
+// Load an icon image by URL or local path, icons are kept in memory once loaded
+func loadIcon(location string) (image.Image, error) {
+	iconCacheMutex.Lock()
+	icon, exists := iconCache[location]
+	iconCacheMutex.Unlock()
+	if exists {
+		return icon, nil
+	}
+
+	var err error
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		var data []byte
+		if data, err = downloadFile(iconClient, location); err != nil {
+			return nil, err
+		}
+		icon, _, err = image.Decode(bytes.NewReader(data))
+	} else {
+		icon, err = decodeImageFile(location)
+	}
+	if err != nil {
+		return nil, err
+	}
+	iconCacheMutex.Lock()
+	iconCache[location] = icon
+	iconCacheMutex.Unlock()
+	return icon, nil
+}
+
+func downloadFile(client *http.Client, url string) ([]byte, error) {
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("User-Agent", tileUserAgent)
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s for %s", response.Status, url)
+	}
+	return io.ReadAll(response.Body)
+}
+
+func decodeImageFile(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	return img, err
+}
+
+func floorDiv(a int, b int) int {
+	if a < 0 {
+		return -((-a + b - 1) / b)
+	}
+	return a / b
+}
+
+// Draw a filled opaque circle
+func drawDisc(canvas *image.RGBA, cx int, cy int, radius int, c color.Color) {
+	bounds := image.Rect(cx-radius, cy-radius, cx+radius+1, cy+radius+1)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if (x-cx)*(x-cx)+(y-cy)*(y-cy) <= radius*radius {
+				canvas.Set(x, y, c)
+			}
+		}
+	}
+}
+
+// Draw a marker with a white border
+func drawMarker(canvas *image.RGBA, x int, y int, c color.Color) {
+	drawDisc(canvas, x, y, 9, color.White)
+	drawDisc(canvas, x, y, 7, c)
+}
+
+// Draw a line of the given width between two points
+func drawLine(canvas *image.RGBA, x0 int, y0 int, x1 int, y1 int, width int, c color.Color) {
+	steps := max(abs(x1-x0), abs(y1-y0))
+	if steps == 0 {
+		return
+	}
+	// Draw onto a mask first, so overlapping dots do not stack up their transparency
+	mask := image.NewAlpha(canvas.Bounds())
+	for i := 0; i <= steps; i++ {
+		x := x0 + (x1-x0)*i/steps
+		y := y0 + (y1-y0)*i/steps
+		draw.Draw(mask, image.Rect(x-width, y-width, x+width+1, y+width+1), image.Opaque, image.Point{}, draw.Src)
+	}
+	draw.DrawMask(canvas, canvas.Bounds(), image.NewUniform(c), image.Point{}, mask, image.Point{}, draw.Over)
+}
+
+// Scale an image with nearest neighbour sampling
+func scaleImage(src image.Image, width int, height int) image.Image {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	bounds := src.Bounds()
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			dst.Set(x, y, src.At(bounds.Min.X+x*bounds.Dx()/width, bounds.Min.Y+y*bounds.Dy()/height))
+		}
+	}
+	return dst
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
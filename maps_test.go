@@ -0,0 +1,163 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Fixture tiles at zoom 17 around the Brandenburg Gate. Tile x+dx, y+dy of the center tile
+// 70406/42987 is filled with R = 140+40*dx, G = 200, B = 140+40*dy.
+const testTileDir = "testdata/tiles"
+
+var testSpawn = MapPoint{Lat: 52.5163, Lon: 13.3777}
+
+// countingTiles counts the tiles loaded from a tile source
+type countingTiles struct {
+	TileSource
+	loaded int
+}
+
+func (c *countingTiles) Tile(z int, x int, y int) (image.Image, error) {
+	c.loaded++
+	return c.TileSource.Tile(z, x, y)
+}
+
+func newTestMapRenderer(t *testing.T, tileDir string) (*MapRenderer, *countingTiles) {
+	t.Helper()
+	tiles := &countingTiles{TileSource: TileDirectory{Path: tileDir}}
+	return &MapRenderer{Tiles: tiles, CacheDir: t.TempDir(), Width: 256, Height: 256}, tiles
+}
+
+func assertPixel(t *testing.T, img image.Image, x int, y int, expected color.RGBA) {
+	t.Helper()
+	r, g, b, a := img.At(x, y).RGBA()
+	actual := color.RGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: uint8(a >> 8)}
+	if actual != expected {
+		t.Errorf("pixel %d,%d = %v, expected %v", x, y, actual, expected)
+	}
+}
+
+func TestRenderMapFromTileDirectory(t *testing.T) {
+	renderer, tiles := newTestMapRenderer(t, testTileDir)
+
+	path, err := renderer.Render("spawn", testSpawn, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != filepath.Join(renderer.CacheDir, "spawn.png") {
+		t.Errorf("map rendered to %s", path)
+	}
+	rendered, err := decodeImageFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := rendered.Bounds().Size(); size != image.Pt(256, 256) {
+		t.Fatalf("map size %v, expected 256x256", size)
+	}
+	// A single spawn is shown in the center of the map at the highest zoom level
+	assertPixel(t, rendered, 128, 128, mapSpawnColor)
+	// The spawn is close to the bottom right of the center tile, the map covers the tiles next to it as well
+	assertPixel(t, rendered, 0, 0, color.RGBA{R: 140, G: 200, B: 140, A: 255})
+	assertPixel(t, rendered, 255, 255, color.RGBA{R: 180, G: 200, B: 180, A: 255})
+	if tiles.loaded == 0 {
+		t.Error("no tiles loaded")
+	}
+}
+
+func TestRenderMapCacheHit(t *testing.T) {
+	renderer, tiles := newTestMapRenderer(t, testTileDir)
+
+	first, err := renderer.Render("cached", testSpawn, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := tiles.loaded
+	info, err := os.Stat(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := renderer.Render("cached", testSpawn, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second != first {
+		t.Errorf("cached map at %s, expected %s", second, first)
+	}
+	if tiles.loaded != loaded {
+		t.Errorf("%d tiles loaded for a cached map", tiles.loaded-loaded)
+	}
+	if cached, err := os.Stat(second); err != nil || !cached.ModTime().Equal(info.ModTime()) {
+		t.Errorf("cached map has been rendered again: %v", err)
+	}
+}
+
+func TestRenderMapWithUserAndIcon(t *testing.T) {
+	renderer, _ := newTestMapRenderer(t, testTileDir)
+	user := MapPoint{Lat: 52.5161, Lon: 13.3780} // Below the spawn, the icon is drawn above it
+	icon := image.NewUniform(color.RGBA{R: 255, G: 0, B: 255, A: 255})
+
+	if zoom := renderer.fitZoom([]MapPoint{testSpawn, user}); zoom != mapMaxZoom {
+		t.Fatalf("zoom %d, expected %d", zoom, mapMaxZoom)
+	}
+	path, err := renderer.Render("user", testSpawn, &user, icon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rendered, err := decodeImageFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both points are centered on the map
+	spawnX, spawnY := projectMapPoint(testSpawn, mapMaxZoom)
+	userX, userY := projectMapPoint(user, mapMaxZoom)
+	left := int(((spawnX+userX)/2)+0.5) - 128
+	top := int(((spawnY+userY)/2)+0.5) - 128
+	canvasX := func(x float64) int { return int(x+0.5) - left }
+	canvasY := func(y float64) int { return int(y+0.5) - top }
+
+	assertPixel(t, rendered, canvasX(spawnX), canvasY(spawnY), mapSpawnColor)
+	assertPixel(t, rendered, canvasX(userX), canvasY(userY), mapUserColor)
+	// The icon is drawn above the spawn marker
+	assertPixel(t, rendered, canvasX(spawnX), canvasY(spawnY)-8-mapIconSize/2, color.RGBA{R: 255, G: 0, B: 255, A: 255})
+}
+
+func TestRenderMapWithoutTiles(t *testing.T) {
+	renderer, _ := newTestMapRenderer(t, t.TempDir())
+
+	if _, err := renderer.Render("empty", testSpawn, nil, nil); err != errNoTiles {
+		t.Fatalf("error %v, expected %v", err, errNoTiles)
+	}
+	if _, err := os.Stat(filepath.Join(renderer.CacheDir, "empty.png")); !os.IsNotExist(err) {
+		t.Errorf("map without tiles has been cached: %v", err)
+	}
+}
+
+func TestMapCacheCleanup(t *testing.T) {
+	renderer, _ := newTestMapRenderer(t, testTileDir)
+	expired, err := renderer.Render("expired", testSpawn, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := renderer.Render("current", testSpawn, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-mapCacheMaxAge - time.Minute)
+	if err := os.Chtimes(expired, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	renderer.Cleanup()
+	if _, err := os.Stat(expired); !os.IsNotExist(err) {
+		t.Errorf("expired map has not been removed: %v", err)
+	}
+	if _, err := os.Stat(current); err != nil {
+		t.Errorf("current map has been removed: %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// MatrixNotifier sends notifications to Matrix rooms using the client-server API. It is called by
+// the delivery workers, rate limited requests are retried by the delivery queue.
+// The target of a Matrix user is the room ID.
+type MatrixNotifier struct {
+	Homeserver  string
+	AccessToken string
+	client      *http.Client
+	// Transaction IDs of sent events, unique per access token
+	transactionID atomic.Int64
+}
+
+type matrixMessage struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	Format        string `json:"format,omitempty"`
+	FormattedBody string `json:"formatted_body,omitempty"`
+}
+
+type matrixError struct {
+	ErrCode      string `json:"errcode"`
+	Error        string `json:"error"`
+	RetryAfterMs int64  `json:"retry_after_ms"`
+}
+
+func NewMatrixNotifier(homeserver string, accessToken string) *MatrixNotifier {
+	notifier := &MatrixNotifier{
+		Homeserver:  strings.TrimSuffix(homeserver, "/"),
+		AccessToken: accessToken,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+	notifier.transactionID.Store(time.Now().UnixNano())
+	return notifier
+}
+
+// Enable the Matrix notifier if a homeserver is configured
+func loadMatrixNotifier() {
+	homeserver := os.Getenv("MATRIX_HOMESERVER")
+	accessToken := os.Getenv("MATRIX_ACCESS_TOKEN")
+	if homeserver == "" || accessToken == "" {
+		return
+	}
+	notifiers["matrix"] = NewMatrixNotifier(homeserver, accessToken)
+	log.Printf("✅ Matrix notifications enabled on %s", homeserver)
+}
+
+func (m *MatrixNotifier) nextTransactionID() string {
+	return strconv.FormatInt(m.transactionID.Add(1), 10)
+}
+
+// Join a room by ID or alias, returns the room ID
+func (m *MatrixNotifier) Join(room string) (string, error) {
+	response, err := m.request(http.MethodPost, "/join/"+url.PathEscape(room), struct{}{})
+	if err != nil {
+		return "", err
+	}
+	var joined struct {
+		RoomID string `json:"room_id"`
+	}
+	if err := json.Unmarshal(response, &joined); err != nil {
+		return "", err
+	}
+	return joined.RoomID, nil
+}
+
+func (m *MatrixNotifier) Send(user User, notification Notification) error {
+	encounter := notification.Encounter
+	text := notification.Title + "\n" + notification.Text
+	mapLink := getMapLink(encounter.Lat, encounter.Lon)
+	message := matrixMessage{
+		MsgType:       "m.text",
+		Body:          plainText(text) + "\n" + mapLink,
+		Format:        "org.matrix.custom.html",
+		FormattedBody: strings.ReplaceAll(text, "\n", "<br>") + "<br>" + fmt.Sprintf(`<a href="%s">%s</a>`, escapeHTML(mapLink), escapeHTML(getTranslation("📍 Open map", user.Language))),
+	}
+
+	response, err := m.request(http.MethodPut, "/rooms/"+url.PathEscape(user.Target)+"/send/m.room.message/"+m.nextTransactionID(), message)
+	if err != nil {
+		return err
+	}
+	var sent struct {
+		EventID string `json:"event_id"`
+	}
+	if err := json.Unmarshal(response, &sent); err != nil {
+		return err
+	}
+	messagesCounter.Inc()
+	if notification.Delivery.EncounterID != "" && sent.EventID != "" {
+		recordExternalMessage(user.ID, notification.Delivery.EncounterID, sent.EventID)
+	}
+	return nil
+}
+
+// Redact a sent event
+func (m *MatrixNotifier) Delete(user User, reference string) error {
+	_, err := m.request(http.MethodPut, "/rooms/"+url.PathEscape(user.Target)+"/redact/"+url.PathEscape(reference)+"/"+m.nextTransactionID(), struct{}{})
+	return err
+}
+
+// Send a request to the client-server API, fails with a RateLimitError if the bot is rate limited
+func (m *MatrixNotifier) request(method string, path string, body interface{}) ([]byte, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequest(method, m.Homeserver+"/_matrix/client/v3"+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Authorization", "Bearer "+m.AccessToken)
+	request.Header.Set("Content-Type", "application/json")
+	response, err := m.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode < 300 {
+		return data, nil
+	}
+
+	var matrixErr matrixError
+	json.Unmarshal(data, &matrixErr)
+	if response.StatusCode == http.StatusTooManyRequests || matrixErr.ErrCode == "M_LIMIT_EXCEEDED" {
+		retryAfter := time.Duration(matrixErr.RetryAfterMs) * time.Millisecond
+		return nil, &RateLimitError{RetryAfter: max(retryAfter, time.Second), Message: matrixErr.Error}
+	}
+	return nil, fmt.Errorf("matrix homeserver returned %s: %s %s", response.Status, matrixErr.ErrCode, matrixErr.Error)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testMatrixRoom = "!room:example.com"
+
+// Start a test homeserver and a notifier using it
+func newMatrixHomeserver(t *testing.T, responses ...func(w http.ResponseWriter)) (*recordingServer, *MatrixNotifier) {
+	homeserver, server := newRecordingServer(t, responses...)
+	return homeserver, NewMatrixNotifier(server.URL+"/", "syt_token")
+}
+
+func newMatrixUser(cleanup bool) User {
+	return User{ID: testExternalChatID, Notifier: "matrix", Target: testMatrixRoom, Language: "en", Cleanup: cleanup}
+}
+
+// Get the transaction ID at the end of a request path
+func transactionIDOf(path string) string {
+	return path[strings.LastIndex(path, "/")+1:]
+}
+
+func TestMatrixSendRecordsEvent(t *testing.T) {
+	setupTemplateTest(t)
+	setupTestDB(t)
+	homeserver, matrix := newMatrixHomeserver(t, respondJSON(http.StatusOK, `{"event_id": "$event1"}`))
+	user := newMatrixUser(true)
+
+	if err := matrix.Send(user, newTestNotification(getSampleEncounter())); err != nil {
+		t.Fatal(err)
+	}
+	if err := matrix.Send(user, newTestNotification(getSampleEncounter())); err != nil {
+		t.Fatal(err)
+	}
+
+	first, second := homeserver.requests[0], homeserver.requests[1]
+	prefix := "/_matrix/client/v3/rooms/" + testMatrixRoom + "/send/m.room.message/"
+	if first.Method != http.MethodPut || !strings.HasPrefix(first.URL.Path, prefix) {
+		t.Errorf("request %s %s", first.Method, first.URL.Path)
+	}
+	if transactionIDOf(first.URL.Path) == "" || transactionIDOf(first.URL.Path) == transactionIDOf(second.URL.Path) {
+		t.Errorf("transaction IDs %s and %s", first.URL.Path, second.URL.Path)
+	}
+	if first.Header.Get("Authorization") != "Bearer syt_token" {
+		t.Errorf("authorization %s", first.Header.Get("Authorization"))
+	}
+	var message matrixMessage
+	if err := json.Unmarshal([]byte(homeserver.bodies[0]), &message); err != nil {
+		t.Fatal(err)
+	}
+	if message.MsgType != "m.text" || message.Format != "org.matrix.custom.html" {
+		t.Errorf("message %+v", message)
+	}
+	if !strings.HasPrefix(message.Body, "🔔 Pikachu <3\nThunder Shock / Thunderbolt\n") {
+		t.Errorf("body %q", message.Body)
+	}
+	if !strings.HasPrefix(message.FormattedBody, "<b>🔔 Pikachu &lt;3</b><br><i>Thunder Shock</i>") || !strings.Contains(message.FormattedBody, "<a href=") {
+		t.Errorf("formatted body %q", message.FormattedBody)
+	}
+
+	var stored []ExternalMessage
+	dbConfig.Find(&stored)
+	if len(stored) != 2 || stored[0].ChatID != user.ID || stored[0].EncounterID != "sample" || stored[0].Reference != "$event1" {
+		t.Errorf("stored messages %+v", stored)
+	}
+}
+
+func TestMatrixRedact(t *testing.T) {
+	homeserver, matrix := newMatrixHomeserver(t, respondJSON(http.StatusOK, `{"event_id": "$redaction"}`))
+
+	if err := matrix.Delete(newMatrixUser(true), "$event1"); err != nil {
+		t.Fatal(err)
+	}
+	request := homeserver.requests[0]
+	prefix := "/_matrix/client/v3/rooms/" + testMatrixRoom + "/redact/$event1/"
+	if request.Method != http.MethodPut || !strings.HasPrefix(request.URL.Path, prefix) || transactionIDOf(request.URL.Path) == "" {
+		t.Errorf("request %s %s", request.Method, request.URL.Path)
+	}
+}
+
+// Retrying rate limited messages is tested with Discord, Matrix only differs in the retry delay
+func TestMatrixRateLimit(t *testing.T) {
+	setupTemplateTest(t)
+	tests := []struct {
+		retryAfterMs int
+		expected     time.Duration
+	}{
+		{2500, 2500 * time.Millisecond},
+		// Retries wait at least a second
+		{300, time.Second},
+		{0, time.Second},
+	}
+	for _, test := range tests {
+		_, matrix := newMatrixHomeserver(t,
+			respondJSON(http.StatusTooManyRequests, fmt.Sprintf(`{"errcode": "M_LIMIT_EXCEEDED", "error": "Too many requests", "retry_after_ms": %d}`, test.retryAfterMs)),
+		)
+		err := matrix.Send(newMatrixUser(false), newTestNotification(getSampleEncounter()))
+		if retryAfter, limited := getRetryAfter(err); !limited || retryAfter != test.expected {
+			t.Errorf("retry_after_ms %d: error %v, retry after %s", test.retryAfterMs, err, retryAfter)
+		}
+	}
+}
+
+func TestMatrixErrors(t *testing.T) {
+	_, matrix := newMatrixHomeserver(t, respondJSON(http.StatusForbidden, `{"errcode": "M_FORBIDDEN", "error": "You are not in the room"}`))
+
+	err := matrix.Delete(newMatrixUser(true), "$event1")
+	if err == nil || !strings.Contains(err.Error(), "M_FORBIDDEN") {
+		t.Errorf("error %v", err)
+	}
+	if _, limited := getRetryAfter(err); limited {
+		t.Error("forbidden request would be retried")
+	}
+}
+
+func TestUnconfiguredNotifierIsSkipped(t *testing.T) {
+	setupTestDB(t)
+	queue := setupTestQueue(t)
+	delete(notifiers, "matrix")
+	user := newMatrixUser(true)
+
+	if _, err := getNotifier(user); err == nil {
+		t.Fatal("unconfigured notifier has been found")
+	}
+	if err := sendNotification(user, newTestNotification(getSampleEncounter())); err == nil {
+		t.Error("notification has been sent without notifier")
+	}
+	// Messages that cannot be redacted are forgotten
+	dbConfig.Create(&user)
+	getUsersByFilters()
+	recordExternalMessage(user.ID, "despawned", "$event1")
+	if deleted := deleteExternalMessages(0, "despawned", true); deleted != 0 {
+		t.Errorf("%d messages deleted", deleted)
+	}
+	var count int64
+	dbConfig.Model(&ExternalMessage{}).Count(&count)
+	if count != 0 {
+		t.Errorf("%d messages kept", count)
+	}
+	if held := len(queue.lanes[PriorityLow]) + len(queue.lanes[PriorityNormal]); held != 0 {
+		t.Errorf("%d messages queued", held)
+	}
+}
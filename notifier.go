@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Chat IDs of notification targets outside of Telegram start below all Telegram chat IDs
+// (channels are -100xxxxxxxxxx), so they can be stored as users like Telegram channels.
+const externalChatIDBase = -2000000000000
+
+// Notifier delivers notifications to a user or channel
+type Notifier interface {
+	// Send a notification about an encounter
+	Send(user User, notification Notification) error
+	// Delete a sent message by the reference it was stored with
+	Delete(user User, reference string) error
+}
+
+// Notification is an encounter notification rendered for a user
+type Notification struct {
+	Encounter EncounterData
+	Title     string // Telegram HTML
+	Text      string // Telegram HTML
+	Delivery  Delivery
+}
+
+// ExternalMessage is a message sent by a notifier other than Telegram, kept for cleanup
+type ExternalMessage struct {
+	ID          uint   `gorm:"primaryKey"`
+	ChatID      int64  `gorm:"index;not null"`
+	EncounterID string `gorm:"index;not null;size:25"`
+	Reference   string `gorm:"not null;size:255"`
+}
+
+var notifiers = map[string]Notifier{
+	"":        TelegramNotifier{},
+	"discord": NewDiscordNotifier(),
+}
+
+// Get the notifier of a user, Telegram unless the user points to another target.
+// Fails if the notifier is unknown or not configured, e.g. Matrix without a homeserver.
+func getNotifier(user User) (Notifier, error) {
+	if notifier, exists := notifiers[user.Notifier]; exists {
+		return notifier, nil
+	}
+	return nil, fmt.Errorf("notifier %s is not configured", user.Notifier)
+}
+
+// Send a notification. Other notifiers than Telegram are called by the delivery workers,
+// so a slow or rate limited service does not hold up the matching for all users.
+func sendNotification(user User, notification Notification) error {
+	notifier, err := getNotifier(user)
+	if err != nil {
+		return err
+	}
+	if !isExternal(user) {
+		// Telegram notifications queue their messages themselves
+		return notifier.Send(user, notification)
+	}
+	return deliveryQueue.Enqueue(&OutboundMessage{
+		ChatID:   user.ID,
+		Kind:     user.Notifier,
+		Delivery: notification.Delivery,
+		Deliver:  func() error { return notifier.Send(user, notification) },
+	})
+}
+
+// Check if a user is notified outside of Telegram
+func isExternal(user User) bool {
+	return user.Notifier != ""
+}
+
+// Check if a user has a Telegram chat, i.e. is not an external target added by an admin.
+// Users who linked another notifier keep their chat for the settings.
+func hasTelegramChat(user User) bool {
+	return user.ID > externalChatIDBase
+}
+
+// Check if a user is a channel, i.e. a Telegram channel or an external target
+func isChannel(user User) bool {
+	return !hasTelegramChat(user) || strings.HasPrefix(strconv.FormatInt(user.ID, 10), "-100")
+}
+
+// Get the next free chat ID for an external target
+func newExternalChatID() int64 {
+	var lowest User
+	if dbConfig.Where("id <= ?", externalChatIDBase).Order("id").Limit(1).Find(&lowest).RowsAffected == 0 {
+		return externalChatIDBase
+	}
+	return lowest.ID - 1
+}
+
+// Register an external target as a channel
+func addExternalTarget(notifier string, target string, language string) User {
+	user := User{ID: newExternalChatID(), Notifier: notifier, Target: target, Language: language, Notify: true}
+	dbConfig.Create(&user)
+	getUsersByFilters()
+	return user
+}
+
+// Get a display name of a channel
+func getChatTitle(user User) string {
+	if !hasTelegramChat(user) {
+		return fmt.Sprintf("%s %d", strings.ToUpper(user.Notifier[:1])+user.Notifier[1:], externalChatIDBase-user.ID+1)
+	}
+	chat, err := bot.ChatByID(user.ID)
+	if err != nil {
+		return strconv.FormatInt(user.ID, 10)
+	}
+	return chat.Title
+}
+
+func recordExternalMessage(chatID int64, encounterID string, reference string) {
+	if err := dbConfig.Create(&ExternalMessage{ChatID: chatID, EncounterID: encounterID, Reference: reference}).Error; err != nil {
+		log.Printf("❌ Failed to store message %s for %d: %v", reference, chatID, err)
+	}
+}
+
+// Delete the external messages of an encounter, of a single chat or of all chats (chatID 0).
+// The messages are deleted by the delivery workers, returns the number of queued deletions.
+func deleteExternalMessages(chatID int64, encounterID string, cleanupOnly bool) int {
+	var messages []ExternalMessage
+	query := dbConfig.Where("encounter_id = ?", encounterID)
+	if chatID != 0 {
+		query = query.Where("chat_id = ?", chatID)
+	}
+	query.Find(&messages)
+
+	deleted := 0
+	for _, message := range messages {
+		user := users.All[message.ChatID]
+		if !cleanupOnly || user.Cleanup {
+			notifier, err := getNotifier(user)
+			if err != nil {
+				log.Printf("⚠️ Skipping deletion of message %s for %d: %v", message.Reference, message.ChatID, err)
+				dbConfig.Delete(&message)
+				continue
+			}
+			reference := message.Reference
+			err = deliveryQueue.Enqueue(&OutboundMessage{
+				ChatID:   message.ChatID,
+				Kind:     "delete",
+				Delivery: Delivery{Priority: PriorityLow},
+				Deliver:  func() error { return notifier.Delete(user, reference) },
+			})
+			if err == nil {
+				deleted++
+			}
+		}
+		dbConfig.Delete(&message)
+	}
+	return deleted
+}
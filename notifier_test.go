@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+const testExternalChatID = externalChatIDBase
+
+// recordingServer records the requests to a test server of an external notifier, e.g. a
+// Discord webhook or a Matrix homeserver
+type recordingServer struct {
+	mutex    sync.Mutex
+	requests []*http.Request
+	bodies   []string
+	// Responses by request, the last one is repeated
+	responses []func(w http.ResponseWriter)
+}
+
+func newRecordingServer(t *testing.T, responses ...func(w http.ResponseWriter)) (*recordingServer, *httptest.Server) {
+	recorder := &recordingServer{responses: responses}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		recorder.mutex.Lock()
+		recorder.requests = append(recorder.requests, r)
+		recorder.bodies = append(recorder.bodies, string(body))
+		respond := recorder.responses[min(len(recorder.requests), len(recorder.responses))-1]
+		recorder.mutex.Unlock()
+		respond(w)
+	}))
+	t.Cleanup(server.Close)
+	return recorder, server
+}
+
+func respondJSON(status int, body string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const defaultNtfyServer = "https://ntfy.sh"
+
+// ntfy message priorities
+const (
+	ntfyPriorityLow     = 2
+	ntfyPriorityDefault = 3
+	ntfyPriorityMax     = 5
+)
+
+// Topics are used as URL path, ntfy only allows these characters
+var ntfyTopicPattern = regexp.MustCompile(`^[-_A-Za-z0-9]{1,64}$`)
+
+// NtfyNotifier publishes notifications to topics on an ntfy server.
+// The target of an ntfy user is the topic.
+type NtfyNotifier struct {
+	Server string
+	Token  string // Access token for servers requiring authentication, optional
+	client *http.Client
+}
+
+type NtfyMessage struct {
+	Topic    string   `json:"topic"`
+	Title    string   `json:"title,omitempty"`
+	Message  string   `json:"message"`
+	Markdown bool     `json:"markdown,omitempty"`
+	Priority int      `json:"priority,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Click    string   `json:"click,omitempty"`
+	Icon     string   `json:"icon,omitempty"`
+}
+
+func NewNtfyNotifier(server string, token string) *NtfyNotifier {
+	return &NtfyNotifier{
+		Server: strings.TrimSuffix(server, "/"),
+		Token:  token,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Use another ntfy server than ntfy.sh if configured
+func loadNtfyNotifier() {
+	server := os.Getenv("NTFY_SERVER")
+	if server == "" {
+		server = defaultNtfyServer
+	}
+	notifiers["ntfy"] = NewNtfyNotifier(server, os.Getenv("NTFY_TOKEN"))
+	log.Printf("✅ ntfy notifications enabled on %s", server)
+}
+
+func isValidNtfyTopic(topic string) bool {
+	return ntfyTopicPattern.MatchString(topic)
+}
+
+// Get the priority of a notification, 100% IV Pokémon are urgent and silent notifications do not make a sound
+func getNtfyPriority(notification Notification) int {
+	encounter := notification.Encounter
+	switch {
+	case notification.Delivery.Silent:
+		return ntfyPriorityLow
+	case encounter.IV != nil && *encounter.IV == 100:
+		return ntfyPriorityMax
+	}
+	return ntfyPriorityDefault
+}
+
+// Build the tags of a notification, tags matching an emoji short code are shown as emoji
+func getNtfyTags(encounter EncounterData) []string {
+	tags := []string{strings.ToLower(getPokemonName(encounter.PokemonID, "en"))}
+	if encounter.IV != nil && *encounter.IV == 100 {
+		tags = append(tags, "100")
+	}
+	if encounter.Shiny != nil && *encounter.Shiny {
+		tags = append(tags, "sparkles")
+	}
+	return tags
+}
+
+func (n *NtfyNotifier) Send(user User, notification Notification) error {
+	encounter := notification.Encounter
+	message := NtfyMessage{
+		Topic:    user.Target,
+		Title:    plainText(notification.Title),
+		Message:  markdownText(notification.Text),
+		Markdown: true,
+		Priority: getNtfyPriority(notification),
+		Tags:     getNtfyTags(encounter),
+		Click:    getMapLink(encounter.Lat, encounter.Lon),
+	}
+	// The ntfy apps can only show icons they can download
+	if icon := getPokemonIcon(uicons, encounter); strings.HasPrefix(icon, "https://") {
+		message.Icon = icon
+	}
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, n.Server, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if n.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+n.Token)
+	}
+	response, err := n.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	data, _ := io.ReadAll(response.Body)
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("ntfy server returned %s: %s", response.Status, data)
+	}
+	messagesCounter.Inc()
+	return nil
+}
+
+// Published ntfy messages cannot be removed from the devices, so they are not stored for the cleanup
+func (n *NtfyNotifier) Delete(user User, reference string) error {
+	return nil
+}
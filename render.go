@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// All messages are sent as Telegram HTML. Bot texts and translations keep using the
+// Markdown style markers *bold*, _italic_, `code` and ```pre```, which are converted to
+// HTML tags, while every interpolated value is escaped. A name containing markup
+// characters can therefore never break a message.
+
+var (
+	markupPre    = regexp.MustCompile("(?s)```\n?(.*?)\n?```")
+	markupCode   = regexp.MustCompile("`([^`\n]+)`")
+	markupBold   = regexp.MustCompile(`\*([^*\n]+)\*`)
+	markupItalic = regexp.MustCompile(`\b_([^_\n]+)_\b`)
+	htmlTag      = regexp.MustCompile(`<[^>]*>`)
+	htmlTagName  = regexp.MustCompile(`^<(/?)([a-z-]+)(\s[^>]*)?>$`)
+	htmlEntity   = regexp.MustCompile(`&(#[0-9]+|#x[0-9a-fA-F]+|lt|gt|amp|quot);`)
+
+	htmlMarkdown = strings.NewReplacer("<b>", "**", "</b>", "**", "<i>", "*", "</i>", "*", "<code>", "`", "</code>", "`", "<pre>", "```\n", "</pre>", "\n```")
+)
+
+// Escape a value for Telegram HTML
+func escapeHTML(value string) string {
+	return html.EscapeString(value)
+}
+
+// Convert a trusted text using Markdown style markers to Telegram HTML
+func markupHTML(text string) string {
+	text = escapeHTML(text)
+	text = markupPre.ReplaceAllString(text, "<pre>$1</pre>")
+	text = markupCode.ReplaceAllString(text, "<code>$1</code>")
+	text = markupBold.ReplaceAllString(text, "<b>$1</b>")
+	text = markupItalic.ReplaceAllString(text, "<i>$1</i>")
+	return text
+}
+
+// Format a trusted text using Markdown style markers as Telegram HTML, all arguments are escaped.
+// Numbers are passed as they are to keep verbs like %d and %.1f working.
+func formatHTML(format string, args ...interface{}) string {
+	escaped := make([]interface{}, len(args))
+	for i, arg := range args {
+		switch value := arg.(type) {
+		case string:
+			escaped[i] = escapeHTML(value)
+		case error:
+			escaped[i] = escapeHTML(value.Error())
+		case fmt.Stringer:
+			escaped[i] = escapeHTML(value.String())
+		default:
+			escaped[i] = arg
+		}
+	}
+	return fmt.Sprintf(markupHTML(format), escaped...)
+}
+
+// Convert Telegram HTML to plain text, e.g. for venue titles that do not support formatting
+func plainText(text string) string {
+	return strings.TrimSpace(html.UnescapeString(htmlTag.ReplaceAllString(text, "")))
+}
+
+// Convert Telegram HTML to Markdown, e.g. for Discord
+func markdownText(text string) string {
+	return strings.TrimSpace(html.UnescapeString(htmlTag.ReplaceAllString(htmlMarkdown.Replace(text), "")))
+}
+
+// Telegram limits photo captions to 1024 characters after parsing the formatting
+func fitsCaption(text string) bool {
+	return utf8.RuneCountInString(plainText(text)) <= 1024
+}
+
+// Tags supported by Telegram HTML, see https://core.telegram.org/bots/api#html-style
+var telegramTags = map[string]bool{
+	"b": true, "strong": true, "i": true, "em": true, "u": true, "ins": true, "s": true, "strike": true, "del": true,
+	"span": true, "tg-spoiler": true, "a": true, "tg-emoji": true, "code": true, "pre": true, "blockquote": true,
+}
+
+// Check that a text only uses the tags and entities supported by Telegram HTML and that
+// all tags are closed in order, Telegram rejects the whole message otherwise
+func validateTelegramHTML(text string) error {
+	var open []string
+	for _, tag := range htmlTag.FindAllString(text, -1) {
+		match := htmlTagName.FindStringSubmatch(tag)
+		if match == nil || !telegramTags[match[2]] {
+			return fmt.Errorf("unsupported tag %s", tag)
+		}
+		if match[1] == "" {
+			open = append(open, match[2])
+			continue
+		}
+		if len(open) == 0 || open[len(open)-1] != match[2] {
+			return fmt.Errorf("unexpected closing tag %s", tag)
+		}
+		open = open[:len(open)-1]
+	}
+	if len(open) > 0 {
+		return fmt.Errorf("unclosed tag <%s>", open[len(open)-1])
+	}
+	if rest := htmlEntity.ReplaceAllString(htmlTag.ReplaceAllString(text, ""), ""); strings.ContainsAny(rest, "<>&") {
+		return errors.New("unescaped <, > or &, use &lt;, &gt; or &amp;")
+	}
+	return nil
+}
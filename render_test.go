@@ -0,0 +1,243 @@
+package main
+
+import (
+	"html/template"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Names as they appear in gyms, channels, Telegram profiles and masterfile forms
+var markupNames = []string{
+	"Gym_of_*Stars*",
+	"<b>Channel</b> & Friends",
+	"user_name`s `code`",
+	"Tom & Jerry <3",
+	"*_`<&>`_*",
+}
+
+func TestEscapeHTML(t *testing.T) {
+	tests := map[string]string{
+		"Gym_of_*Stars*":            "Gym_of_*Stars*",
+		"<b>Channel</b> & Friends":  "&lt;b&gt;Channel&lt;/b&gt; &amp; Friends",
+		"Tom & Jerry <3":            "Tom &amp; Jerry &lt;3",
+		`"quoted" 'name'`:           "&#34;quoted&#34; &#39;name&#39;",
+		"already &amp; escaped":     "already &amp;amp; escaped",
+		"user_name`s `code`":        "user_name`s `code`",
+		"Pokéstop 🏟️ Unicode":       "Pokéstop 🏟️ Unicode",
+		"":                          "",
+		"<script>alert(1)</script>": "&lt;script&gt;alert(1)&lt;/script&gt;",
+	}
+	for input, expected := range tests {
+		if escaped := escapeHTML(input); escaped != expected {
+			t.Errorf("escapeHTML(%q) = %q, expected %q", input, escaped, expected)
+		}
+	}
+}
+
+func TestMarkupHTML(t *testing.T) {
+	tests := map[string]string{
+		"*bold* text":               "<b>bold</b> text",
+		"_italic_ text":             "<i>italic</i> text",
+		"use `/help` now":           "use <code>/help</code> now",
+		"```\nline 1\nline 2\n```":  "<pre>line 1\nline 2</pre>",
+		"*Min IV:* 90% & <more>":    "<b>Min IV:</b> 90% &amp; &lt;more&gt;",
+		"snake_case_word stays":     "snake_case_word stays",
+		"a * b * c":                 "a <b> b </b> c",
+		"unclosed *bold":            "unclosed *bold",
+		"📋 *Your Schedules:*":       "📋 <b>Your Schedules:</b>",
+		"`<code> & co`":             "<code>&lt;code&gt; &amp; co</code>",
+		"*bold*\n_italic_\n`code`":  "<b>bold</b>\n<i>italic</i>\n<code>code</code>",
+		"no markers at all":         "no markers at all",
+		"*multi\nline* is not bold": "*multi\nline* is not bold",
+		"see _this_ first":          "see <i>this</i> first",
+	}
+	for input, expected := range tests {
+		if markup := markupHTML(input); markup != expected {
+			t.Errorf("markupHTML(%q) = %q, expected %q", input, markup, expected)
+		}
+	}
+}
+
+func TestFormatHTMLEscapesArguments(t *testing.T) {
+	for _, name := range markupNames {
+		// Formats of the channel list, the gym search and the webhook list
+		formatted := formatHTML("🔹 *%s* @%s (%d) - Notify: %s\n", name, name, int64(-1001234567890), "✅")
+		expected := "🔹 <b>" + escapeHTML(name) + "</b> @" + escapeHTML(name) + " (-1001234567890) - Notify: ✅\n"
+		if formatted != expected {
+			t.Errorf("formatHTML with %q = %q, expected %q", name, formatted, expected)
+		}
+		if plain := plainText(formatted); !strings.Contains(plain, name) {
+			t.Errorf("plainText(%q) = %q, lost the name %q", formatted, plain, name)
+		}
+	}
+}
+
+func TestFormatHTMLArgumentTypes(t *testing.T) {
+	formatted := formatHTML("*IV:* %.1f%% L%d %s %v", float32(97.8), 35, testStringer("<Pikachu & Co>"), errTest("_failed_ <now>"))
+	expected := "<b>IV:</b> 97.8% L35 &lt;Pikachu &amp; Co&gt; _failed_ &lt;now&gt;"
+	if formatted != expected {
+		t.Errorf("formatHTML = %q, expected %q", formatted, expected)
+	}
+	// Code markers in the format are converted before arguments are inserted
+	formatted = formatHTML("✅ Webhook %d added, requests are signed with the secret `%s`", 3, "a`b<c>")
+	expected = "✅ Webhook 3 added, requests are signed with the secret <code>a`b&lt;c&gt;</code>"
+	if formatted != expected {
+		t.Errorf("formatHTML = %q, expected %q", formatted, expected)
+	}
+}
+
+func TestPlainAndMarkdownText(t *testing.T) {
+	html := "<b>🔔 " + escapeHTML("Pika_*chu* <&>") + "</b> <i>" + escapeHTML("`Form`") + "</i> <code>15|15|15</code>"
+	if plain := plainText(html); plain != "🔔 Pika_*chu* <&> `Form` 15|15|15" {
+		t.Errorf("plainText = %q", plain)
+	}
+	if markdown := markdownText(html); markdown != "**🔔 Pika_*chu* <&>** *`Form`* `15|15|15`" {
+		t.Errorf("markdownText = %q", markdown)
+	}
+}
+
+func TestRenderTemplateEscapesNames(t *testing.T) {
+	setupTemplateTest(t)
+	user := User{ID: 1, Language: "en"}
+
+	for _, name := range markupNames {
+		MasterFileData.Pokemon["25"].Forms["598"] = Form{Name: name}
+		encounter := getSampleEncounter()
+		form := 598
+		encounter.Form = &form
+
+		title := renderTemplate(user, "title", buildNotificationData(user, encounter))
+		if !strings.Contains(title, "("+escapeHTML(name)+")") {
+			t.Errorf("title does not contain the escaped form %q: %q", name, title)
+		}
+		if !strings.HasPrefix(title, "<b>🔔 Pikachu (") || strings.Count(title, "<b>") != 1 || strings.Count(title, "</b>") != 1 {
+			t.Errorf("form %q breaks the title markup: %q", name, title)
+		}
+		if !strings.Contains(plainText(title), name) {
+			t.Errorf("plain title does not contain the form %q: %q", name, plainText(title))
+		}
+	}
+}
+
+func TestRenderCustomTemplate(t *testing.T) {
+	setupTemplateTest(t)
+	user := User{ID: 2, Language: "en"}
+	MasterFileData.Moves["221"] = Move{Name: "Thunder_*Shock* <&>"}
+
+	parsed, err := parseTemplate("text", `<i>{{moveName .Move1 .Language}}</i> {{upper (pokemonName .PokemonID .Language)}} {{if .Shiny}}✨{{end}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userTemplates[user.ID] = map[string]*template.Template{"text": parsed}
+	defer delete(userTemplates, user.ID)
+
+	text := renderTemplate(user, "text", buildNotificationData(user, getSampleEncounter()))
+	if expected := "<i>Thunder_*Shock* &lt;&amp;&gt;</i> PIKACHU "; text != expected {
+		t.Errorf("renderTemplate = %q, expected %q", text, expected)
+	}
+}
+
+func TestRenderBrokenTemplateFallsBack(t *testing.T) {
+	setupTemplateTest(t)
+	user := User{ID: 3, Language: "en"}
+
+	parsed, err := parseTemplate("title", `{{.Missing}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userTemplates[user.ID] = map[string]*template.Template{"title": parsed}
+	defer delete(userTemplates, user.ID)
+
+	title := renderTemplate(user, "title", buildNotificationData(user, getSampleEncounter()))
+	if !strings.HasPrefix(title, "<b>🔔 Pikachu") {
+		t.Errorf("renderTemplate did not fall back to the default template: %q", title)
+	}
+}
+
+// Prepare the masterfile and default templates used by the sample encounter
+func setupTemplateTest(t *testing.T) {
+	t.Helper()
+	MasterFileData = MasterFile{
+		Pokemon: map[string]Pokemon{
+			"25": {Name: "Pikachu", Forms: map[string]Form{}},
+			"26": {Name: "Raichu", Forms: map[string]Form{}},
+		},
+		Moves: map[string]Move{"221": {Name: "Thunder Shock"}, "79": {Name: "Thunderbolt"}},
+	}
+	if len(parsedDefaultTemplates) == 0 {
+		loadDefaultTemplates()
+	}
+	if timezone == nil {
+		timezone = time.UTC
+	}
+}
+
+type testStringer string
+
+func (s testStringer) String() string { return string(s) }
+
+type errTest string
+
+func (e errTest) Error() string { return string(e) }
+
+func TestValidateTelegramHTML(t *testing.T) {
+	tests := []struct {
+		text  string
+		valid bool
+	}{
+		{"<b>🔔 Pikachu</b> <i>100%</i>", true},
+		{"<b><i>nested</i></b>", true},
+		{`<a href="https://example.com/?a=1&amp;b=2">map</a>`, true},
+		{"Tom &amp; Jerry &lt;3 &#39;", true},
+		{"<b>unclosed", false},
+		{"closed</b>", false},
+		{"<b><i>crossed</b></i>", false},
+		{"<h1>title</h1>", false},
+		{"line<br>break", false},
+		{"a < b", false},
+		{"Tom & Jerry", false},
+	}
+	for _, test := range tests {
+		if err := validateTelegramHTML(test.text); (err == nil) != test.valid {
+			t.Errorf("validateTelegramHTML(%q) = %v, expected valid %v", test.text, err, test.valid)
+		}
+	}
+}
+
+func TestDefaultTemplatesAreValidTelegramHTML(t *testing.T) {
+	setupTemplateTest(t)
+	for language := range defaultTemplates {
+		user := User{Language: language}
+		for _, kind := range templateKinds {
+			if err := validateTelegramHTML(renderTemplate(user, kind, buildNotificationData(user, getSampleEncounter()))); err != nil {
+				t.Errorf("%s %s template: %v", language, kind, err)
+			}
+		}
+	}
+}
+
+func TestSetTemplateValidatesHTML(t *testing.T) {
+	setupTemplateTest(t)
+	setupTestDB(t)
+	user := User{ID: 4, Language: "en"}
+	t.Cleanup(func() { resetTemplate(user.ID, "title") })
+
+	for _, text := range []string{`<b>{{pokemonName .PokemonID .Language}}`, `<blink>{{.IV}}</blink>`, `{{.IV}} & more`} {
+		if err := setTemplate(user, "title", text); err == nil {
+			t.Errorf("template %q has been saved", text)
+		}
+	}
+	var count int64
+	dbConfig.Model(&NotificationTemplate{}).Count(&count)
+	if count != 0 {
+		t.Errorf("%d invalid templates stored", count)
+	}
+
+	if err := setTemplate(user, "title", `<b>{{pokemonName .PokemonID .Language}}</b> {{.IV}}%`); err != nil {
+		t.Fatalf("valid template rejected: %v", err)
+	}
+	if text := getTemplateText(user, "title"); text != `<b>{{pokemonName .PokemonID .Language}}</b> {{.IV}}%` {
+		t.Errorf("stored template %q", text)
+	}
+}
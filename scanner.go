@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+
+	"gorm.io/gorm"
+)
+
+// ScannerAdapter reads the tables of a scanner database schema and normalizes them to the
+// encounter and gym models of the bot, which follow the Golbat schema.
+type ScannerAdapter interface {
+	// Fetch the encounters with IVs that have not despawned yet and were updated after
+	// (updated, id), ordered by updated and id
+	Encounters(db *gorm.DB, updated int, id string, now int64, limit int) ([]EncounterData, error)
+	// Fetch the gyms matching a condition on the columns both schemas share, e.g. id or name
+	Gyms(db *gorm.DB, condition string, args ...interface{}) ([]GymData, error)
+}
+
+var scannerAdapters = map[string]ScannerAdapter{
+	"golbat": GolbatAdapter{},
+	"rdm":    RDMAdapter{},
+}
+
+// Restrict a query to the rows updated after (updated, id), pages are fetched in this order
+func updatedAfter(query *gorm.DB, updated int, id string) *gorm.DB {
+	if id == "" {
+		return query.Where("updated > ?", updated)
+	}
+	return query.Where("updated > ? OR (updated = ? AND id > ?)", updated, updated, id)
+}
+
+// GolbatAdapter reads the Golbat schema, which the bot models are mapped to
+type GolbatAdapter struct{}
+
+func (GolbatAdapter) Encounters(db *gorm.DB, updated int, id string, now int64, limit int) ([]EncounterData, error) {
+	var encounters []EncounterData
+	query := db.Where("iv IS NOT NULL AND expire_timestamp > ?", now)
+	err := updatedAfter(query, updated, id).Order("updated, id").Limit(limit).Find(&encounters).Error
+	return encounters, err
+}
+
+func (GolbatAdapter) Gyms(db *gorm.DB, condition string, args ...interface{}) ([]GymData, error) {
+	var gyms []GymData
+	err := db.Where(condition, args...).Find(&gyms).Error
+	return gyms, err
+}
+
+// RDMAdapter reads the RDM schema
+type RDMAdapter struct{}
+
+// RDMPokemon is a row of the RDM pokemon table
+type RDMPokemon struct {
+	ID                      string
+	PokestopID              *string
+	SpawnID                 *int64
+	Lat                     float32
+	Lon                     float32
+	Weight                  *float32
+	Size                    *float32 // Height in meters, RDM has no size class
+	ExpireTimestamp         *int
+	Updated                 *int
+	PokemonID               int
+	Move1                   *int `gorm:"column:move_1"`
+	Move2                   *int `gorm:"column:move_2"`
+	Gender                  *int
+	CP                      *int
+	AtkIV                   *int
+	DefIV                   *int
+	StaIV                   *int
+	Form                    *int
+	Level                   *int
+	Weather                 *int
+	Costume                 *int
+	FirstSeenTimestamp      int
+	Changed                 int
+	CellID                  *int64
+	ExpireTimestampVerified bool
+	Shiny                   *bool
+	Username                *string
+	DisplayPokemonID        *int
+	Capture1                *float32 `gorm:"column:capture_1"`
+	Capture2                *float32 `gorm:"column:capture_2"`
+	Capture3                *float32 `gorm:"column:capture_3"`
+	PVPRankingsGreatLeague  *string  `gorm:"column:pvp_rankings_great_league"`
+	PVPRankingsUltraLeague  *string  `gorm:"column:pvp_rankings_ultra_league"`
+	IsEvent                 int
+}
+
+func (RDMPokemon) TableName() string {
+	return "pokemon"
+}
+
+// RDMGym is a row of the RDM gym table
+type RDMGym struct {
+	GymData
+	// RDM misspells the column of the available slots
+	AvailbleSlots *int `gorm:"column:availble_slots"`
+}
+
+func (RDMGym) TableName() string {
+	return "gym"
+}
+
+func (RDMAdapter) Encounters(db *gorm.DB, updated int, id string, now int64, limit int) ([]EncounterData, error) {
+	var rows []RDMPokemon
+	// The iv column is not available in all RDM versions
+	query := db.Where("atk_iv IS NOT NULL AND def_iv IS NOT NULL AND sta_iv IS NOT NULL AND expire_timestamp > ?", now)
+	if err := updatedAfter(query, updated, id).Order("updated, id").Limit(limit).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	encounters := make([]EncounterData, 0, len(rows))
+	for _, row := range rows {
+		encounters = append(encounters, row.toEncounter())
+	}
+	return encounters, nil
+}
+
+func (RDMAdapter) Gyms(db *gorm.DB, condition string, args ...interface{}) ([]GymData, error) {
+	var rows []RDMGym
+	if err := db.Where(condition, args...).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	gyms := make([]GymData, 0, len(rows))
+	for _, row := range rows {
+		row.GymData.AvailableSlots = row.AvailbleSlots
+		gyms = append(gyms, row.GymData)
+	}
+	return gyms, nil
+}
+
+// Convert an RDM Pokémon to an encounter
+func (pokemon RDMPokemon) toEncounter() EncounterData {
+	iv := float32(*pokemon.AtkIV+*pokemon.DefIV+*pokemon.StaIV) / 45 * 100
+	return EncounterData{
+		ID:                      pokemon.ID,
+		PokestopID:              pokemon.PokestopID,
+		SpawnID:                 pokemon.SpawnID,
+		Lat:                     pokemon.Lat,
+		Lon:                     pokemon.Lon,
+		Weight:                  pokemon.Weight,
+		Height:                  pokemon.Size,
+		ExpireTimestamp:         pokemon.ExpireTimestamp,
+		Updated:                 pokemon.Updated,
+		PokemonID:               pokemon.PokemonID,
+		Move1:                   pokemon.Move1,
+		Move2:                   pokemon.Move2,
+		Gender:                  pokemon.Gender,
+		CP:                      pokemon.CP,
+		AtkIV:                   pokemon.AtkIV,
+		DefIV:                   pokemon.DefIV,
+		StaIV:                   pokemon.StaIV,
+		Form:                    pokemon.Form,
+		Level:                   pokemon.Level,
+		Weather:                 pokemon.Weather,
+		Costume:                 pokemon.Costume,
+		FirstSeenTimestamp:      pokemon.FirstSeenTimestamp,
+		Changed:                 pokemon.Changed,
+		CellID:                  pokemon.CellID,
+		ExpireTimestampVerified: pokemon.ExpireTimestampVerified,
+		DisplayPokemonID:        pokemon.DisplayPokemonID,
+		IsDitto:                 pokemon.PokemonID == 132 && pokemon.DisplayPokemonID != nil,
+		Shiny:                   pokemon.Shiny,
+		Username:                pokemon.Username,
+		Capture1:                pokemon.Capture1,
+		Capture2:                pokemon.Capture2,
+		Capture3:                pokemon.Capture3,
+		IsEvent:                 pokemon.IsEvent,
+		PVP:                     pokemon.pvp(),
+		IV:                      &iv,
+	}
+}
+
+// Combine the PVP rankings of the leagues to the Golbat format, nil without rankings
+func (pokemon RDMPokemon) pvp() *string {
+	pvp := make(PVP)
+	leagues := map[string]*string{"great": pokemon.PVPRankingsGreatLeague, "ultra": pokemon.PVPRankingsUltraLeague}
+	for league, rankings := range leagues {
+		if rankings == nil || *rankings == "" {
+			continue
+		}
+		var entries []PokemonEntry
+		if err := json.Unmarshal([]byte(*rankings), &entries); err != nil {
+			log.Printf("❌ Failed to decode %s league rankings of encounter %s: %v", league, pokemon.ID, err)
+			continue
+		}
+		if len(entries) > 0 {
+			pvp[league] = entries
+		}
+	}
+	if len(pvp) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(pvp)
+	if err != nil {
+		return nil
+	}
+	text := string(data)
+	return &text
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// Time the scanner fixtures are read at, Pokémon expiring before are despawned
+const testScannerNow = 1760780000
+
+// Open a SQLite scanner database with the rows of a fixture in testdata/scanner
+func openTestScannerDB(t *testing.T, schema string) *gorm.DB {
+	t.Helper()
+	fixture, err := os.ReadFile(filepath.Join("testdata", "scanner", schema+".sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), schema+".db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	for _, statement := range strings.Split(string(fixture), ";\n") {
+		if strings.TrimSpace(statement) == "" {
+			continue
+		}
+		if err := db.Exec(statement).Error; err != nil {
+			t.Fatalf("%s fixture: %v", schema, err)
+		}
+	}
+	return db
+}
+
+func encounterIDs(encounters []EncounterData) string {
+	ids := make([]string, len(encounters))
+	for i, encounter := range encounters {
+		ids[i] = encounter.ID
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestGolbatAdapterEncounters(t *testing.T) {
+	db := openTestScannerDB(t, "golbat")
+
+	encounters, err := GolbatAdapter{}.Encounters(db, 0, "", testScannerNow, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without IVs and despawned Pokémon are skipped, the others are ordered by updated and id
+	if ids := encounterIDs(encounters); ids != "1001,1002,1005" {
+		t.Fatalf("encounters %s", ids)
+	}
+
+	pikachu := encounters[0]
+	if pikachu.PokemonID != 25 || *pikachu.Form != 598 || *pikachu.IV != 100 || *pikachu.AtkIV != 15 || *pikachu.Level != 35 || *pikachu.CP != 938 {
+		t.Errorf("Pikachu %+v", pikachu)
+	}
+	if *pikachu.Move1 != 221 || *pikachu.Move2 != 79 || *pikachu.Gender != 1 || *pikachu.Weather != 1 || *pikachu.Size != 3 || *pikachu.Height != float32(0.42) {
+		t.Errorf("Pikachu details %+v", pikachu)
+	}
+	if *pikachu.SpawnID != 1234567 || pikachu.PokestopID != nil || *pikachu.ExpireTimestamp != 1760781800 || *pikachu.Updated != 1760779900 || !pikachu.ExpireTimestampVerified {
+		t.Errorf("Pikachu spawn %+v", pikachu)
+	}
+	if *pikachu.Capture1 != float32(0.1) || *pikachu.Capture2 != float32(0.2) || *pikachu.Capture3 != float32(0.3) {
+		t.Errorf("capture rates %.1f %.1f %.1f", *pikachu.Capture1, *pikachu.Capture2, *pikachu.Capture3)
+	}
+	if !*pikachu.Shiny || *pikachu.SeenType != "encounter" || *pikachu.Username != "scanner01" {
+		t.Errorf("Pikachu shiny %v, seen %s by %s", *pikachu.Shiny, *pikachu.SeenType, *pikachu.Username)
+	}
+	var pvp PVP
+	if err := json.Unmarshal([]byte(*pikachu.PVP), &pvp); err != nil || len(pvp["great"]) != 1 || pvp["great"][0].Pokemon != 26 || pvp["great"][0].Rank != 2 {
+		t.Errorf("PVP %s: %v", *pikachu.PVP, err)
+	}
+
+	ditto := encounters[1]
+	if !ditto.IsDitto || *ditto.DisplayPokemonID != 16 || *ditto.PokestopID != "a1b2c3d4.16" || ditto.SpawnID != nil || ditto.PVP != nil {
+		t.Errorf("Ditto %+v", ditto)
+	}
+
+	// The next page starts after the last fetched Pokémon, also within the same second
+	page, err := GolbatAdapter{}.Encounters(db, *ditto.Updated, ditto.ID, testScannerNow, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := encounterIDs(page); ids != "1005" {
+		t.Errorf("next page %s", ids)
+	}
+	if limited, _ := (GolbatAdapter{}).Encounters(db, 0, "", testScannerNow, 2); encounterIDs(limited) != "1001,1002" {
+		t.Errorf("limited page %s", encounterIDs(limited))
+	}
+}
+
+func TestRDMAdapterEncounters(t *testing.T) {
+	db := openTestScannerDB(t, "rdm")
+
+	encounters, err := RDMAdapter{}.Encounters(db, 0, "", testScannerNow, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := encounterIDs(encounters); ids != "9001,9002" {
+		t.Fatalf("encounters %s", ids)
+	}
+
+	charizard := encounters[0]
+	if charizard.PokemonID != 6 || *charizard.Form != 178 || *charizard.IV != float32(44)/45*100 || *charizard.Level != 31 || *charizard.CP != 2289 {
+		t.Errorf("Charizard %+v", charizard)
+	}
+	// RDM stores the height as size and has no size class
+	if *charizard.Height != float32(1.61) || charizard.Size != nil || *charizard.Weight != float32(60.5) {
+		t.Errorf("Charizard height %v, size %v", charizard.Height, charizard.Size)
+	}
+	if *charizard.SpawnID != 2345678 || *charizard.CellID != 5163345235634208768 || *charizard.Capture3 != float32(0.35) || *charizard.Username != "device01" || *charizard.Shiny {
+		t.Errorf("Charizard details %+v", charizard)
+	}
+	// The rankings of both leagues are combined to the Golbat format
+	var pvp PVP
+	if err := json.Unmarshal([]byte(*charizard.PVP), &pvp); err != nil {
+		t.Fatalf("PVP %s: %v", *charizard.PVP, err)
+	}
+	if len(pvp) != 2 || len(pvp["great"]) != 2 || len(pvp["ultra"]) != 1 {
+		t.Fatalf("PVP %s", *charizard.PVP)
+	}
+	if great := pvp["great"][1]; great.Pokemon != 5 || great.Form != 899 || great.Rank != 12 || great.CP != 1497 || great.Level != 19 || great.Percentage != 0.981 {
+		t.Errorf("great league %+v", great)
+	}
+	if ultra := pvp["ultra"][0]; ultra.Pokemon != 6 || ultra.Rank != 3 || ultra.CP != 2494 {
+		t.Errorf("ultra league %+v", ultra)
+	}
+
+	// Empty rankings and 0% IV Pokémon
+	ditto := encounters[1]
+	if !ditto.IsDitto || *ditto.DisplayPokemonID != 13 || *ditto.IV != 0 || ditto.PVP != nil || ditto.IsEvent != 1 || *ditto.PokestopID != "c0ffee00.16" {
+		t.Errorf("Ditto %+v", ditto)
+	}
+}
+
+func TestScannerAdapterGyms(t *testing.T) {
+	golbat, rdm := openTestScannerDB(t, "golbat"), openTestScannerDB(t, "rdm")
+
+	gyms, err := GolbatAdapter{}.Gyms(golbat, "id = ?", "f1e2d3c4.16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gyms) != 1 {
+		t.Fatalf("%d gyms", len(gyms))
+	}
+	gym := gyms[0]
+	if *gym.Name != "Brandenburger Tor" || gym.Lat != 52.516275 || *gym.TeamID != 2 || *gym.AvailableSlots != 2 || *gym.GuardingPokemonID != 143 || *gym.Description != "Berlin landmark" {
+		t.Errorf("gym %+v", gym)
+	}
+	if *gym.RaidLevel != 5 || *gym.RaidPokemonID != 150 || *gym.RaidPokemonMove1 != 234 || *gym.RaidPokemonMove2 != 108 || *gym.RaidEndTimestamp != 1760782700 || *gym.RaidPokemonForm != 135 {
+		t.Errorf("raid %+v", gym)
+	}
+
+	gyms, err = RDMAdapter{}.Gyms(rdm, "lower(name) LIKE ?", "%welt%")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gyms) != 1 {
+		t.Fatalf("%d gyms", len(gyms))
+	}
+	gym = gyms[0]
+	// RDM misspells the available slots
+	if gym.ID != "b2c3d4e5.16" || *gym.Name != "Tor zur Welt" || gym.AvailableSlots == nil || *gym.AvailableSlots != 1 || *gym.TeamID != 1 {
+		t.Errorf("gym %+v", gym)
+	}
+	if *gym.RaidLevel != 6 || *gym.RaidPokemonID != 384 || *gym.RaidPokemonMove1 != 281 || *gym.RaidPokemonMove2 != 295 {
+		t.Errorf("raid %+v", gym)
+	}
+}
+
+func TestSearchGymsOfAllSources(t *testing.T) {
+	previousSources, previousNames := scannerSources, scannerSourceNames
+	t.Cleanup(func() { scannerSources, scannerSourceNames = previousSources, previousNames })
+	scannerSources = map[string]*ScannerSource{
+		"berlin":  {Name: "berlin", Type: "database", db: openTestScannerDB(t, "golbat"), adapter: GolbatAdapter{}},
+		"hamburg": {Name: "hamburg", Type: "database", db: openTestScannerDB(t, "rdm"), adapter: RDMAdapter{}},
+		"golbat":  {Name: "golbat", Type: "webhook"},
+	}
+	scannerSourceNames = []string{"berlin", "golbat", "hamburg"}
+
+	// Gyms known to several sources are found once, gyms without name are not listed
+	gyms := searchGyms("TOR")
+	if len(gyms) != 2 || gyms[0].ID != "f1e2d3c4.16" || *gyms[0].AvailableSlots != 2 || gyms[1].ID != "b2c3d4e5.16" || *gyms[1].AvailableSlots != 1 {
+		t.Errorf("gyms %+v", gyms)
+	}
+	if gyms := searchGyms("nowhere"); len(gyms) != 0 {
+		t.Errorf("gyms %+v", gyms)
+	}
+
+	if gym, ok := getGym("b2c3d4e5.16"); !ok || *gym.Name != "Tor zur Welt" {
+		t.Errorf("gym %+v found: %v", gym, ok)
+	}
+	if gym, ok := getGym("f1e2d3c4.16"); !ok || *gym.TeamID != 2 {
+		t.Errorf("gym of the first source %+v found: %v", gym, ok)
+	}
+	if _, ok := getGym("00000000.16"); ok {
+		t.Error("gym without name found")
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a weekly time window in which notifications are either muted (quiet)
+// or exclusively allowed. Start and End are minutes since midnight in the user's
+// timezone, windows with End before Start span midnight. Quiet and Days have no
+// database defaults, GORM would store them instead of false or zero on create.
+type Schedule struct {
+	ID     uint  `gorm:"primaryKey"`
+	UserID int64 `gorm:"index;not null"`
+	Quiet  bool  `gorm:"not null"`
+	Days   int   `gorm:"not null;size:8"` // Bitmask of time.Weekday
+	Start  int   `gorm:"not null;default:0;size:16"`
+	End    int   `gorm:"not null;default:0;size:16"`
+}
+
+const allDays = 1<<7 - 1
+
+var (
+	schedules   map[int64][]Schedule
+	weekdayKeys = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+func getSchedules() {
+	schedules = make(map[int64][]Schedule)
+	var allSchedules []Schedule
+	dbConfig.Order("id").Find(&allSchedules)
+	for _, schedule := range allSchedules {
+		schedules[schedule.UserID] = append(schedules[schedule.UserID], schedule)
+	}
+	log.Printf("📋 Loaded %d schedules", len(allSchedules))
+}
+
+func addSchedule(schedule Schedule) {
+	dbConfig.Create(&schedule)
+	getSchedules()
+}
+
+func clearSchedules(userID int64) {
+	dbConfig.Where("user_id = ?", userID).Delete(&Schedule{})
+	getSchedules()
+}
+
+// Check if the schedule window covers the given time
+func (schedule Schedule) covers(t time.Time) bool {
+	minutes := t.Hour()*60 + t.Minute()
+	today := schedule.Days&(1<<int(t.Weekday())) != 0
+	if schedule.Start <= schedule.End {
+		return today && minutes >= schedule.Start && minutes < schedule.End
+	}
+	// Window spans midnight, the early part belongs to the window of the previous day
+	yesterday := schedule.Days&(1<<int((t.Weekday()+6)%7)) != 0
+	return (today && minutes >= schedule.Start) || (yesterday && minutes < schedule.End)
+}
+
+// Check if notifications for a user are muted by their schedules at the given time.
+// Quiet windows always mute, if there are allow windows notifications are muted outside of them.
+func isQuietTime(user User, t time.Time) bool {
+	userSchedules := schedules[user.ID]
+	if len(userSchedules) == 0 {
+		return false
+	}
+	t = t.In(getUserTimezone(user))
+	hasAllowWindow := false
+	insideAllowWindow := false
+	for _, schedule := range userSchedules {
+		if schedule.Quiet {
+			if schedule.covers(t) {
+				return true
+			}
+			continue
+		}
+		hasAllowWindow = true
+		if schedule.covers(t) {
+			insideAllowWindow = true
+		}
+	}
+	return hasAllowWindow && !insideAllowWindow
+}
+
+// Parse a days specification like "daily", "weekdays", "weekends", "mon-fri" or "sat,sun"
+func parseDays(input string) (int, error) {
+	switch strings.ToLower(input) {
+	case "daily", "all":
+		return allDays, nil
+	case "weekdays":
+		return parseDays("mon-fri")
+	case "weekends":
+		return parseDays("sat,sun")
+	}
+
+	indexOf := func(key string) (int, error) {
+		for i, weekdayKey := range weekdayKeys {
+			if strings.HasPrefix(strings.ToLower(key), weekdayKey) {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("invalid weekday: %s", key)
+	}
+
+	days := 0
+	for _, part := range strings.Split(input, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		from, err := indexOf(bounds[0])
+		if err != nil {
+			return 0, err
+		}
+		to := from
+		if len(bounds) == 2 {
+			if to, err = indexOf(bounds[1]); err != nil {
+				return 0, err
+			}
+		}
+		for i := from; ; i = (i + 1) % 7 {
+			days |= 1 << i
+			if i == to {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// Parse a time of day like "23:00" into minutes since midnight, "24:00" is allowed as end of day
+func parseTimeOfDay(input string) (int, error) {
+	parts := strings.SplitN(input, ":", 2)
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid time: %s", input)
+	}
+	hours, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, fmt.Errorf("invalid time: %s", input)
+	}
+	minutes, err := strconv.Atoi(parts[1])
+	if err != nil || hours < 0 || minutes < 0 || minutes > 59 || hours*60+minutes > 24*60 {
+		return 0, fmt.Errorf("invalid time: %s", input)
+	}
+	return hours*60 + minutes, nil
+}
+
+// Parse a time range like "23:00-07:00"
+func parseTimeRange(input string) (int, int, error) {
+	parts := strings.SplitN(input, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid time range: %s", input)
+	}
+	start, err := parseTimeOfDay(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseTimeOfDay(parts[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	if start == end {
+		return 0, 0, fmt.Errorf("empty time range: %s", input)
+	}
+	return start, end, nil
+}
+
+func formatDays(days int) string {
+	if days == allDays {
+		return "daily"
+	}
+	var keys []string
+	// List Monday first
+	for i := 1; i <= 7; i++ {
+		if days&(1<<(i%7)) != 0 {
+			keys = append(keys, weekdayKeys[i%7])
+		}
+	}
+	return strings.Join(keys, ",")
+}
+
+func formatSchedule(schedule Schedule, language string) string {
+	kind := getTranslation("🌙 Quiet", language)
+	if !schedule.Quiet {
+		kind = getTranslation("🔔 Only", language)
+	}
+	return fmt.Sprintf("%s %s %02d:%02d-%02d:%02d", kind, formatDays(schedule.Days),
+		schedule.Start/60, schedule.Start%60, schedule.End/60, schedule.End%60)
+}
+
+// Build the list of schedules of a user shown by /schedule
+func buildScheduleList(userID int64, language string) string {
+	var text strings.Builder
+	text.WriteString(markupHTML(getTranslation("📅 *Your Schedules:*", language)) + "\n\n")
+	if len(schedules[userID]) == 0 {
+		text.WriteString(escapeHTML(getTranslation("🔹 You have no schedules, notifications are always sent", language)) + "\n")
+	}
+	for _, schedule := range schedules[userID] {
+		text.WriteString("🔹 " + escapeHTML(formatSchedule(schedule, language)) + "\n")
+	}
+	text.WriteString("\n" + escapeHTML(getTranslation("ℹ️ Usage: /schedule [quiet|only <days> <from>-<to>] [clear]", language)))
+	return text.String()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/telebot.v3"
+)
+
+// Hour of the day (in the user's timezone) at which a snooze "until tomorrow" ends
+const snoozeTomorrowHour = 7
+
+// Check if notifications for a user are paused
+func isSnoozed(user User) bool {
+	return user.SnoozedUntil > time.Now().Unix()
+}
+
+// Parse a snooze duration like "30m", "2h", "1d" or "tomorrow" into the time the snooze ends
+func parseSnooze(user User, input string) (time.Time, error) {
+	now := time.Now()
+	input = strings.ToLower(strings.TrimSpace(input))
+	if input == "tomorrow" {
+		local := now.In(getUserTimezone(user))
+		return time.Date(local.Year(), local.Month(), local.Day()+1, snoozeTomorrowHour, 0, 0, 0, local.Location()), nil
+	}
+	if days, found := strings.CutSuffix(input, "d"); found {
+		count, err := strconv.Atoi(days)
+		if err != nil || count <= 0 {
+			return time.Time{}, fmt.Errorf("invalid snooze duration: %s", input)
+		}
+		return now.AddDate(0, 0, count), nil
+	}
+	duration, err := time.ParseDuration(input)
+	if err != nil || duration <= 0 {
+		return time.Time{}, fmt.Errorf("invalid snooze duration: %s", input)
+	}
+	return now.Add(duration), nil
+}
+
+func snoozeUser(userID int64, until time.Time) {
+	updateUserPreference(userID, "SnoozedUntil", until.Unix())
+	getActiveSubscriptions()
+}
+
+func resumeUser(userID int64) {
+	updateUserPreference(userID, "SnoozedUntil", 0)
+	getActiveSubscriptions()
+}
+
+func buildSnoozeButtons(language string) *telebot.ReplyMarkup {
+	btn30m := telebot.InlineButton{Text: "30m", Unique: "snooze", Data: "30m"}
+	btn1h := telebot.InlineButton{Text: "1h", Unique: "snooze", Data: "1h"}
+	btn4h := telebot.InlineButton{Text: "4h", Unique: "snooze", Data: "4h"}
+	btnTomorrow := telebot.InlineButton{Text: getTranslation("🌅 Until tomorrow", language), Unique: "snooze", Data: "tomorrow"}
+	btnClose := telebot.InlineButton{Text: getTranslation("Close", language), Unique: "close"}
+	return &telebot.ReplyMarkup{InlineKeyboard: [][]telebot.InlineButton{{btn30m, btn1h, btn4h}, {btnTomorrow}, {btnClose}}}
+}
+
+// Resume users whose snooze has ended and let them know
+func checkSnoozeExpiry() {
+	now := time.Now().Unix()
+	var expired []User
+	for _, user := range users.All {
+		if user.SnoozedUntil > 0 && user.SnoozedUntil <= now {
+			expired = append(expired, user)
+		}
+	}
+	if len(expired) == 0 {
+		return
+	}
+
+	for _, user := range expired {
+		dbConfig.Model(&User{}).Where("id = ?", user.ID).Update("SnoozedUntil", 0)
+		log.Printf("⏰ Snooze ended for %d", user.ID)
+		if !hasTelegramChat(user) {
+			continue
+		}
+		if _, err := bot.Send(&telebot.User{ID: user.ID}, getTranslation("⏰ Your snooze has ended, notifications are active again", user.Language)); err != nil {
+			log.Printf("❌ Failed to send snooze end message: %v", err)
+		}
+	}
+	getUsersByFilters()
+	getActiveSubscriptions()
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+// ScannerSource is a source of Pokémon encounters, either a scanner database that is polled
+// or a Golbat instance sending webhooks
+type ScannerSource struct {
+	Name string       `json:"name"`
+	Type string       `json:"type"` // "database" or "webhook"
+	Area [][2]float64 `json:"area"` // Optional polygon of [lat, lon] points, encounters outside are ignored
+
+	// Database sources
+	Schema   string `json:"schema"` // "golbat" (default) or "rdm"
+	Host     string `json:"host"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	Database string `json:"database"`
+	Fallback string `json:"fallback"` // Only polled while this webhook source is not sending Pokémon
+
+	// Webhook sources
+	Listen string `json:"listen"`
+	Path   string `json:"path"` // Defaults to "/golbat"
+	Secret string `json:"secret"`
+
+	db          *gorm.DB
+	adapter     ScannerAdapter
+	cursor      string       // Name of the polling cursor
+	lastPokemon atomic.Int64 // Unix time of the last Pokémon received as webhook
+}
+
+// ReceivedEncounters are encounters received from a webhook source, processed by the background processing
+type ReceivedEncounters struct {
+	Source     *ScannerSource
+	Encounters []EncounterData
+}
+
+// receivedEncounter is the fingerprint of an encounter that has been matched
+type receivedEncounter struct {
+	fingerprint string
+	expiration  int
+}
+
+var (
+	scannerSources = make(map[string]*ScannerSource)
+	// Names of the sources in the order of the configuration
+	scannerSourceNames []string
+	// Encounters matched from any source, only accessed by the background processing
+	receivedEncounters = make(map[string]receivedEncounter)
+)
+
+// Load the scanner sources from the JSON file in SCANNER_SOURCES. Without it, a single source
+// is configured from the SCANNER_DB_* variables and GOLBAT_WEBHOOK_LISTEN.
+func loadScannerSources() error {
+	var sources []*ScannerSource
+	if filename := os.Getenv("SCANNER_SOURCES"); filename != "" {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return fmt.Errorf("failed to read scanner sources (%s): %w", filename, err)
+		}
+		if err := json.Unmarshal(data, &sources); err != nil {
+			return fmt.Errorf("failed to unmarshal JSON from (%s): %w", filename, err)
+		}
+	} else {
+		sources = defaultScannerSources()
+	}
+	if len(sources) == 0 {
+		return fmt.Errorf("no scanner sources configured")
+	}
+
+	for _, source := range sources {
+		if source.Name == "" {
+			return fmt.Errorf("scanner source without name")
+		}
+		if _, exists := scannerSources[source.Name]; exists {
+			return fmt.Errorf("duplicate scanner source: %s", source.Name)
+		}
+		if len(source.Area) > 0 && len(source.Area) < 3 {
+			return fmt.Errorf("area of scanner source %s needs at least 3 points", source.Name)
+		}
+		switch source.Type {
+		case "database":
+			if source.Schema == "" {
+				source.Schema = "golbat"
+			}
+			adapter, exists := scannerAdapters[source.Schema]
+			if !exists {
+				return fmt.Errorf("unknown schema of scanner source %s: %s", source.Name, source.Schema)
+			}
+			source.adapter = adapter
+			if source.cursor == "" {
+				source.cursor = source.Name
+			}
+		case "webhook":
+			if source.Listen == "" {
+				return fmt.Errorf("webhook scanner source %s without listen address", source.Name)
+			}
+			if source.Path == "" {
+				source.Path = "/golbat"
+			}
+		default:
+			return fmt.Errorf("unknown type of scanner source %s: %s", source.Name, source.Type)
+		}
+		scannerSources[source.Name] = source
+		scannerSourceNames = append(scannerSourceNames, source.Name)
+	}
+	for _, source := range sources {
+		if source.Fallback == "" {
+			continue
+		}
+		if fallback, exists := scannerSources[source.Fallback]; !exists || fallback.Type != "webhook" {
+			return fmt.Errorf("fallback of scanner source %s is not a webhook source: %s", source.Name, source.Fallback)
+		}
+	}
+	return nil
+}
+
+// Configure a single source from the environment variables
+func defaultScannerSources() []*ScannerSource {
+	database := &ScannerSource{
+		Name:     "default",
+		Type:     "database",
+		Schema:   os.Getenv("SCANNER_SCHEMA"),
+		Host:     os.Getenv("SCANNER_DB_HOST"),
+		User:     os.Getenv("SCANNER_DB_USER"),
+		Password: os.Getenv("SCANNER_DB_PASS"),
+		Database: os.Getenv("SCANNER_DB_NAME"),
+		// Keep the cursor stored before sources could be configured
+		cursor: "pokemon",
+	}
+	sources := []*ScannerSource{database}
+	if address := os.Getenv("GOLBAT_WEBHOOK_LISTEN"); address != "" {
+		database.Fallback = "golbat"
+		sources = append(sources, &ScannerSource{
+			Name:   "golbat",
+			Type:   "webhook",
+			Listen: address,
+			Secret: os.Getenv("GOLBAT_WEBHOOK_SECRET"),
+		})
+	}
+	return sources
+}
+
+// Connect to the databases of all database sources
+func connectScannerSources() {
+	for _, name := range scannerSourceNames {
+		source := scannerSources[name]
+		if source.Type != "database" {
+			continue
+		}
+		dsn := fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=utf8mb4&parseTime=True&loc=Local", source.User, source.Password, source.Host, source.Database)
+		var err error
+		source.db, err = gorm.Open(mysql.Open(dsn), &gorm.Config{})
+		if err != nil {
+			log.Fatalf("❌ Failed to connect to encounter database of %s: %v", source.Name, err)
+		}
+		log.Printf("✅ Connected to encounter database of %s (%s schema)", source.Name, source.Schema)
+	}
+}
+
+// Start the HTTP servers receiving the webhooks of all webhook sources, sources may share a listen address
+func startWebhookSources() {
+	servers := make(map[string]*http.ServeMux)
+	var addresses []string
+	for _, name := range scannerSourceNames {
+		source := scannerSources[name]
+		if source.Type != "webhook" {
+			continue
+		}
+		mux, exists := servers[source.Listen]
+		if !exists {
+			mux = http.NewServeMux()
+			servers[source.Listen] = mux
+			addresses = append(addresses, source.Listen)
+		}
+		mux.HandleFunc(source.Path, func(w http.ResponseWriter, r *http.Request) {
+			handleGolbatWebhook(w, r, source)
+		})
+		log.Printf("🚀 Receiving Golbat webhooks of %s at %s%s", source.Name, source.Listen, source.Path)
+	}
+	for _, address := range addresses {
+		go func() {
+			if err := http.ListenAndServe(address, servers[address]); err != nil {
+				log.Fatalf("❌ Golbat webhook server error: %v", err)
+			}
+		}()
+	}
+}
+
+// Check if Pokémon are received from a webhook source
+func (source *ScannerSource) isActive() bool {
+	return time.Since(time.Unix(source.lastPokemon.Load(), 0)) < golbatWebhookTimeout
+}
+
+// Check if a database source has to be polled, it is not if its fallback webhook source is active
+func (source *ScannerSource) needsPolling() bool {
+	return source.Type == "database" && (source.Fallback == "" || !scannerSources[source.Fallback].isActive())
+}
+
+// Poll all database sources that are not replaced by webhooks
+func pollScannerSources() {
+	for _, name := range scannerSourceNames {
+		if source := scannerSources[name]; source.needsPolling() {
+			processEncounters(source)
+		}
+	}
+}
+
+// Check if an encounter is within the area of a source, sources without area accept all encounters
+func (source *ScannerSource) contains(encounter EncounterData) bool {
+	if len(source.Area) == 0 {
+		return true
+	}
+	lat, lon := float64(encounter.Lat), float64(encounter.Lon)
+	inside := false
+	for i, j := 0, len(source.Area)-1; i < len(source.Area); j, i = i, i+1 {
+		a, b := source.Area[i], source.Area[j]
+		if (a[1] > lon) != (b[1] > lon) && lat < (b[0]-a[0])*(lon-a[1])/(b[1]-a[1])+a[0] {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// Match the encounters received from a source. Encounters outside of the area of the source are dropped,
+// and encounters that have already been matched unchanged, e.g. from another source, are skipped.
+func receiveEncounters(source *ScannerSource, encounters []EncounterData) {
+	indexes := make(map[string]int, len(encounters))
+	var unique []EncounterData
+	for _, encounter := range encounters {
+		if !source.contains(encounter) {
+			continue
+		}
+		// Keep the latest state of encounters received more than once
+		if index, exists := indexes[encounter.ID]; exists {
+			unique[index] = encounter
+			continue
+		}
+		indexes[encounter.ID] = len(unique)
+		unique = append(unique, encounter)
+	}
+
+	changed := make([]EncounterData, 0, len(unique))
+	for _, encounter := range unique {
+		fingerprint := getEncounterFingerprint(encounter)
+		if received, exists := receivedEncounters[encounter.ID]; exists && received.fingerprint == fingerprint {
+			continue
+		}
+		receivedEncounters[encounter.ID] = receivedEncounter{fingerprint: fingerprint, expiration: *encounter.ExpireTimestamp}
+		changed = append(changed, encounter)
+	}
+	if skipped := len(unique) - len(changed); skipped > 0 {
+		log.Printf("♻️ Skipping %d Pokémon of %s that have already been matched", skipped, source.Name)
+	}
+	if len(changed) > 0 {
+		filterAndSendEncounters(users, changed)
+	}
+}
+
+// Forget the matched encounters that have despawned
+func pruneReceivedEncounters() {
+	now := int(time.Now().Unix())
+	for encounterID, received := range receivedEncounters {
+		if received.expiration < now {
+			delete(receivedEncounters, encounterID)
+		}
+	}
+}
+
+// Search the gyms of all database sources by name
+func searchGyms(name string) []GymData {
+	var gyms []GymData
+	seen := make(map[string]bool)
+	for _, sourceName := range scannerSourceNames {
+		source := scannerSources[sourceName]
+		if source.db == nil {
+			continue
+		}
+		found, err := source.adapter.Gyms(source.db, "lower(name) LIKE ?", "%"+strings.ToLower(name)+"%")
+		if err != nil {
+			log.Printf("❌ Failed to search gyms of %s: %v", source.Name, err)
+			continue
+		}
+		for _, gym := range found {
+			if !seen[gym.ID] && gym.Name != nil {
+				seen[gym.ID] = true
+				gyms = append(gyms, gym)
+			}
+		}
+	}
+	return gyms
+}
+
+// Get a gym from the first database source that knows it
+func getGym(gymID string) (GymData, bool) {
+	for _, name := range scannerSourceNames {
+		source := scannerSources[name]
+		if source.db == nil {
+			continue
+		}
+		found, err := source.adapter.Gyms(source.db, "id = ?", gymID)
+		if err != nil {
+			log.Printf("❌ Failed to get gym %s of %s: %v", gymID, source.Name, err)
+			continue
+		}
+		if len(found) > 0 && found[0].Name != nil {
+			return found[0], true
+		}
+	}
+	return GymData{}, false
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScannerSourceContains(t *testing.T) {
+	// An L-shaped area around the center of Berlin, the north east is cut out
+	area := [][2]float64{{52.45, 13.30}, {52.45, 13.50}, {52.50, 13.50}, {52.50, 13.40}, {52.55, 13.40}, {52.55, 13.30}}
+	tests := []struct {
+		name     string
+		area     [][2]float64
+		lat, lon float32
+		expected bool
+	}{
+		{"without area", nil, 48.1374, 11.5755, true},
+		{"inside", area, 52.5163, 13.3777, true},
+		{"inside the lower part", area, 52.47, 13.45, true},
+		{"in the cut out corner", area, 52.52, 13.45, false},
+		{"north", area, 52.60, 13.35, false},
+		{"east", area, 52.47, 13.60, false},
+		{"far away", area, 48.1374, 11.5755, false},
+	}
+	for _, test := range tests {
+		source := &ScannerSource{Name: "berlin", Area: test.area}
+		encounter := EncounterData{Lat: test.lat, Lon: test.lon}
+		if inside := source.contains(encounter); inside != test.expected {
+			t.Errorf("%s: contains %v, expected %v", test.name, inside, test.expected)
+		}
+	}
+}
+
+func TestReceiveEncountersFromSeveralSources(t *testing.T) {
+	setupTestDB(t)
+	previousUsers, previousSent, previousReceived := users, sentNotifications, receivedEncounters
+	t.Cleanup(func() { users, sentNotifications, receivedEncounters = previousUsers, previousSent, previousReceived })
+	sentNotifications = NewSentNotificationCache(10)
+	// A channel collecting all matches in an email digest, its notifier is not configured
+	delete(notifiers, "matrix")
+	channel := User{ID: testExternalChatID, Notifier: "matrix", Language: "en", MinIV: 1, Email: "team@example.com", EmailDigest: "daily"}
+	users = FilteredUsers{All: map[int64]User{channel.ID: channel}, Channels: []User{channel}}
+	t.Cleanup(func() {
+		emailMutex.Lock()
+		delete(emailDigestBuffer, channel.ID)
+		emailMutex.Unlock()
+	})
+	// Get the scanner that sent the encounter matched last
+	matchedFrom := func(encounterID string) string {
+		emailMutex.Lock()
+		defer emailMutex.Unlock()
+		encounter, matched := emailDigestBuffer[channel.ID][encounterID]
+		if !matched || encounter.Username == nil {
+			return ""
+		}
+		return *encounter.Username
+	}
+	berlin := &ScannerSource{Name: "berlin"}
+	potsdam := &ScannerSource{Name: "potsdam", Area: [][2]float64{{52.35, 13.00}, {52.35, 13.15}, {52.45, 13.15}, {52.45, 13.00}}}
+	encounterFrom := func(id string, scanner string, cp int) EncounterData {
+		encounter := getSampleEncounter()
+		encounter.ID = id
+		encounter.Username = &scanner
+		encounter.CP = &cp
+		return encounter
+	}
+
+	tests := []struct {
+		name       string
+		first      EncounterData
+		second     EncounterData
+		secondFrom *ScannerSource
+		expected   string
+	}{
+		{"same fingerprint", encounterFrom("1", "berlin", 938), encounterFrom("1", "potsdam", 938), berlin, "berlin"},
+		{"different fingerprint", encounterFrom("2", "berlin", 938), encounterFrom("2", "potsdam", 940), berlin, "potsdam"},
+		{"outside of the area", encounterFrom("3", "berlin", 938), encounterFrom("3", "potsdam", 940), potsdam, "berlin"},
+	}
+	for _, test := range tests {
+		receivedEncounters = make(map[string]receivedEncounter)
+		receiveEncounters(berlin, []EncounterData{test.first})
+		receiveEncounters(test.secondFrom, []EncounterData{test.second})
+		if from := matchedFrom(test.first.ID); from != test.expected {
+			t.Errorf("%s: matched from %q, expected %q", test.name, from, test.expected)
+		}
+	}
+
+	// The latest state of an encounter received twice in a batch is matched
+	receivedEncounters = make(map[string]receivedEncounter)
+	receiveEncounters(berlin, []EncounterData{encounterFrom("4", "first", 938), encounterFrom("4", "second", 940)})
+	if from := matchedFrom("4"); from != "second" || len(receivedEncounters) != 1 {
+		t.Errorf("matched from %q, %d encounters received", from, len(receivedEncounters))
+	}
+}
+
+func TestNeedsPolling(t *testing.T) {
+	previousSources := scannerSources
+	t.Cleanup(func() { scannerSources = previousSources })
+	webhook := &ScannerSource{Name: "golbat", Type: "webhook"}
+	scannerSources = map[string]*ScannerSource{"golbat": webhook}
+	withFallback := &ScannerSource{Name: "berlin", Type: "database", Fallback: "golbat"}
+	withoutFallback := &ScannerSource{Name: "potsdam", Type: "database"}
+
+	tests := []struct {
+		name        string
+		lastPokemon time.Time
+		expected    bool
+	}{
+		{"no webhooks received", time.Time{}, true},
+		{"webhooks received", time.Now(), false},
+		{"webhooks received shortly ago", time.Now().Add(-golbatWebhookTimeout / 2), false},
+		{"webhooks stopped", time.Now().Add(-golbatWebhookTimeout - time.Second), true},
+	}
+	for _, test := range tests {
+		webhook.lastPokemon.Store(test.lastPokemon.Unix())
+		if polled := withFallback.needsPolling(); polled != test.expected {
+			t.Errorf("%s: polled %v, expected %v", test.name, polled, test.expected)
+		}
+		if !withoutFallback.needsPolling() {
+			t.Errorf("%s: source without fallback is not polled", test.name)
+		}
+		if webhook.needsPolling() {
+			t.Errorf("%s: webhook source is polled", test.name)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import "gopkg.in/telebot.v3"
+
+// TelegramNotifier sends notifications to Telegram chats through the delivery queue
+type TelegramNotifier struct{}
+
+// Queue the messages of a notification as a single job, so they are sent in order
+// and a full queue drops the whole notification instead of some of its messages
+func (TelegramNotifier) Send(user User, notification Notification) error {
+	encounter := notification.Encounter
+	delivery := notification.Delivery
+	text := notification.Title + "\n" + notification.Text
+
+	// Send a single photo with the notification as caption
+	if !user.OnlyMap && user.Compact {
+		// Maps are rendered by the delivery workers
+		photo := func() telebot.File { return getNotificationPhoto(user, encounter) }
+		if fitsCaption(text) {
+			return deliveryQueue.Enqueue(newPhotoMessage(user.ID, photo, text, buildOpenMapButton(user, encounter), delivery))
+		}
+		// Send the text separately if it is too long for a caption
+		return deliveryQueue.Enqueue(chainMessages(
+			newPhotoMessage(user.ID, photo, "", buildOpenMapButton(user, encounter), delivery),
+			newTextMessage(user.ID, text, delivery),
+		))
+	}
+
+	if user.OnlyMap {
+		return deliveryQueue.Enqueue(newVenueMessage(user.ID, encounter.Lat, encounter.Lon, plainText(notification.Title), plainText(notification.Text), delivery))
+	}
+	var messages []*OutboundMessage
+	if user.Stickers {
+		messages = append(messages, newStickerMessage(user.ID, getPokemonIcon(uiconsStickers, encounter), delivery))
+	}
+	messages = append(messages,
+		newLocationMessage(user.ID, encounter.Lat, encounter.Lon, delivery),
+		newTextMessage(user.ID, text, delivery),
+	)
+	return deliveryQueue.Enqueue(chainMessages(messages...))
+}
+
+func (TelegramNotifier) Delete(user User, reference string) error {
+	return bot.Delete(&telebot.StoredMessage{MessageID: reference, ChatID: user.ID})
+}
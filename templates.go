@@ -0,0 +1,313 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NotificationTemplate overrides the default template of a message kind for a user or channel
+type NotificationTemplate struct {
+	ChatID   int64  `gorm:"primaryKey;autoIncrement:false"`
+	Kind     string `gorm:"primaryKey;size:10"`
+	Template string `gorm:"not null;type:text"`
+}
+
+// NotificationData is the data available in notification templates. Only curated fields are
+// exposed, templates can be set by every user and must not reveal scanner internals.
+type NotificationData struct {
+	Language        string
+	PokemonID       int
+	Form            string // Translated name of a non-default form, empty otherwise
+	Costume         bool
+	Gender          string
+	IV              float32
+	Atk             int
+	Def             int
+	Sta             int
+	CP              int
+	Level           int
+	Size            string
+	Weather         string
+	Move1           int
+	Move2           int
+	Shiny           bool
+	Lat             float32
+	Lon             float32
+	HasDistance     bool
+	Distance        float64 // In meters
+	TravelMode      string
+	ArrivalTime     string
+	ExpireTime      string
+	ExpireTimestamp int
+	PVP             []PVPRank
+}
+
+// PVPRank is a top ranking of an encounter in a PVP league
+type PVPRank struct {
+	League    string
+	Rank      int16
+	PokemonID int
+	CP        int
+	Level     float64
+}
+
+// Message kinds that can be customised with templates
+var templateKinds = []string{"title", "text"}
+
+// Default templates per language and message kind. Templates produce Telegram HTML,
+// values are escaped automatically.
+var defaultTemplates = map[string]map[string]string{
+	"en": {
+		"title": `<b>🔔 {{pokemonName .PokemonID .Language}}{{if .Form}} ({{if .Costume}}👕 {{end}}{{.Form}}){{end}} {{.Gender}} {{printf "%.1f" .IV}}% {{.Atk}}|{{.Def}}|{{.Sta}} {{.CP}}CP L{{.Level}}</b>{{if .Size}} {{.Size}}{{end}} {{.Weather}}`,
+		"text": `{{if .HasDistance}}📍 {{distance .Distance}}{{if .ArrivalTime}} {{.TravelMode}} {{.ArrivalTime}}{{end}}
+{{end}}💨 {{.ExpireTime}} ⏳ {{timeLeft .ExpireTimestamp}}
+{{if and .Move1 .Move2}}💥 {{moveName .Move1 .Language}} / {{moveName .Move2 .Language}}{{end}}{{range .PVP}}
+🏅 <b>{{.League}} Rank {{.Rank}}</b>: {{pokemonName .PokemonID $.Language}} {{.CP}}CP L{{printf "%.1f" .Level}}{{end}}`,
+	},
+	"de": {
+		"title": `<b>🔔 {{pokemonName .PokemonID .Language}}{{if .Form}} ({{if .Costume}}👕 {{end}}{{.Form}}){{end}} {{.Gender}} {{printf "%.1f" .IV}}% {{.Atk}}|{{.Def}}|{{.Sta}} {{.CP}}WP L{{.Level}}</b>{{if .Size}} {{.Size}}{{end}} {{.Weather}}`,
+		"text": `{{if .HasDistance}}📍 {{distance .Distance}}{{if .ArrivalTime}} {{.TravelMode}} {{.ArrivalTime}}{{end}}
+{{end}}💨 {{.ExpireTime}} ⏳ {{timeLeft .ExpireTimestamp}}
+{{if and .Move1 .Move2}}💥 {{moveName .Move1 .Language}} / {{moveName .Move2 .Language}}{{end}}{{range .PVP}}
+🏅 <b>{{.League}} Rang {{.Rank}}</b>: {{pokemonName .PokemonID $.Language}} {{.CP}}WP L{{printf "%.1f" .Level}}{{end}}`,
+	},
+}
+
+var templateFuncs = template.FuncMap{
+	"pokemonName": getPokemonName,
+	"moveName":    getMoveName,
+	"translate":   getTranslation,
+	"distance":    formatDistance,
+	"timeLeft": func(expireTimestamp int) string {
+		return time.Until(time.Unix(int64(expireTimestamp), 0)).Truncate(time.Second).String()
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+func formatDistance(meters float64) string {
+	if meters < 1000 {
+		return fmt.Sprintf("%.0fm", meters)
+	}
+	return fmt.Sprintf("%.2fkm", meters/1000)
+}
+
+var (
+	parsedDefaultTemplates = make(map[string]map[string]*template.Template)
+	userTemplates          = make(map[int64]map[string]*template.Template)
+	userTemplatesMutex     sync.RWMutex
+)
+
+// Parse a notification template. html/template is used instead of text/template because it
+// escapes every value, so names from the masterfile or translations can not break the HTML.
+func parseTemplate(kind string, text string) (*template.Template, error) {
+	return template.New(kind).Funcs(templateFuncs).Parse(text)
+}
+
+func loadDefaultTemplates() {
+	for language, kinds := range defaultTemplates {
+		parsedDefaultTemplates[language] = make(map[string]*template.Template)
+		for kind, text := range kinds {
+			parsedDefaultTemplates[language][kind] = template.Must(parseTemplate(kind, text))
+		}
+	}
+}
+
+func getTemplates() {
+	var templates []NotificationTemplate
+	dbConfig.Find(&templates)
+
+	loaded := make(map[int64]map[string]*template.Template)
+	for _, notificationTemplate := range templates {
+		parsed, err := parseTemplate(notificationTemplate.Kind, notificationTemplate.Template)
+		if err != nil {
+			log.Printf("❌ Failed to parse %s template of %d: %v", notificationTemplate.Kind, notificationTemplate.ChatID, err)
+			continue
+		}
+		if loaded[notificationTemplate.ChatID] == nil {
+			loaded[notificationTemplate.ChatID] = make(map[string]*template.Template)
+		}
+		loaded[notificationTemplate.ChatID][notificationTemplate.Kind] = parsed
+	}
+
+	userTemplatesMutex.Lock()
+	userTemplates = loaded
+	userTemplatesMutex.Unlock()
+	log.Printf("📋 Loaded %d notification templates", len(templates))
+}
+
+func getDefaultTemplate(language string, kind string) *template.Template {
+	if templates, exists := parsedDefaultTemplates[language]; exists {
+		return templates[kind]
+	}
+	return parsedDefaultTemplates["en"][kind]
+}
+
+// Validate a template by rendering the sample encounter and store it for the chat.
+// The sample must be valid Telegram HTML, otherwise Telegram would reject every notification.
+func setTemplate(user User, kind string, text string) error {
+	parsed, err := parseTemplate(kind, text)
+	if err != nil {
+		return err
+	}
+	var output strings.Builder
+	if err := parsed.Execute(&output, buildNotificationData(user, getSampleEncounter())); err != nil {
+		return err
+	}
+	if err := validateTelegramHTML(output.String()); err != nil {
+		return err
+	}
+	dbConfig.Save(&NotificationTemplate{ChatID: user.ID, Kind: kind, Template: text})
+	getTemplates()
+	return nil
+}
+
+func resetTemplate(chatID int64, kind string) {
+	dbConfig.Where("chat_id = ? AND kind = ?", chatID, kind).Delete(&NotificationTemplate{})
+	getTemplates()
+}
+
+// Get the template source of a message kind used for a user
+func getTemplateText(user User, kind string) string {
+	var notificationTemplate NotificationTemplate
+	if dbConfig.Where("chat_id = ? AND kind = ?", user.ID, kind).Limit(1).Find(&notificationTemplate).RowsAffected > 0 {
+		return notificationTemplate.Template
+	}
+	if templates, exists := defaultTemplates[user.Language]; exists {
+		return templates[kind]
+	}
+	return defaultTemplates["en"][kind]
+}
+
+// Render a message kind for a user, falls back to the default template if the custom one fails
+func renderTemplate(user User, kind string, data NotificationData) string {
+	userTemplatesMutex.RLock()
+	custom := userTemplates[user.ID][kind]
+	userTemplatesMutex.RUnlock()
+
+	var output strings.Builder
+	if custom != nil {
+		err := custom.Execute(&output, data)
+		if err == nil {
+			return output.String()
+		}
+		log.Printf("❌ Failed to render %s template of %d: %v", kind, user.ID, err)
+		output.Reset()
+	}
+	if err := getDefaultTemplate(user.Language, kind).Execute(&output, data); err != nil {
+		log.Printf("❌ Failed to render default %s template: %v", kind, err)
+	}
+	return output.String()
+}
+
+// Collect the data shown in a notification of an encounter for a user
+func buildNotificationData(user User, encounter EncounterData) NotificationData {
+	valueOf := func(value *int) int {
+		if value == nil {
+			return 0
+		}
+		return *value
+	}
+
+	data := NotificationData{
+		Language:        user.Language,
+		PokemonID:       encounter.PokemonID,
+		Atk:             valueOf(encounter.AtkIV),
+		Def:             valueOf(encounter.DefIV),
+		Sta:             valueOf(encounter.StaIV),
+		CP:              valueOf(encounter.CP),
+		Level:           valueOf(encounter.Level),
+		Move1:           valueOf(encounter.Move1),
+		Move2:           valueOf(encounter.Move2),
+		Gender:          genderMap[valueOf(encounter.Gender)],
+		Weather:         weatherMap[valueOf(encounter.Weather)],
+		ExpireTimestamp: valueOf(encounter.ExpireTimestamp),
+		Shiny:           encounter.Shiny != nil && *encounter.Shiny,
+		Lat:             encounter.Lat,
+		Lon:             encounter.Lon,
+	}
+	if encounter.IV != nil {
+		data.IV = *encounter.IV
+	}
+
+	// Retrieve form name (if applicable)
+	if encounter.Form != nil && *encounter.Form > 0 {
+		pkm := MasterFileData.Pokemon[strconv.Itoa(encounter.PokemonID)]
+		if form, exists := pkm.Forms[strconv.Itoa(*encounter.Form)]; exists && form.Name != "Normal" {
+			data.Form = getTranslation(form.Name, user.Language)
+			data.Costume = form.IsCostume
+		}
+	}
+
+	if encounter.Size != nil {
+		switch *encounter.Size {
+		case 1:
+			data.Size = "🔹"
+		case 5:
+			data.Size = "🔶"
+		}
+	}
+
+	if distance, ok := getUserDistance(user, encounter); ok {
+		data.HasDistance = true
+		data.Distance = distance
+		if travelTime, ok := getTravelTime(user, encounter); ok {
+			data.TravelMode = travelModeEmojis[user.TravelMode]
+			data.ArrivalTime = formatUserTime(user, time.Now().Add(travelTime))
+		}
+	}
+
+	data.ExpireTime = formatUserTime(user, time.Unix(int64(data.ExpireTimestamp), 0))
+
+	for league, entries := range encounter.PVPData {
+		// Capitalize the league name
+		leagueName := strings.ToUpper(string(league[0])) + league[1:]
+		for _, entry := range entries {
+			if entry.Rank < 4 {
+				data.PVP = append(data.PVP, PVPRank{
+					League:    getTranslation(leagueName+" League", user.Language),
+					Rank:      entry.Rank,
+					PokemonID: entry.Pokemon,
+					CP:        entry.CP,
+					Level:     entry.Level,
+				})
+			}
+		}
+	}
+
+	return data
+}
+
+// Get a sample encounter to preview and validate templates
+func getSampleEncounter() EncounterData {
+	intPtr := func(value int) *int { return &value }
+	iv := float32(100)
+	size := 5
+	expireTimestamp := int(time.Now().Add(25 * time.Minute).Unix())
+	return EncounterData{
+		ID:              "sample",
+		PokemonID:       25,
+		Lat:             52.5163,
+		Lon:             13.3777,
+		ExpireTimestamp: &expireTimestamp,
+		Move1:           intPtr(221),
+		Move2:           intPtr(79),
+		Gender:          intPtr(1),
+		CP:              intPtr(938),
+		AtkIV:           intPtr(15),
+		DefIV:           intPtr(15),
+		StaIV:           intPtr(15),
+		Level:           intPtr(35),
+		Weather:         intPtr(1),
+		Size:            &size,
+		IV:              &iv,
+		PVPData: PVP{
+			"great": {{Pokemon: 26, CP: 1488, Level: 20.5, Rank: 2}},
+		},
+	}
+}
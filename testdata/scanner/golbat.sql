@@ -0,0 +1,96 @@
+-- Rows of a Golbat scanner database, reduced to SQLite types
+CREATE TABLE pokemon (
+  id TEXT PRIMARY KEY,
+  pokestop_id TEXT,
+  spawn_id INTEGER,
+  lat REAL NOT NULL,
+  lon REAL NOT NULL,
+  weight REAL,
+  size INTEGER,
+  height REAL,
+  expire_timestamp INTEGER,
+  updated INTEGER,
+  pokemon_id INTEGER NOT NULL,
+  move_1 INTEGER,
+  move_2 INTEGER,
+  gender INTEGER,
+  cp INTEGER,
+  atk_iv INTEGER,
+  def_iv INTEGER,
+  sta_iv INTEGER,
+  golbat_internal BLOB,
+  iv REAL,
+  form INTEGER,
+  level INTEGER,
+  is_strong INTEGER,
+  weather INTEGER,
+  costume INTEGER,
+  first_seen_timestamp INTEGER NOT NULL,
+  changed INTEGER NOT NULL DEFAULT 0,
+  cell_id INTEGER,
+  expire_timestamp_verified INTEGER NOT NULL,
+  display_pokemon_id INTEGER,
+  is_ditto INTEGER NOT NULL DEFAULT 0,
+  seen_type TEXT,
+  shiny INTEGER,
+  username TEXT,
+  capture_1 REAL,
+  capture_2 REAL,
+  capture_3 REAL,
+  pvp TEXT,
+  is_event INTEGER NOT NULL DEFAULT 0
+);
+
+INSERT INTO pokemon VALUES ('1001', NULL, 1234567, 52.5163, 13.3777, 6.1, 3, 0.42, 1760781800, 1760779900, 25, 221, 79, 1, 938, 15, 15, 15, NULL, 100, 598, 35, 0, 1, 0, 1760779000, 1760779900, 5163345235634208768, 1, NULL, 0, 'encounter', 1, 'scanner01', 0.1, 0.2, 0.3, '{"great":[{"pokemon":26,"form":0,"cap":50,"cp":1488,"level":20.5,"percentage":0.99,"rank":2}]}', 0);
+INSERT INTO pokemon VALUES ('1002', 'a1b2c3d4.16', NULL, 52.5170, 13.3790, 4.0, 2, 0.3, 1760781000, 1760779950, 132, 242, 133, 3, 512, 15, 14, 8, NULL, 82.2222, 0, 22, 0, 1, 0, 1760779100, 1760779950, NULL, 0, 16, 1, 'encounter', 0, 'scanner02', NULL, NULL, NULL, NULL, 0);
+INSERT INTO pokemon VALUES ('1005', NULL, 7654321, 52.5180, 13.3800, NULL, NULL, NULL, 1760782000, 1760779950, 1, 214, 118, 2, 637, 10, 10, 10, NULL, 66.6667, 163, 20, 0, 3, 0, 1760779200, 1760779950, NULL, 1, NULL, 0, 'encounter', 0, 'scanner01', NULL, NULL, NULL, NULL, 0);
+-- Seen near a pokestop without IVs
+INSERT INTO pokemon VALUES ('1003', 'a1b2c3d4.16', NULL, 52.5171, 13.3791, NULL, NULL, NULL, 1760781000, 1760779960, 16, NULL, NULL, 1, NULL, NULL, NULL, NULL, NULL, NULL, 0, NULL, NULL, NULL, 0, 1760779960, 1760779960, NULL, 0, NULL, 0, 'nearby_stop', NULL, 'scanner02', NULL, NULL, NULL, NULL, 0);
+-- Despawned
+INSERT INTO pokemon VALUES ('1004', NULL, 1111111, 52.5190, 13.3810, NULL, NULL, NULL, 1760779000, 1760779970, 19, 221, 26, 1, 120, 7, 8, 7, NULL, 48.8889, 45, 10, 0, 1, 0, 1760778000, 1760779970, NULL, 1, NULL, 0, 'encounter', 0, 'scanner01', NULL, NULL, NULL, NULL, 0);
+
+CREATE TABLE gym (
+  id TEXT PRIMARY KEY,
+  lat REAL NOT NULL,
+  lon REAL NOT NULL,
+  name TEXT,
+  url TEXT,
+  last_modified_timestamp INTEGER,
+  raid_end_timestamp INTEGER,
+  raid_spawn_timestamp INTEGER,
+  raid_battle_timestamp INTEGER,
+  updated INTEGER NOT NULL,
+  raid_pokemon_id INTEGER,
+  guarding_pokemon_id INTEGER,
+  guarding_pokemon_display TEXT,
+  available_slots INTEGER,
+  team_id INTEGER,
+  raid_level INTEGER,
+  enabled INTEGER,
+  ex_raid_eligible INTEGER,
+  in_battle INTEGER,
+  raid_pokemon_move_1 INTEGER,
+  raid_pokemon_move_2 INTEGER,
+  raid_pokemon_form INTEGER,
+  raid_pokemon_alignment INTEGER,
+  raid_pokemon_cp INTEGER,
+  raid_is_exclusive INTEGER,
+  cell_id INTEGER,
+  deleted INTEGER NOT NULL DEFAULT 0,
+  total_cp INTEGER,
+  first_seen_timestamp INTEGER NOT NULL,
+  raid_pokemon_gender INTEGER,
+  sponsor_id INTEGER,
+  partner_id TEXT,
+  raid_pokemon_costume INTEGER,
+  raid_pokemon_evolution INTEGER,
+  ar_scan_eligible INTEGER,
+  power_up_level INTEGER,
+  power_up_points INTEGER,
+  power_up_end_timestamp INTEGER,
+  description TEXT
+);
+
+INSERT INTO gym VALUES ('f1e2d3c4.16', 52.516275, 13.377704, 'Brandenburger Tor', 'https://lh3.googleusercontent.com/gym', 1760779000, 1760782700, 1760776400, 1760780000, 1760779990, 150, 143, NULL, 2, 2, 5, 1, 1, 0, 234, 108, 135, 0, 54148, 0, 5163345235634208768, 0, 9000, 1700000000, 3, NULL, NULL, 0, 0, 1, 1, 100, 1761000000, 'Berlin landmark');
+INSERT INTO gym VALUES ('a9b8c7d6.16', 52.520815, 13.409419, 'Fernsehturm', NULL, 1760779000, NULL, NULL, NULL, 1760779990, NULL, 149, NULL, 6, 1, NULL, 1, 0, 0, NULL, NULL, NULL, NULL, NULL, NULL, NULL, 0, 0, 1700000000, NULL, NULL, NULL, NULL, NULL, 0, 0, 0, 0, NULL);
+INSERT INTO gym VALUES ('00000000.16', 52.5000, 13.3000, NULL, NULL, NULL, NULL, NULL, NULL, 1760779990, NULL, NULL, NULL, NULL, 0, NULL, 1, 0, 0, NULL, NULL, NULL, NULL, NULL, NULL, NULL, 0, 0, 1700000000, NULL, NULL, NULL, NULL, NULL, 0, 0, 0, 0, NULL);
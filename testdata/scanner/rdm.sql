@@ -0,0 +1,83 @@
+-- Rows of an RDM scanner database, reduced to SQLite types
+CREATE TABLE pokemon (
+  id TEXT PRIMARY KEY,
+  pokestop_id TEXT,
+  spawn_id INTEGER,
+  lat REAL NOT NULL,
+  lon REAL NOT NULL,
+  weight REAL,
+  size REAL,
+  expire_timestamp INTEGER,
+  updated INTEGER,
+  pokemon_id INTEGER NOT NULL,
+  move_1 INTEGER,
+  move_2 INTEGER,
+  gender INTEGER,
+  cp INTEGER,
+  atk_iv INTEGER,
+  def_iv INTEGER,
+  sta_iv INTEGER,
+  form INTEGER,
+  level INTEGER,
+  weather INTEGER,
+  costume INTEGER,
+  first_seen_timestamp INTEGER NOT NULL,
+  changed INTEGER NOT NULL DEFAULT 0,
+  cell_id INTEGER,
+  expire_timestamp_verified INTEGER NOT NULL,
+  shiny INTEGER,
+  username TEXT,
+  display_pokemon_id INTEGER,
+  capture_1 REAL,
+  capture_2 REAL,
+  capture_3 REAL,
+  pvp_rankings_great_league TEXT,
+  pvp_rankings_ultra_league TEXT,
+  is_event INTEGER NOT NULL DEFAULT 0
+);
+
+INSERT INTO pokemon VALUES ('9001', NULL, 2345678, 52.5163, 13.3777, 60.5, 1.61, 1760781800, 1760779900, 6, 269, 103, 1, 2289, 14, 15, 15, 178, 31, 1, 0, 1760779000, 1760779900, 5163345235634208768, 1, 0, 'device01', NULL, 0.15, 0.25, 0.35, '[{"pokemon":4,"form":896,"cp":1490,"level":21.5,"percentage":0.972,"rank":34},{"pokemon":5,"form":899,"cp":1497,"level":19.0,"percentage":0.981,"rank":12}]', '[{"pokemon":6,"form":178,"cp":2494,"level":26.5,"percentage":0.99,"rank":3}]', 0);
+INSERT INTO pokemon VALUES ('9002', 'c0ffee00.16', NULL, 52.5170, 13.3790, NULL, NULL, 1760781000, 1760779950, 132, 242, 133, 3, 620, 0, 0, 0, 0, 24, 3, 0, 1760779100, 1760779950, NULL, 0, 1, 'device02', 13, NULL, NULL, NULL, '', NULL, 1);
+-- Seen without IVs
+INSERT INTO pokemon VALUES ('9003', NULL, 3456789, 52.5171, 13.3791, NULL, NULL, 1760781000, 1760779960, 16, NULL, NULL, 1, NULL, NULL, NULL, NULL, 0, NULL, NULL, 0, 1760779960, 1760779960, NULL, 1, NULL, 'device02', NULL, NULL, NULL, NULL, NULL, NULL, 0);
+
+CREATE TABLE gym (
+  id TEXT PRIMARY KEY,
+  lat REAL NOT NULL,
+  lon REAL NOT NULL,
+  name TEXT,
+  url TEXT,
+  last_modified_timestamp INTEGER,
+  raid_end_timestamp INTEGER,
+  raid_spawn_timestamp INTEGER,
+  raid_battle_timestamp INTEGER,
+  updated INTEGER NOT NULL,
+  raid_pokemon_id INTEGER,
+  guarding_pokemon_id INTEGER,
+  availble_slots INTEGER,
+  team_id INTEGER,
+  raid_level INTEGER,
+  enabled INTEGER,
+  ex_raid_eligible INTEGER,
+  in_battle INTEGER,
+  raid_pokemon_move_1 INTEGER,
+  raid_pokemon_move_2 INTEGER,
+  raid_pokemon_form INTEGER,
+  raid_pokemon_cp INTEGER,
+  raid_is_exclusive INTEGER,
+  cell_id INTEGER,
+  deleted INTEGER NOT NULL DEFAULT 0,
+  total_cp INTEGER,
+  first_seen_timestamp INTEGER NOT NULL,
+  raid_pokemon_gender INTEGER,
+  sponsor_id INTEGER,
+  raid_pokemon_costume INTEGER,
+  raid_pokemon_evolution INTEGER,
+  ar_scan_eligible INTEGER,
+  power_up_level INTEGER,
+  power_up_points INTEGER,
+  power_up_end_timestamp INTEGER
+);
+
+INSERT INTO gym VALUES ('f1e2d3c4.16', 52.516275, 13.377704, 'Brandenburger Tor', NULL, 1760779000, NULL, NULL, NULL, 1760779990, NULL, 143, 4, 3, NULL, 1, 1, 0, NULL, NULL, NULL, NULL, NULL, 5163345235634208768, 0, 8000, 1700000000, NULL, NULL, NULL, NULL, 1, 0, 0, 0);
+INSERT INTO gym VALUES ('b2c3d4e5.16', 53.550341, 9.992196, 'Tor zur Welt', 'https://lh3.googleusercontent.com/port', 1760779000, 1760782000, 1760775000, 1760778600, 1760779990, 384, 68, 1, 1, 6, 1, 0, 1, 281, 295, 0, 60000, 0, NULL, 0, 11000, 1700000000, 0, NULL, 0, 0, 0, 0, 0, 0);
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ringsaturn/tzf"
+)
+
+var (
+	timezoneFinder      tzf.F
+	timezoneCache       = make(map[string]*time.Location)
+	timezoneCacheMutex  sync.Mutex
+	timeFormatsByLocale = map[string]string{
+		"en": "3:04:05 PM",
+	}
+)
+
+func loadTimezoneFinder() {
+	finder, err := tzf.NewDefaultFinder()
+	if err != nil {
+		log.Printf("❌ Failed to load timezone finder, timezones will not be detected: %v", err)
+		return
+	}
+	timezoneFinder = finder
+	log.Println("✅ Loaded timezone finder")
+}
+
+// Detect the timezone name of a location, empty if unknown
+func getTimezoneByLocation(lat float32, lon float32) string {
+	if timezoneFinder == nil || (lat == 0 && lon == 0) {
+		return ""
+	}
+	return timezoneFinder.GetTimezoneName(float64(lon), float64(lat))
+}
+
+// Load a timezone by name, falls back to the bot timezone if empty or unknown
+func loadTimezone(name string) *time.Location {
+	if name == "" {
+		return timezone
+	}
+	timezoneCacheMutex.Lock()
+	defer timezoneCacheMutex.Unlock()
+	if location, exists := timezoneCache[name]; exists {
+		return location
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("❌ Failed to load timezone %s: %v", name, err)
+		location = timezone
+	}
+	timezoneCache[name] = location
+	return location
+}
+
+// Get the timezone of a user: the one of an active live location, the configured one otherwise
+func getUserTimezone(user User) *time.Location {
+	if location, active := getLiveLocation(user.ID); active && location.Timezone != "" {
+		return loadTimezone(location.Timezone)
+	}
+	return loadTimezone(user.Timezone)
+}
+
+// Format a time of day in the timezone and locale of a user
+func formatUserTime(user User, t time.Time) string {
+	layout, exists := timeFormatsByLocale[user.Language]
+	if !exists {
+		layout = time.TimeOnly
+	}
+	return t.In(getUserTimezone(user)).Format(layout)
+}
+
+// Format a date and time of day in the timezone and locale of a user
+func formatUserDateTime(user User, t time.Time) string {
+	return t.In(getUserTimezone(user)).Format(time.DateOnly) + " " + formatUserTime(user, t)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"time"
+
+	"gopkg.in/telebot.v3"
+)
+
+// Default speeds (in km/h) for the available travel modes
+var travelModeSpeeds = map[string]int{
+	"walk": 5,
+	"bike": 15,
+}
+
+var travelModeEmojis = map[string]string{
+	"walk": "🚶",
+	"bike": "🚲",
+}
+
+// Get the travel speed of a user in km/h, 0 if travel time filtering is disabled
+func getTravelSpeed(user User) int {
+	if _, exists := travelModeSpeeds[user.TravelMode]; !exists {
+		return 0
+	}
+	if user.TravelSpeed > 0 {
+		return user.TravelSpeed
+	}
+	return travelModeSpeeds[user.TravelMode]
+}
+
+// Get the estimated time needed to travel from the user location to an encounter.
+// Returns false if the user has no location or no travel mode set.
+func getTravelTime(user User, encounter EncounterData) (time.Duration, bool) {
+	speed := getTravelSpeed(user)
+	if speed == 0 {
+		return 0, false
+	}
+	distance, ok := getUserDistance(user, encounter)
+	if !ok {
+		return 0, false
+	}
+	metersPerSecond := float64(speed) / 3.6
+	return time.Duration(distance / metersPerSecond * float64(time.Second)), true
+}
+
+// Helper function to check if the encounter can be reached before it despawns.
+// Returns true if the check passes or if no travel mode is set.
+func reachableInTime(user User, encounter EncounterData) bool {
+	if encounter.ExpireTimestamp == nil {
+		return true
+	}
+	travelTime, ok := getTravelTime(user, encounter)
+	if !ok {
+		return true
+	}
+	expireTime := time.Unix(int64(*encounter.ExpireTimestamp), 0)
+	return time.Now().Add(travelTime).Before(expireTime)
+}
+
+func getTravelModeName(travelMode string, language string) string {
+	switch travelMode {
+	case "walk":
+		return getTranslation("🚶 Walking", language)
+	case "bike":
+		return getTranslation("🚲 Cycling", language)
+	default:
+		return getTranslation("❌ Off", language)
+	}
+}
+
+func setTravelMode(c telebot.Context, travelMode string) error {
+	user := getUserPreferences(getUserID(c))
+	user.TravelMode = travelMode
+	updateUserPreference(user.ID, "TravelMode", user.TravelMode)
+	settingsMessage, replyMarkup := buildSettings(user)
+	return c.Edit(settingsMessage, replyMarkup, telebot.ModeHTML)
+}
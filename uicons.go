@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/telebot.v3"
+)
+
+const (
+	defaultUIconsURL        = "https://raw.githubusercontent.com/WatWowMap/wwm-uicons/main"
+	defaultUIconsStickerURL = "https://raw.githubusercontent.com/WatWowMap/wwm-uicons-webp/main"
+)
+
+var (
+	// Icons used for photos and maps, must be PNG or JPEG
+	uicons *UIcons
+	// Icons used for stickers, must be WebP
+	uiconsStickers *UIcons
+)
+
+// UIcons is an icon repository following the UICONS standard, either at a URL or a local path.
+// See https://github.com/UIcons/UIcons for the file naming and fallback rules.
+type UIcons struct {
+	Base      string
+	Extension string
+	// Available files per category, e.g. "pokemon" or "raid/egg"
+	index map[string]map[string]struct{}
+}
+
+// Load an icon repository and its index.json.
+// Without an index icons are resolved without fallbacks using the given extension.
+func loadUIcons(base string, extension string) *UIcons {
+	icons := &UIcons{Base: strings.TrimSuffix(base, "/"), Extension: extension}
+
+	data, err := icons.readIndex()
+	if err != nil {
+		log.Printf("⚠️ Failed to load UICONS index of %s, icons are used without fallbacks: %v", icons.Base, err)
+		return icons
+	}
+	var index map[string]interface{}
+	if err := json.Unmarshal(data, &index); err != nil {
+		log.Printf("⚠️ Failed to parse UICONS index of %s, icons are used without fallbacks: %v", icons.Base, err)
+		return icons
+	}
+	icons.index = make(map[string]map[string]struct{})
+	icons.addIndex("", index)
+
+	// Use the extension of the repository
+	for file := range icons.index["pokemon"] {
+		icons.Extension = strings.TrimPrefix(path.Ext(file), ".")
+		break
+	}
+	log.Printf("✅ Loaded UICONS index of %s with %d categories", icons.Base, len(icons.index))
+	return icons
+}
+
+func loadUIconsRepositories() {
+	base := os.Getenv("UICONS_URL")
+	if base == "" {
+		base = defaultUIconsURL
+	}
+	stickerBase := os.Getenv("UICONS_STICKER_URL")
+	if stickerBase == "" {
+		stickerBase = defaultUIconsStickerURL
+	}
+	uicons = loadUIcons(base, "png")
+	uiconsStickers = loadUIcons(stickerBase, "webp")
+}
+
+func (icons *UIcons) isLocal() bool {
+	return !strings.HasPrefix(icons.Base, "http://") && !strings.HasPrefix(icons.Base, "https://")
+}
+
+func (icons *UIcons) readIndex() ([]byte, error) {
+	if icons.isLocal() {
+		return os.ReadFile(filepath.Join(icons.Base, "index.json"))
+	}
+	return downloadFile(&http.Client{Timeout: tileHTTPTimeout}, icons.Base+"/index.json")
+}
+
+// Collect the file names of all categories, nested directories are nested objects in the index
+func (icons *UIcons) addIndex(category string, entries map[string]interface{}) {
+	for name, entry := range entries {
+		key := path.Join(category, name)
+		switch value := entry.(type) {
+		case []interface{}:
+			files := make(map[string]struct{}, len(value))
+			for _, file := range value {
+				if file, ok := file.(string); ok {
+					files[file] = struct{}{}
+				}
+			}
+			icons.index[key] = files
+		case map[string]interface{}:
+			icons.addIndex(key, value)
+		}
+	}
+}
+
+// Get the location of the first available candidate of a category.
+// Falls back to "0" which is the default icon of every category.
+func (icons *UIcons) resolve(category string, candidates []string) string {
+	files, indexed := icons.index[category]
+	if !indexed {
+		// Without an index only the basic icon is known to exist
+		return icons.location(category, candidates[len(candidates)-1])
+	}
+	for _, candidate := range candidates {
+		if _, exists := files[candidate+"."+icons.Extension]; exists {
+			return icons.location(category, candidate)
+		}
+	}
+	return icons.location(category, "0")
+}
+
+func (icons *UIcons) location(category string, name string) string {
+	if icons.isLocal() {
+		return filepath.Join(icons.Base, filepath.FromSlash(category), name+"."+icons.Extension)
+	}
+	return icons.Base + "/" + category + "/" + name + "." + icons.Extension
+}
+
+// Build all combinations of optional suffixes, from the most to the least specific one.
+// The last suffix is dropped first, empty suffixes are skipped.
+func buildIconCandidates(prefix string, suffixes ...string) []string {
+	if len(suffixes) == 0 {
+		return []string{prefix}
+	}
+	if suffixes[0] == "" {
+		return buildIconCandidates(prefix, suffixes[1:]...)
+	}
+	return append(buildIconCandidates(prefix+suffixes[0], suffixes[1:]...), buildIconCandidates(prefix, suffixes[1:]...)...)
+}
+
+func optionalSuffix(format string, value int) string {
+	if value <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(format, value)
+}
+
+// Get the icon of a Pokémon, falling back through evolution, form, costume, gender and shiny
+func (icons *UIcons) Pokemon(pokemonID int, evolution int, form int, costume int, gender int, shiny bool) string {
+	if icons.index == nil {
+		// Without an index only forms are used, like before UICONS indexes were supported
+		return icons.location("pokemon", strconv.Itoa(pokemonID)+getFormSuffix(pokemonID, form))
+	}
+	shinySuffix := ""
+	if shiny {
+		shinySuffix = "_s"
+	}
+	return icons.resolve("pokemon", buildIconCandidates(strconv.Itoa(pokemonID),
+		optionalSuffix("_e%d", evolution),
+		optionalSuffix("_f%d", form),
+		optionalSuffix("_c%d", costume),
+		optionalSuffix("_g%d", gender),
+		shinySuffix,
+	))
+}
+
+// Get the form suffix of a Pokémon icon, empty for the default form
+func getFormSuffix(pokemonID int, form int) string {
+	if form <= 0 {
+		return ""
+	}
+	if pkm, exists := MasterFileData.Pokemon[strconv.Itoa(pokemonID)]; exists {
+		if f, exists := pkm.Forms[strconv.Itoa(form)]; exists && f.Name != "Normal" {
+			return fmt.Sprintf("_f%d", form)
+		}
+	}
+	return ""
+}
+
+// Get the icon of a raid egg, falling back through hatched and EX
+func (icons *UIcons) RaidEgg(level int, hatched bool, ex bool) string {
+	hatchedSuffix, exSuffix := "", ""
+	if hatched {
+		hatchedSuffix = "_h"
+	}
+	if ex {
+		exSuffix = "_ex"
+	}
+	return icons.resolve("raid/egg", buildIconCandidates(strconv.Itoa(level), hatchedSuffix, exSuffix))
+}
+
+// Get the icon of an item reward, falling back through the amount
+func (icons *UIcons) Item(itemID int, amount int) string {
+	return icons.resolve("reward/item", buildIconCandidates(strconv.Itoa(itemID), optionalSuffix("_a%d", amount)))
+}
+
+// Get the icon of an invasion character
+func (icons *UIcons) Invasion(characterID int) string {
+	return icons.resolve("invasion", []string{strconv.Itoa(characterID)})
+}
+
+// Get the icon of the Pokémon of an encounter
+func getPokemonIcon(icons *UIcons, encounter EncounterData) string {
+	valueOf := func(value *int) int {
+		if value == nil {
+			return 0
+		}
+		return *value
+	}
+	shiny := encounter.Shiny != nil && *encounter.Shiny
+	// Wild Pokémon are never temporarily evolved
+	return icons.Pokemon(encounter.PokemonID, 0, valueOf(encounter.Form), valueOf(encounter.Costume), valueOf(encounter.Gender), shiny)
+}
+
+// Get a Telegram file for an icon location
+func getIconFile(location string) telebot.File {
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		return telebot.FromURL(location)
+	}
+	return telebot.FromDisk(location)
+}
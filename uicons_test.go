@@ -0,0 +1,65 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildIconCandidates(t *testing.T) {
+	candidates := buildIconCandidates("25", "_e1", "_f598", "", "_g2", "_s")
+	expected := []string{
+		"25_e1_f598_g2_s", "25_e1_f598_g2", "25_e1_f598_s", "25_e1_f598",
+		"25_e1_g2_s", "25_e1_g2", "25_e1_s", "25_e1",
+		"25_f598_g2_s", "25_f598_g2", "25_f598_s", "25_f598",
+		"25_g2_s", "25_g2", "25_s", "25",
+	}
+	if strings.Join(candidates, " ") != strings.Join(expected, " ") {
+		t.Errorf("candidates %v, expected %v", candidates, expected)
+	}
+	if candidates := buildIconCandidates("25"); len(candidates) != 1 || candidates[0] != "25" {
+		t.Errorf("candidates without suffixes %v", candidates)
+	}
+}
+
+func TestResolveIcons(t *testing.T) {
+	base := filepath.Join("testdata", "uicons")
+	icons := loadUIcons(base, "webp")
+	if icons.Extension != "png" {
+		t.Errorf("extension %s, expected the one of the index", icons.Extension)
+	}
+
+	tests := []struct {
+		name     string
+		icon     string
+		expected string
+	}{
+		{"shiny form", icons.Pokemon(25, 0, 598, 0, 0, true), "pokemon/25_f598_s.png"},
+		{"form without gender", icons.Pokemon(25, 0, 598, 0, 2, false), "pokemon/25_f598.png"},
+		{"shiny costume", icons.Pokemon(25, 0, 0, 5, 0, true), "pokemon/25_c5_s.png"},
+		{"costume without shiny", icons.Pokemon(25, 0, 0, 5, 0, false), "pokemon/25.png"},
+		{"evolution", icons.Pokemon(25, 1, 0, 0, 2, false), "pokemon/25_g2.png"},
+		{"unknown Pokémon", icons.Pokemon(150, 0, 0, 0, 0, false), "pokemon/0.png"},
+		{"hatched egg", icons.RaidEgg(5, true, false), "raid/egg/5_h.png"},
+		{"hatched EX egg", icons.RaidEgg(5, true, true), "raid/egg/5_h.png"},
+		{"unknown egg", icons.RaidEgg(3, false, false), "raid/egg/0.png"},
+		{"item amount", icons.Item(1, 5), "reward/item/1_a5.png"},
+		{"unknown item amount", icons.Item(1, 3), "reward/item/1.png"},
+		{"unknown item", icons.Item(2, 1), "reward/item/0.png"},
+		// Categories missing in the index use the basic icon
+		{"category without index", icons.Invasion(4), "invasion/4.png"},
+	}
+	for _, test := range tests {
+		if expected := filepath.Join(base, filepath.FromSlash(test.expected)); test.icon != expected {
+			t.Errorf("%s: icon %s, expected %s", test.name, test.icon, expected)
+		}
+	}
+}
+
+func TestResolveIconsWithoutIndex(t *testing.T) {
+	setupTemplateTest(t)
+	icons := loadUIcons("testdata/missing", "png")
+	if icon := icons.Pokemon(25, 0, 598, 0, 0, true); icon != filepath.Join("testdata", "missing", "pokemon", "25.png") {
+		t.Errorf("icon %s, expected the basic icon for an unknown form", icon)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strconv"
+
+	"gopkg.in/telebot.v3"
+)
+
+// Get a fingerprint of the encounter fields shown in a notification.
+// It changes when Golbat updates the encounter, e.g. after a weather change or when a Ditto is revealed.
+func getEncounterFingerprint(encounter EncounterData) string {
+	formatInt := func(value *int) string {
+		if value == nil {
+			return "-"
+		}
+		return strconv.Itoa(*value)
+	}
+	pvp := ""
+	if encounter.PVP != nil {
+		pvp = *encounter.PVP
+	}
+	hash := sha1.Sum([]byte(fmt.Sprintf("%d|%s|%s|%s|%s|%s|%s|%s|%s",
+		encounter.PokemonID,
+		formatInt(encounter.Form),
+		formatInt(encounter.AtkIV),
+		formatInt(encounter.DefIV),
+		formatInt(encounter.StaIV),
+		formatInt(encounter.CP),
+		formatInt(encounter.Level),
+		formatInt(encounter.Weather),
+		pvp,
+	)))
+	return hex.EncodeToString(hash[:])
+}
+
+// Get the stored messages of a notification, optionally only the ones of the given kinds
+func getNotificationMessages(chatID int64, encounterID string, kinds ...string) []Message {
+	// Make sure recently sent messages are stored
+	flushBookkeeping()
+	var messages []Message
+	query := dbConfig.Where("chat_id = ? AND encounter_id = ?", chatID, encounterID)
+	if len(kinds) > 0 {
+		query = query.Where("kind IN ?", kinds)
+	}
+	query.Find(&messages)
+	return messages
+}
+
+// Update the text of a notification after the encounter has changed
+func updateEncounterNotification(user User, encounter EncounterData, fingerprint string) {
+	sentNotifications.Set(encounter, user.ID, fingerprint)
+	// ntfy pushes can neither be edited nor removed, every update would be another push
+	if user.Notifier == "ntfy" {
+		log.Printf("🔕 Skipping update of notification for Pokémon #%d to %d (ntfy)", encounter.PokemonID, user.ID)
+		return
+	}
+	log.Printf("✏️ Updating notification for Pokémon #%d to %d", encounter.PokemonID, user.ID)
+
+	delivery := Delivery{
+		EncounterID: encounter.ID,
+		Expiration:  int64(*encounter.ExpireTimestamp),
+		Priority:    getNotificationPriority(user, encounter),
+		Silent:      true,
+	}
+	notificationTitle, notificationText := buildEncounterNotification(user, encounter)
+
+	// Other notifiers replace their messages
+	if isExternal(user) {
+		deleteExternalMessages(user.ID, encounter.ID, false)
+		notification := Notification{Encounter: encounter, Title: notificationTitle, Text: notificationText, Delivery: delivery}
+		if err := sendNotification(user, notification); err != nil {
+			log.Printf("❌ Failed to send notification for Pokémon #%d to %d: %v", encounter.PokemonID, user.ID, err)
+		}
+		return
+	}
+
+	for _, message := range getNotificationMessages(user.ID, encounter.ID, "message", "venue", "photo") {
+		if message.Kind == "photo" {
+			// Replace the photo as well, the Pokémon or its form may have changed
+			caption := notificationTitle + "\n" + notificationText
+			if !fitsCaption(caption) {
+				caption = ""
+			}
+			deliveryQueue.Enqueue(&OutboundMessage{
+				ChatID:    user.ID,
+				What:      &telebot.Photo{Caption: caption},
+				Options:   &telebot.SendOptions{ParseMode: telebot.ModeHTML, ReplyMarkup: buildOpenMapButton(user, encounter)},
+				Kind:      "edit",
+				Delivery:  delivery,
+				Edit:      &telebot.StoredMessage{MessageID: strconv.Itoa(message.MessageID), ChatID: message.ChatID},
+				PhotoFile: func() telebot.File { return getNotificationPhoto(user, encounter) },
+			})
+			continue
+		}
+		if message.Kind == "message" {
+			deliveryQueue.Enqueue(&OutboundMessage{
+				ChatID:   user.ID,
+				What:     notificationTitle + "\n" + notificationText,
+				Options:  &telebot.SendOptions{ParseMode: telebot.ModeHTML},
+				Kind:     "edit",
+				Delivery: delivery,
+				Edit:     &telebot.StoredMessage{MessageID: strconv.Itoa(message.MessageID), ChatID: message.ChatID},
+			})
+			continue
+		}
+		// Venues can not be edited, replace them instead
+		if err := bot.Delete(&telebot.StoredMessage{MessageID: strconv.Itoa(message.MessageID), ChatID: message.ChatID}); err != nil {
+			log.Printf("❌ Failed to delete message %d for user %d: %v", message.MessageID, message.ChatID, err)
+		}
+		dbConfig.Delete(&message)
+		deliveryQueue.Enqueue(newVenueMessage(user.ID, encounter.Lat, encounter.Lon, plainText(notificationTitle), plainText(notificationText), delivery))
+	}
+}
+
+// Delete a notification of an encounter that does not match the filters of the user anymore
+func retractEncounterNotification(userID int64, encounter EncounterData) {
+	log.Printf("🗑️ Retracting notification for Pokémon #%d to %d (not matching anymore)", encounter.PokemonID, userID)
+	sentNotifications.Delete(encounter.ID, userID)
+	deleteExternalMessages(userID, encounter.ID, false)
+
+	for _, message := range getNotificationMessages(userID, encounter.ID) {
+		// Digests list other Pokémon as well
+		if message.Kind == "digest" {
+			continue
+		}
+		if err := bot.Delete(&telebot.StoredMessage{MessageID: strconv.Itoa(message.MessageID), ChatID: message.ChatID}); err != nil {
+			log.Printf("❌ Failed to delete message %d for user %d: %v", message.MessageID, message.ChatID, err)
+		}
+		dbConfig.Delete(&message)
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	webhookQueueSize   = 1000
+	webhookWorkers     = 2
+	webhookMaxAttempts = 5
+	// Retries wait 10s, 20s, 40s and 80s
+	webhookRetryDelay = 10 * time.Second
+	// Header with the HMAC-SHA256 of the request body, keyed with the secret of the subscriber
+	webhookSignatureHeader = "X-PoGoBot-Signature"
+)
+
+// WebhookSubscriber receives matched encounters as signed JSON requests
+type WebhookSubscriber struct {
+	ID     uint   `gorm:"primaryKey"`
+	URL    string `gorm:"not null;size:255"`
+	Secret string `gorm:"not null;size:64"`
+	ChatID int64  `gorm:"not null;default:0"` // Only matches of this chat, 0 for all chats
+}
+
+// WebhookDeadLetter is a webhook request that failed after all attempts
+type WebhookDeadLetter struct {
+	ID           uint   `gorm:"primaryKey"`
+	SubscriberID uint   `gorm:"index;not null"`
+	Payload      string `gorm:"not null;type:text"`
+	Error        string `gorm:"not null;size:255"`
+	Attempts     int    `gorm:"not null;default:0"`
+	FailedAt     int64  `gorm:"not null"`
+}
+
+// WebhookDelivery is a request waiting in the webhook queue
+type WebhookDelivery struct {
+	Subscriber WebhookSubscriber
+	Body       []byte
+	Attempts   int
+}
+
+// Matches already published per encounter, to send each match only once per change of the encounter
+type publishedMatch struct {
+	Expiration   int
+	Fingerprints map[int64]string
+}
+
+var (
+	webhookSubscribers []WebhookSubscriber
+	webhookMutex       sync.RWMutex
+	webhookQueue       = make(chan *WebhookDelivery, webhookQueueSize)
+	webhookClient      = &http.Client{Timeout: 10 * time.Second}
+	publishedMatches   = make(map[string]*publishedMatch)
+
+	webhookRequestsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "bot_webhook_requests_total",
+			Help: "Total number of webhook requests by result",
+		},
+		[]string{"result"},
+	)
+)
+
+func getWebhookSubscribers() {
+	var subscribers []WebhookSubscriber
+	dbConfig.Order("id").Find(&subscribers)
+	webhookMutex.Lock()
+	webhookSubscribers = subscribers
+	webhookMutex.Unlock()
+}
+
+func addWebhookSubscriber(url string, chatID int64) (WebhookSubscriber, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return WebhookSubscriber{}, err
+	}
+	subscriber := WebhookSubscriber{URL: url, Secret: hex.EncodeToString(secret), ChatID: chatID}
+	if err := dbConfig.Create(&subscriber).Error; err != nil {
+		return WebhookSubscriber{}, err
+	}
+	getWebhookSubscribers()
+	return subscriber, nil
+}
+
+// Remove a subscriber and its dead letters, returns false if it does not exist
+func removeWebhookSubscriber(id uint) bool {
+	if dbConfig.Delete(&WebhookSubscriber{}, id).RowsAffected == 0 {
+		return false
+	}
+	dbConfig.Where("subscriber_id = ?", id).Delete(&WebhookDeadLetter{})
+	getWebhookSubscribers()
+	return true
+}
+
+// Build the webhook payload of a match
+func buildWebhookPayload(user User, encounter EncounterData, rule MatchRule) WebhookPayload {
+	recipient := WebhookRecipient{ChatID: user.ID, Notifier: user.Notifier, Language: user.Language}
+	if recipient.Notifier == "" {
+		recipient.Notifier = "telegram"
+	}
+	if distance, ok := getUserDistance(user, encounter); ok {
+		recipient.Distance = &distance
+	}
+	return WebhookPayload{
+		Version:   webhookPayloadVersion,
+		Type:      "match",
+		Timestamp: time.Now().Unix(),
+		Encounter: WebhookEncounter{
+			ID:              encounter.ID,
+			PokemonID:       encounter.PokemonID,
+			PokemonName:     getPokemonName(encounter.PokemonID, "en"),
+			Form:            encounter.Form,
+			Costume:         encounter.Costume,
+			Gender:          encounter.Gender,
+			Shiny:           encounter.Shiny,
+			Lat:             encounter.Lat,
+			Lon:             encounter.Lon,
+			IV:              encounter.IV,
+			AtkIV:           encounter.AtkIV,
+			DefIV:           encounter.DefIV,
+			StaIV:           encounter.StaIV,
+			CP:              encounter.CP,
+			Level:           encounter.Level,
+			Move1:           encounter.Move1,
+			Move2:           encounter.Move2,
+			Size:            encounter.Size,
+			Weather:         encounter.Weather,
+			ExpireTimestamp: *encounter.ExpireTimestamp,
+			ExpireVerified:  encounter.ExpireTimestampVerified,
+			PVP:             encounter.PVPData,
+			MapURL:          getMapLink(encounter.Lat, encounter.Lon),
+		},
+		Rule:      rule,
+		Recipient: recipient,
+	}
+}
+
+// Send a match to all webhook subscribers, unless it has been sent with the same encounter data before
+func publishMatch(user User, encounter EncounterData, rule MatchRule) {
+	webhookMutex.RLock()
+	subscribers := webhookSubscribers
+	webhookMutex.RUnlock()
+	if len(subscribers) == 0 {
+		return
+	}
+
+	fingerprint := getEncounterFingerprint(encounter)
+	published, exists := publishedMatches[encounter.ID]
+	if !exists {
+		published = &publishedMatch{Expiration: *encounter.ExpireTimestamp, Fingerprints: make(map[int64]string)}
+		publishedMatches[encounter.ID] = published
+	}
+	if published.Fingerprints[user.ID] == fingerprint {
+		return
+	}
+	published.Expiration = *encounter.ExpireTimestamp
+	published.Fingerprints[user.ID] = fingerprint
+
+	body, err := json.Marshal(buildWebhookPayload(user, encounter, rule))
+	if err != nil {
+		log.Printf("❌ Failed to encode webhook payload for encounter %s: %v", encounter.ID, err)
+		return
+	}
+	for _, subscriber := range subscribers {
+		if subscriber.ChatID == 0 || subscriber.ChatID == user.ID {
+			enqueueWebhook(&WebhookDelivery{Subscriber: subscriber, Body: body})
+		}
+	}
+}
+
+// Forget published matches of expired encounters
+func prunePublishedMatches() {
+	now := int(time.Now().Unix())
+	for encounterID, published := range publishedMatches {
+		if published.Expiration < now {
+			delete(publishedMatches, encounterID)
+		}
+	}
+}
+
+// Add a request to the webhook queue, it is stored as dead letter if the queue is full
+func enqueueWebhook(delivery *WebhookDelivery) {
+	select {
+	case webhookQueue <- delivery:
+	default:
+		storeDeadLetter(delivery, fmt.Errorf("webhook queue is full"))
+	}
+}
+
+func startWebhookWorkers() {
+	for i := 0; i < webhookWorkers; i++ {
+		go func() {
+			for delivery := range webhookQueue {
+				deliverWebhook(delivery)
+			}
+		}()
+	}
+}
+
+// Send a webhook request, failed requests are retried with exponential backoff
+func deliverWebhook(delivery *WebhookDelivery) {
+	delivery.Attempts++
+	retryable, err := postWebhook(delivery.Subscriber, delivery.Body)
+	if err == nil {
+		webhookRequestsCounter.WithLabelValues("success").Inc()
+		return
+	}
+	if !retryable || delivery.Attempts >= webhookMaxAttempts {
+		webhookRequestsCounter.WithLabelValues("failed").Inc()
+		storeDeadLetter(delivery, err)
+		return
+	}
+	webhookRequestsCounter.WithLabelValues("retry").Inc()
+	delay := webhookRetryDelay << (delivery.Attempts - 1)
+	log.Printf("⏳ Webhook %d failed (attempt %d), retrying in %s: %v", delivery.Subscriber.ID, delivery.Attempts, delay, err)
+	time.AfterFunc(delay, func() { enqueueWebhook(delivery) })
+}
+
+// Get the signature of a request body
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Post a payload to a subscriber, returns whether a failed request should be retried
+func postWebhook(subscriber WebhookSubscriber, body []byte) (bool, error) {
+	request, err := http.NewRequest(http.MethodPost, subscriber.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "PoGoBot")
+	request.Header.Set(webhookSignatureHeader, signWebhook(subscriber.Secret, body))
+	response, err := webhookClient.Do(request)
+	if err != nil {
+		return true, err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024))
+
+	if response.StatusCode < 300 {
+		return false, nil
+	}
+	// Other client errors will not go away by retrying
+	retryable := response.StatusCode >= 500 || response.StatusCode == http.StatusRequestTimeout || response.StatusCode == http.StatusTooManyRequests
+	return retryable, fmt.Errorf("webhook returned %s", response.Status)
+}
+
+func storeDeadLetter(delivery *WebhookDelivery, err error) {
+	log.Printf("❌ Webhook %d failed after %d attempts: %v", delivery.Subscriber.ID, delivery.Attempts, err)
+	message := err.Error()
+	if len(message) > 255 {
+		message = message[:255]
+	}
+	deadLetter := WebhookDeadLetter{
+		SubscriberID: delivery.Subscriber.ID,
+		Payload:      string(delivery.Body),
+		Error:        message,
+		Attempts:     delivery.Attempts,
+		FailedAt:     time.Now().Unix(),
+	}
+	if err := dbConfig.Create(&deadLetter).Error; err != nil {
+		log.Printf("❌ Failed to store dead letter of webhook %d: %v", delivery.Subscriber.ID, err)
+	}
+}
+
+// Queue all dead letters again, returns the number of requests queued
+func retryDeadLetters() int {
+	var deadLetters []WebhookDeadLetter
+	dbConfig.Order("id").Find(&deadLetters)
+
+	webhookMutex.RLock()
+	subscribers := make(map[uint]WebhookSubscriber, len(webhookSubscribers))
+	for _, subscriber := range webhookSubscribers {
+		subscribers[subscriber.ID] = subscriber
+	}
+	webhookMutex.RUnlock()
+
+	queued := 0
+	for _, deadLetter := range deadLetters {
+		if subscriber, exists := subscribers[deadLetter.SubscriberID]; exists {
+			enqueueWebhook(&WebhookDelivery{Subscriber: subscriber, Body: []byte(deadLetter.Payload)})
+			queued++
+		}
+		dbConfig.Delete(&deadLetter)
+	}
+	return queued
+}
+
+// Count the dead letters per subscriber
+func countDeadLetters() map[uint]int {
+	var counts []struct {
+		SubscriberID uint
+		Count        int
+	}
+	dbConfig.Model(&WebhookDeadLetter{}).Select("subscriber_id, count(*) as count").Group("subscriber_id").Scan(&counts)
+	result := make(map[uint]int, len(counts))
+	for _, count := range counts {
+		result[count.SubscriberID] = count.Count
+	}
+	return result
+}